@@ -0,0 +1,132 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/joelanford/goscan/utils/cache"
+	"github.com/joelanford/goscan/utils/keywords"
+	"github.com/pkg/errors"
+)
+
+// defaultCacheFile returns the scan cache file in the user's cache
+// directory, or in the temporary directory if they don't have one.
+func defaultCacheFile() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "goscan", "cache.jsonl")
+}
+
+func parseCacheFlags(args []string) (*Opts, error) {
+	if len(args) == 0 {
+		return nil, errors.New("usage: goscan cache <stats|prune> [options]")
+	}
+
+	var policies string
+	var opts Opts
+	fs := flag.NewFlagSet("goscan cache "+args[0], flag.ExitOnError)
+	fs.StringVar(&opts.CacheFile, "cache.file", defaultCacheFile(), "Scan cache file")
+	switch args[0] {
+	case "stats":
+		opts.Command = "cache stats"
+		fs.Usage = func() {
+			fmt.Printf("Usage: goscan cache stats [options]\n")
+			fs.PrintDefaults()
+		}
+	case "prune":
+		opts.Command = "cache prune"
+		fs.Usage = func() {
+			fmt.Printf("Usage: goscan cache prune [options]\n")
+			fs.PrintDefaults()
+		}
+		fs.DurationVar(&opts.CacheAge, "age", 0, "Remove entries older than this (0 to keep entries of any age)")
+		fs.StringVar(&opts.KeywordsFile, "words", "", "Remove entries for keywords other than the ones in this YAML keywords file")
+		fs.StringVar(&policies, "policies", "all", "Comma-separated list of keyword policies used with words")
+	default:
+		return nil, errors.Errorf("unknown cache command %q", args[0])
+	}
+	fs.Parse(args[1:])
+
+	if fs.NArg() != 0 {
+		return nil, errors.New("unexpected arguments")
+	}
+	if opts.CacheAge < 0 {
+		return nil, errors.New("age must be >= 0")
+	}
+	if policies != "all" {
+		opts.Policies = strings.Split(policies, ",")
+	}
+	return &opts, nil
+}
+
+func runCacheStats(opts *Opts) error {
+	c, err := cache.Open(opts.CacheFile)
+	if err != nil {
+		return errors.Wrapf(err, "error opening scan cache")
+	}
+	s, err := c.Stats()
+	if err != nil {
+		return errors.Wrapf(err, "error reading scan cache")
+	}
+
+	fmt.Printf("Cache file: %s\n", s.File)
+	fmt.Printf("Size:       %d bytes\n", s.Size)
+	fmt.Printf("Entries:    %d\n", s.Entries)
+	fmt.Printf("Results:    %d\n", s.Results)
+	if s.Entries > 0 {
+		fmt.Printf("Oldest:     %s\n", s.Oldest.Format(time.RFC3339))
+		fmt.Printf("Newest:     %s\n", s.Newest.Format(time.RFC3339))
+	}
+
+	fingerprints := make([]string, 0, len(s.Keywords))
+	for fp := range s.Keywords {
+		fingerprints = append(fingerprints, fp)
+	}
+	sort.Strings(fingerprints)
+	if len(fingerprints) > 0 {
+		fmt.Printf("\nEntries by keyword fingerprint:\n")
+	}
+	for _, fp := range fingerprints {
+		fmt.Printf("  %s  %d\n", fp, s.Keywords[fp])
+	}
+	return nil
+}
+
+// runCachePrune removes old entries, and entries for keywords other than
+// the current ones, from the scan cache.
+func runCachePrune(opts *Opts) error {
+	var fingerprint string
+	if opts.KeywordsFile != "" {
+		kw, err := keywords.LoadFile(opts.KeywordsFile, opts.Policies)
+		if err != nil {
+			return errors.Wrapf(err, "error loading keywords")
+		}
+		fingerprint = kw.Fingerprint()
+	}
+
+	c, err := cache.Open(opts.CacheFile)
+	if err != nil {
+		return errors.Wrapf(err, "error opening scan cache")
+	}
+	cutoff := time.Now().Add(-opts.CacheAge)
+	keep := func(e *cache.Entry) bool {
+		if opts.CacheAge > 0 && e.Created.Before(cutoff) {
+			return false
+		}
+		return fingerprint == "" || e.Keywords == fingerprint
+	}
+
+	n, err := c.Prune(keep)
+	if err != nil {
+		return errors.Wrapf(err, "error pruning scan cache")
+	}
+	fmt.Fprintf(os.Stderr, "Removed %d cache entries\n", n)
+	return nil
+}
@@ -12,6 +12,9 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/joelanford/goscan/utils/archive"
+	"github.com/joelanford/goscan/utils/baseline"
+	"github.com/joelanford/goscan/utils/cache"
 	"github.com/joelanford/goscan/utils/keywords"
 	"github.com/joelanford/goscan/utils/output"
 	"github.com/joelanford/goscan/utils/scanner"
@@ -20,6 +23,7 @@ import (
 )
 
 type Opts struct {
+	Command       string
 	BaseDir       string
 	InputFile     string
 	KeywordsFile  string
@@ -28,28 +32,152 @@ type Opts struct {
 	HitsOnly      bool
 	ResultsFile   string
 	ResultsFormat string
+	ResultsTmpl   string
 	Parallelism   int
+	RamdiskEnable bool
+	RamdiskSize   int
+	MemoryBudget  int
+	MemoryNoSpill bool
+	QuotaSize     int
+	QuotaMinFree  int
+	QuotaPause    bool
+	CopyInput     bool
+	StdinName     string
+	KeywordsWatch time.Duration
+	Encrypt       bool
+	KeepScratch   bool
+	Dedup         bool
+	Native        bool
+	Metadata      bool
+	LegacyHashes  bool
+	FailFast      bool
+	Cache         bool
+	CacheFile     string
+	Progress      string
+	Git           bool
+	GitRevs       []string
+	GitDiff       string
+	GitStaged     bool
+	FailOnHits    bool
+	Baseline      string
+	HookForce     bool
+	DiffOld       string
+
+	UnarchiveTimeout time.Duration
+	ProgressInterval time.Duration
+
+	ScratchGCAge  time.Duration
+	ScratchGCKept bool
+	DryRun        bool
+	CacheAge      time.Duration
+
+	Listen      string
+	MaxBodySize int64
+	ScanTimeout time.Duration
+	JobTTL      time.Duration
+
+	ICAPAction    string
+	ICAPBlockPage string
+
+	WatchSink     string
+	WatchScans    int
+	WatchDebounce time.Duration
+	WatchPoll     time.Duration
+	WatchExisting bool
+	WatchMove     bool
+	CleanDir      string
+	QuarantineDir string
 }
 
+// ParseFlags parses the command line. Without a subcommand, or with the
+// scan subcommand, it parses the options of a scan.
 func ParseFlags() (*Opts, error) {
+	args := os.Args[1:]
+	command := ""
+	if len(args) > 0 {
+		switch args[0] {
+		case "scan":
+			args = args[1:]
+		case "baseline":
+			if len(args) < 2 || args[1] != "create" {
+				return nil, errors.New("usage: goscan baseline create [options] <scanfile|->")
+			}
+			command = "baseline create"
+			args = args[2:]
+		case "scratch":
+			return parseScratchFlags(os.Args[2:])
+		case "cache":
+			return parseCacheFlags(os.Args[2:])
+		case "serve":
+			return parseServeFlags(os.Args[2:])
+		case "icap":
+			return parseICAPFlags(os.Args[2:])
+		case "watch":
+			return parseWatchFlags(os.Args[2:])
+		case "hook":
+			return parseHookFlags(os.Args[2:])
+		case "diff":
+			return parseDiffFlags(os.Args[2:])
+		}
+	}
+
 	flag.Usage = func() {
-		fmt.Printf("Usage: goscan [options] <scanfiles>\n")
+		fmt.Printf("Usage: goscan [scan] [options] <scanfile|->\n")
+		fmt.Printf("       goscan scratch gc [options]\n")
+		fmt.Printf("       goscan scratch inspect <scratchdir>\n")
+		fmt.Printf("       goscan cache <stats|prune> [options]\n")
+		fmt.Printf("       goscan serve [options]\n")
+		fmt.Printf("       goscan icap [options]\n")
+		fmt.Printf("       goscan watch [options] <dir>\n")
+		fmt.Printf("       goscan hook install [options] [repo]\n")
+		fmt.Printf("       goscan baseline create [options] <scanfile|->\n")
+		fmt.Printf("       goscan diff [options] <old> <new>\n")
 		flag.PrintDefaults()
 	}
 
 	var policies string
-	var opts Opts
+	var gitRevs string
+	opts := Opts{Command: command}
 
 	flag.StringVar(&opts.BaseDir, "basedir", os.TempDir(), "Scratch directory for scan unarchiving")
 	flag.StringVar(&opts.KeywordsFile, "words", "", "YAML keywords file")
 	flag.IntVar(&opts.HitContext, "context", 10, "Context to capture around each hit")
-	flag.BoolVar(&opts.HitsOnly, "hitsonly", false, "Only output results containing hits")
+	flag.BoolVar(&opts.HitsOnly, "hitsonly", false, "Only output results containing hits or errors")
 	flag.StringVar(&policies, "policies", "all", "Comma-separated list of keyword policies")
 	flag.StringVar(&opts.ResultsFile, "output.file", "-", "Results output file (\"-\" for stdout)")
-	flag.StringVar(&opts.ResultsFormat, "output.format", "json", "Results output format")
+	flag.StringVar(&opts.ResultsFormat, "output.format", "json", "Results output format (json, yaml, csv, junit, template)")
+	flag.StringVar(&opts.ResultsTmpl, "output.template", "", "Go text/template file used when output.format is \"template\"")
 	flag.IntVar(&opts.Parallelism, "parallelism", runtime.NumCPU(), "Number of goroutines to use to scan files")
+	flag.BoolVar(&opts.RamdiskEnable, "ramdisk.enable", false, "Enable ramdisk scratch directory")
+	flag.IntVar(&opts.RamdiskSize, "ramdisk.size", 4096, "Size of ramdisk (in MB) to use as scratch space")
+	flag.IntVar(&opts.MemoryBudget, "scratch.memory", 0, "Keep up to this many MB of scratch files in memory (0 to keep them on disk)")
+	flag.BoolVar(&opts.MemoryNoSpill, "scratch.memory.nospill", false, "Never write scratch files to disk when scratch.memory is set")
+	flag.IntVar(&opts.QuotaSize, "scratch.quota", 0, "Maximum size (in MB) of the scratch space (0 for no limit)")
+	flag.IntVar(&opts.QuotaMinFree, "scratch.minfree", 0, "Minimum free space (in MB) to leave on the scratch filesystem (0 for no limit)")
+	flag.BoolVar(&opts.QuotaPause, "scratch.quota.pause", false, "Pause unarchiving until space is freed, rather than skipping archives, when the scratch quota is reached")
+	flag.BoolVar(&opts.Native, "unarchive.native", false, "Use built-in extractors for gzip, bzip2, tar and zip (always on with scratch.memory and scratch.encrypt)")
+	flag.BoolVar(&opts.Metadata, "metadata", false, "Include size, SHA-256, file type, mtime and mode of each file in results")
+	flag.BoolVar(&opts.LegacyHashes, "metadata.legacyhashes", false, "Also include MD5 and SHA-1 hashes in file metadata")
+	flag.BoolVar(&opts.Dedup, "dedup", false, "Scan and unarchive files with identical contents only once, at the cost of reading each file one more time to hash it")
+	flag.BoolVar(&opts.Cache, "cache", false, "Reuse the results of files and archives scanned before with the same keywords and options")
+	flag.StringVar(&opts.CacheFile, "cache.file", defaultCacheFile(), "Scan cache file")
+	flag.BoolVar(&opts.FailFast, "fail-fast", false, "Stop scanning at the first file that can't be read")
+	flag.BoolVar(&opts.KeepScratch, "keep-scratch", false, "Keep the scratch directory and write a manifest of its files, for debugging")
+	flag.BoolVar(&opts.Encrypt, "scratch.encrypt", false, "Encrypt scratch files on disk with a per-scan key (implies unarchive.native)")
+	flag.StringVar(&opts.StdinName, "stdin-name", "", "Name of the file read from stdin in results (default \"stdin\" with an extension for its type)")
+	flag.BoolVar(&opts.CopyInput, "scratch.copyinput", false, "Always copy the input file into the scratch space, rather than linking it or reading it in place")
+	flag.DurationVar(&opts.ScratchGCAge, "scratch.gc.age", time.Hour, "Remove stale scratch directories older than this at startup (0 to disable)")
+	flag.DurationVar(&opts.UnarchiveTimeout, "unarchive.timeout", 0, "Maximum time to spend unarchiving a single archive (0 for no limit)")
+	flag.BoolVar(&opts.Git, "git", false, "Scan the history of the git repository at the scan path, rather than its files")
+	flag.StringVar(&gitRevs, "git.revs", "", "Comma-separated refs, commits and ranges (a..b, ^a) of the history to scan with git (default all refs and HEAD)")
+	flag.StringVar(&opts.GitDiff, "diff", "", "Only scan the lines added, and files added, in a range of commits (base..head, base...head, or base for base..HEAD) of the git repository at the scan path")
+	flag.BoolVar(&opts.GitStaged, "staged", false, "Only scan the lines added, and files added, in the changes staged to be committed to the git repository at the scan path")
+	flag.BoolVar(&opts.FailOnHits, "fail-on-hits", false, "Exit with an error if there are any hits, or any new hits with baseline")
+	flag.StringVar(&opts.Baseline, "baseline", "", "Baseline file to mark hits as new, existing or fixed against, or to write with baseline create")
+	flag.StringVar(&opts.Progress, "progress", "auto", "Show scan progress on stderr (auto, tty, json, none)")
+	flag.DurationVar(&opts.ProgressInterval, "progress.interval", 0, "How often to show scan progress (0 for 500ms on a terminal, 10s for json)")
 
-	flag.Parse()
+	flag.CommandLine.Parse(args)
 
 	if opts.KeywordsFile == "" {
 		return nil, errors.New("words file must be defined")
@@ -59,6 +187,10 @@ func ParseFlags() (*Opts, error) {
 		return nil, errors.New("context must not be >= 0")
 	}
 
+	if opts.ResultsFormat == "template" && opts.ResultsTmpl == "" {
+		return nil, errors.New("output.template must be defined when output.format is template")
+	}
+
 	if policies == "all" {
 		opts.Policies = nil
 	} else {
@@ -69,26 +201,87 @@ func ParseFlags() (*Opts, error) {
 		return nil, errors.New("parallelism must be > 0")
 	}
 
-	if len(flag.Args()) != 1 {
+	if opts.RamdiskEnable && opts.RamdiskSize < 1 {
+		return nil, errors.New("ramdisk size must be > 0")
+	}
+
+	if opts.MemoryBudget < 0 {
+		return nil, errors.New("scratch memory must be >= 0")
+	}
+
+	if opts.MemoryNoSpill && opts.MemoryBudget == 0 {
+		return nil, errors.New("scratch.memory must be set to use scratch.memory.nospill")
+	}
+
+	if opts.QuotaSize < 0 || opts.QuotaMinFree < 0 {
+		return nil, errors.New("scratch quota and minimum free space must be >= 0")
+	}
+
+	switch opts.Progress {
+	case "auto", "tty", "json", "none":
+	default:
+		return nil, errors.New("progress must be one of auto, tty, json or none")
+	}
+
+	if opts.ProgressInterval < 0 {
+		return nil, errors.New("progress interval must be >= 0")
+	}
+
+	if opts.KeepScratch && (opts.QuotaSize > 0 || opts.QuotaMinFree > 0) {
+		return nil, errors.New("keep-scratch can't be used with a scratch quota, which removes scanned files")
+	}
+
+	if opts.Command == "baseline create" && opts.Baseline == "" {
+		return nil, errors.New("baseline must be defined to create one")
+	}
+
+	diff := opts.GitDiff != "" || opts.GitStaged
+	if diff && flag.NArg() == 0 {
+		opts.InputFile = "."
+	} else if len(flag.Args()) != 1 {
 		return nil, errors.New("must define exactly one file to scan")
+	} else {
+		opts.InputFile = flag.Arg(0)
+	}
+
+	opts.GitRevs = parseGitRevs(gitRevs)
+	if len(opts.GitRevs) > 0 && !opts.Git {
+		return nil, errors.New("git must be set to use git.revs")
+	}
+	if opts.GitDiff != "" && opts.GitStaged {
+		return nil, errors.New("diff and staged can't be used together")
+	}
+	if opts.Git && diff {
+		return nil, errors.New("git can't be used with diff or staged")
+	}
+	if (opts.Git || diff) && opts.InputFile == "-" {
+		return nil, errors.New("git repositories can't be read from stdin")
 	}
-	opts.InputFile = flag.Arg(0)
 	return &opts, nil
 }
 
 func Run(opts *Opts) error {
-	//
-	// Setup output formatter
-	//
-	var w output.SummaryWriter
-	switch opts.ResultsFormat {
-	case "json":
-		w = output.NewJSONSummaryWriter(os.Stdout, "", "  ")
-	case "yaml":
-		w = output.NewYAMLSummaryWriter(os.Stdout)
-	default:
-		return errors.New("invalid results format")
+	switch opts.Command {
+	case "scratch gc":
+		return runScratchGC(opts)
+	case "scratch inspect":
+		return runScratchInspect(opts)
+	case "cache stats":
+		return runCacheStats(opts)
+	case "cache prune":
+		return runCachePrune(opts)
+	case "serve":
+		return runServe(opts)
+	case "icap":
+		return runICAP(opts)
+	case "watch":
+		return runWatch(opts)
+	case "hook install":
+		return runHookInstall(opts)
+	case "diff":
+		return runDiff(opts)
 	}
+	create := opts.Command == "baseline create"
 
 	sum := output.ScanSummary{
 		InputFile: opts.InputFile,
@@ -111,88 +304,273 @@ func Run(opts *Opts) error {
 	}
 
 	//
-	// Open the output file
+	// Load the baseline to compare hits to
 	//
-	var outputFile io.WriteCloser
-	if opts.ResultsFile == "-" {
-		outputFile = os.Stdout
-	} else {
-		outputFile, err = os.Create(opts.ResultsFile)
+	var m *baseline.Matcher
+	if opts.Baseline != "" && !create {
+		b, err := baseline.Load(opts.Baseline)
 		if err != nil {
-			return errors.Wrapf(err, "error opening output file")
+			return err
+		}
+		if b.KeywordsFingerprint != "" && b.KeywordsFingerprint != kw.Fingerprint() {
+			fmt.Fprintf(os.Stderr, "Warning: baseline %s was created with different keywords\n", opts.Baseline)
 		}
+		m = b.Matcher()
+	}
+
+	//
+	// Open the output file and setup the output formatter. Creating a
+	// baseline writes no results, so it leaves the output file alone.
+	//
+	var w output.SummaryWriter
+	if !create {
+		var outputFile io.Closer
+		w, outputFile, err = summaryWriter(opts)
+		if err != nil {
+			return err
+		}
+		defer outputFile.Close()
+	}
+
+	//
+	// Clean up after scans that were killed before they could
+	//
+	if opts.ScratchGCAge > 0 {
+		removeStaleScratch(opts.BaseDir, opts.ScratchGCAge, false, false)
 	}
-	defer outputFile.Close()
 
 	//
 	// Prepare the scratch space
 	//
-	ss := scratch.New(opts.BaseDir)
+	var scratchOpts []scratch.Option
+	if opts.RamdiskEnable {
+		scratchOpts = append(scratchOpts, scratch.Ramdisk(opts.RamdiskSize))
+	}
+	if opts.MemoryBudget > 0 {
+		scratchOpts = append(scratchOpts, scratch.Memory(opts.MemoryBudget, !opts.MemoryNoSpill))
+	}
+	if opts.Encrypt {
+		scratchOpts = append(scratchOpts, scratch.Encrypt())
+	}
+	if opts.KeepScratch {
+		scratchOpts = append(scratchOpts, scratch.Keep())
+	}
+	if opts.QuotaSize > 0 || opts.QuotaMinFree > 0 {
+		scratchOpts = append(scratchOpts, scratch.WithQuota(opts.QuotaSize, opts.QuotaMinFree, opts.QuotaPause))
+	}
+	ss, err := scratch.New(opts.BaseDir, scratchOpts...)
+	if err != nil {
+		return errors.Wrapf(err, "failed to initialize scratch space")
+	}
 	err = ss.Setup()
 	if err != nil {
 		return errors.Wrapf(err, "scratch setup failed")
 	}
-	defer ss.Teardown()
+	defer func() {
+		if err := ss.Teardown(); err != nil {
+			fmt.Fprintf(os.Stderr, "error cleaning up scratch space: %s\n", err)
+		} else if opts.KeepScratch {
+			fmt.Fprintf(os.Stderr, "Scratch directory kept in %s\n", ss.Dir())
+		}
+	}()
 
 	//
-	// Copy input file into scratch space
+	// Open the scan cache, and add the new results to it once we're done
 	//
-	ifile, err := ss.CopyFile(opts.InputFile)
-	if err != nil {
-		return errors.Wrapf(err, "scratch file copy failed")
+	var c *cache.Cache
+	if opts.Cache {
+		c, err = cache.Open(opts.CacheFile)
+		if err != nil {
+			return errors.Wrapf(err, "error opening scan cache")
+		}
+		defer func() {
+			if err := c.Flush(); err != nil {
+				fmt.Fprintf(os.Stderr, "error writing scan cache: %s\n", err)
+			}
+		}()
 	}
 
-	scanResults := make(chan output.ScanResult)
-	errChan := make(chan error)
-	scanner, err := scanner.NewScanner(kw,
+	scanOpts := []scanner.Option{
 		scanner.BaseDir(opts.BaseDir),
 		scanner.HitContext(opts.HitContext),
 		scanner.HitsOnly(opts.HitsOnly),
 		scanner.Parallelism(opts.Parallelism),
-	)
+		scanner.Metadata(opts.Metadata),
+		scanner.LegacyHashes(opts.LegacyHashes),
+		scanner.FailFast(opts.FailFast),
+		scanner.UnarchiveTimeout(opts.UnarchiveTimeout),
+		scanner.NativeUnarchive(opts.Native || opts.MemoryBudget > 0 || opts.Encrypt),
+		scanner.Scratch(ss),
+		scanner.Dedup(opts.Dedup),
+		scanner.Cache(c),
+	}
+	if progress, interval := progressWriter(opts.Progress, opts.ProgressInterval, os.Stderr); progress != nil {
+		scanOpts = append(scanOpts, scanner.OnProgress(interval, progress))
+	}
+	s, err := scanner.NewScanner(kw, scanOpts...)
 	if err != nil {
 		return errors.Wrapf(err, "failed to initialize scanner")
 	}
 
-	err = scanner.ScanFile(ctx, ifile, scanResults, errChan)
+	var src scanner.Source
+	var repo *gitSource
+	switch {
+	case opts.Git:
+		repo = newGitSource(ctx, opts)
+		src = repo
+	case opts.GitDiff != "" || opts.GitStaged:
+		src = newDiffSource(opts)
+	default:
+		src, err = inputSource(opts)
+		if err != nil {
+			return err
+		}
+	}
+	sum.KeywordsFingerprint = kw.Fingerprint()
+
+	err = s.Scan(ctx, src, scanner.HandlerFunc(func(sr output.ScanResult) error {
+		if repo != nil {
+			repo.annotate(&sr)
+		}
+		if m != nil {
+			m.Mark(&sr)
+		}
+		sum.Add(sr, opts.HitsOnly)
+		return nil
+	}))
+	if err == context.Canceled {
+		return nil
+	}
 	if err != nil {
-		return errors.Wrapf(err, "failed scanning file %s", opts.InputFile)
+		return errors.Wrapf(err, "error scanning file %s", opts.InputFile)
+	}
+	sum.Stats.Duration = time.Now().Sub(start).Seconds()
+	if create {
+		return writeBaseline(opts.Baseline, sum)
 	}
+	if m != nil {
+		stats := m.Stats()
+		sum.Stats.Baseline = &stats
+		sum.Fixed = m.Fixed()
+	}
+	w.WriteSummary(sum)
+	if opts.FailOnHits {
+		switch {
+		case sum.Stats.Baseline != nil && sum.Stats.Baseline.New > 0:
+			return errors.Errorf("%d new hits found", sum.Stats.Baseline.New)
+		case sum.Stats.Baseline == nil && sum.Stats.TotalHits > 0:
+			return errors.Errorf("%d hits found in %d files", sum.Stats.TotalHits, sum.Stats.FilesHit)
+		}
+	}
+	return nil
+}
 
-	//
-	// Loop until error or all hits have been found
-	//
-	for {
-		select {
-		case err = <-errChan:
-			if err != context.Canceled {
-				return errors.Wrapf(err, "error scanning file")
-			}
-			return nil
-		case sr, ok := <-scanResults:
-			if !ok {
-				sum.Stats.Duration = time.Now().Sub(start).Seconds()
-				w.WriteSummary(sum)
-				return nil
-			}
-			sum.Stats.FilesScanned++
-			if !opts.HitsOnly || len(sr.Hits) > 0 {
-				sum.Results = append(sum.Results, sr)
-				if len(sr.Hits) > 0 {
-					sum.Stats.FilesHit++
-					sum.Stats.TotalHits += len(sr.Hits)
-				}
+// summaryWriter opens the output file of opts, and returns a writer of
+// summaries to it in the output format of opts.
+func summaryWriter(opts *Opts) (output.SummaryWriter, io.Closer, error) {
+	var outputFile io.WriteCloser
+	if opts.ResultsFile == "-" {
+		outputFile = os.Stdout
+	} else {
+		var err error
+		outputFile, err = os.Create(opts.ResultsFile)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "error opening output file")
+		}
+	}
+
+	var w output.SummaryWriter
+	var err error
+	switch opts.ResultsFormat {
+	case "json":
+		w = output.NewJSONSummaryWriter(outputFile, "", "  ")
+	case "yaml":
+		w = output.NewYAMLSummaryWriter(outputFile)
+	case "csv":
+		w = output.NewCSVSummaryWriter(outputFile)
+	case "junit":
+		w = output.NewJUnitSummaryWriter(outputFile)
+	case "template":
+		w, err = output.NewTemplateFileSummaryWriter(outputFile, opts.ResultsTmpl)
+	default:
+		err = errors.New("invalid results format")
+	}
+	if err != nil {
+		outputFile.Close()
+		return nil, nil, err
+	}
+	return w, outputFile, nil
+}
+
+// writeBaseline writes the baseline of the hits in sum to file.
+func writeBaseline(file string, sum output.ScanSummary) error {
+	b := baseline.New(sum)
+	f, err := os.Create(file)
+	if err != nil {
+		return errors.Wrapf(err, "error creating baseline")
+	}
+	if err := b.Write(f); err != nil {
+		f.Close()
+		return errors.Wrapf(err, "error writing baseline")
+	}
+	if err := f.Close(); err != nil {
+		return errors.Wrapf(err, "error writing baseline")
+	}
+	fmt.Fprintf(os.Stderr, "Wrote baseline of %d hits in %d files to %s\n", sum.Stats.TotalHits, sum.Stats.FilesHit, file)
+	return nil
+}
+
+// inputSource returns the source of the input file. Regular files are
+// linked or read in place if they can be, and stdin ("-") and named pipes
+// are read as streams.
+func inputSource(opts *Opts) (scanner.Source, error) {
+	if opts.InputFile == "-" {
+		name := opts.StdinName
+		var r io.Reader = os.Stdin
+		if name == "" {
+			//
+			// Name stdin after its type, so that it is unarchived
+			// under a sensible name.
+			//
+			var ext string
+			ext, r = archive.StreamType(r)
+			name = "stdin"
+			if ext != "" {
+				name += "." + ext
 			}
 		}
+		return scanner.Stream(name, r), nil
 	}
+
+	info, err := os.Stat(opts.InputFile)
+	if err != nil {
+		return nil, err
+	}
+	if info.Mode()&(os.ModeNamedPipe|os.ModeCharDevice) != 0 {
+		f, err := os.Open(opts.InputFile)
+		if err != nil {
+			return nil, err
+		}
+		return scanner.Stream(opts.InputFile, f), nil
+	}
+	if opts.CopyInput {
+		return scanner.CopiedFile(opts.InputFile), nil
+	}
+	return scanner.File(opts.InputFile), nil
 }
 
 func setupSignalCancellationContext() context.Context {
 	ctx, cancel := context.WithCancel(context.Background())
-	sigChan := make(chan os.Signal)
-	signal.Notify(sigChan, syscall.SIGABRT, syscall.SIGINT, syscall.SIGKILL)
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGABRT, syscall.SIGINT, syscall.SIGTERM)
 	go func() {
 		sig := <-sigChan
+
+		//
+		// Restore the default signal handling, so that a second signal
+		// kills us if cleaning up the scratch space hangs.
+		//
+		signal.Stop(sigChan)
 		fmt.Fprintf(os.Stderr, "Received signal %s. Exiting\n", sig)
 		cancel()
 	}()
@@ -0,0 +1,87 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/joelanford/goscan/utils/compare"
+	"github.com/joelanford/goscan/utils/output"
+	"github.com/pkg/errors"
+)
+
+func parseDiffFlags(args []string) (*Opts, error) {
+	opts := Opts{Command: "diff"}
+	fs := flag.NewFlagSet("goscan diff", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Printf("Usage: goscan diff [options] <old> <new>\n")
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&opts.ResultsFile, "output.file", "-", "Results output file (\"-\" for stdout)")
+	fs.StringVar(&opts.ResultsFormat, "output.format", "json", "Results output format (json, yaml, csv, junit, template)")
+	fs.StringVar(&opts.ResultsTmpl, "output.template", "", "Go text/template file used when output.format is \"template\"")
+	fs.BoolVar(&opts.FailOnHits, "fail-on-hits", false, "Exit with an error if any hits were added or changed")
+	fs.Parse(args)
+
+	if opts.ResultsFormat == "template" && opts.ResultsTmpl == "" {
+		return nil, errors.New("output.template must be defined when output.format is template")
+	}
+	if fs.NArg() != 2 {
+		return nil, errors.New("must define the old and new scan summaries to compare")
+	}
+	if fs.Arg(0) == "-" && fs.Arg(1) == "-" {
+		return nil, errors.New("only one scan summary can be read from stdin")
+	}
+	opts.DiffOld, opts.InputFile = fs.Arg(0), fs.Arg(1)
+	return &opts, nil
+}
+
+// runDiff compares the scan summaries opts.DiffOld and opts.InputFile, and
+// writes the hits that were added, removed or changed between them.
+func runDiff(opts *Opts) error {
+	old, err := readSummary(opts.DiffOld)
+	if err != nil {
+		return err
+	}
+	new, err := readSummary(opts.InputFile)
+	if err != nil {
+		return err
+	}
+	if old.KeywordsFingerprint != "" && new.KeywordsFingerprint != "" && old.KeywordsFingerprint != new.KeywordsFingerprint {
+		fmt.Fprintf(os.Stderr, "Warning: %s and %s were scanned with different keywords\n", opts.DiffOld, opts.InputFile)
+	}
+
+	w, outputFile, err := summaryWriter(opts)
+	if err != nil {
+		return err
+	}
+	defer outputFile.Close()
+
+	d := compare.Compare(old, new)
+	if err := w.WriteSummary(d); err != nil {
+		return errors.Wrapf(err, "error writing comparison")
+	}
+	if opts.FailOnHits && d.Stats.Diff.HitsAdded+d.Stats.Diff.HitsChanged > 0 {
+		return errors.Errorf("%d hits added and %d changed", d.Stats.Diff.HitsAdded, d.Stats.Diff.HitsChanged)
+	}
+	return nil
+}
+
+// readSummary reads the scan summary in file, or stdin for "-".
+func readSummary(file string) (output.ScanSummary, error) {
+	var r io.Reader = os.Stdin
+	if file != "-" {
+		f, err := os.Open(file)
+		if err != nil {
+			return output.ScanSummary{}, errors.Wrapf(err, "error opening scan summary")
+		}
+		defer f.Close()
+		r = f
+	}
+	sum, err := output.ReadSummary(r)
+	if err != nil {
+		return sum, errors.Wrapf(err, "error reading scan summary %s", file)
+	}
+	return sum, nil
+}
@@ -0,0 +1,260 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"path"
+	"strings"
+
+	"github.com/joelanford/goscan/utils/git"
+	"github.com/joelanford/goscan/utils/output"
+	"github.com/joelanford/goscan/utils/scratch"
+	"github.com/pkg/errors"
+)
+
+// gitSource is the history of the git repository at opts.InputFile. Each
+// blob reachable from opts.GitRevs is copied into the scratch space once,
+// as <repo>/<commit>/<path> of the first commit it was seen in.
+type gitSource struct {
+	ctx  context.Context
+	repo string
+	revs []string
+
+	// origins maps the result path of each blob to where it came from.
+	origins map[string]*output.GitOrigin
+}
+
+func newGitSource(ctx context.Context, opts *Opts) *gitSource {
+	return &gitSource{
+		ctx:     ctx,
+		repo:    opts.InputFile,
+		revs:    opts.GitRevs,
+		origins: make(map[string]*output.GitOrigin),
+	}
+}
+
+func (g *gitSource) Import(ss *scratch.Scratch) (string, error) {
+	r, err := git.Open(g.repo)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+
+	dir, err := ss.Name(g.repo)
+	if err != nil {
+		return "", err
+	}
+	if err := ss.FS().MkdirAll(dir); err != nil {
+		return "", err
+	}
+	err = r.Blobs(g.revs, func(b git.Blob) error {
+		if err := g.ctx.Err(); err != nil {
+			return err
+		}
+		data, err := r.ReadBlob(b.ID)
+		if err != nil {
+			return err
+		}
+		commit := b.Commit.ID.String()[:7]
+		if _, err := ss.CopyReader(bytes.NewReader(data), path.Join(g.repo, commit, b.Path)); err != nil {
+			return err
+		}
+		g.origins["/"+path.Join(path.Base(dir), commit, b.Path)] = &output.GitOrigin{
+			Blob:   b.ID.String(),
+			Commit: b.Commit.ID.String(),
+			Path:   b.Path,
+			Author: b.Commit.Author.String(),
+			Date:   b.Commit.Author.When,
+		}
+		return nil
+	})
+	if err != nil {
+		return "", errors.Wrapf(err, "error reading git repository %s", g.repo)
+	}
+	return dir, nil
+}
+
+// annotate sets where in the repository the file of sr came from. Files
+// extracted from archives in the repository are given the origin of the
+// archive.
+func (g *gitSource) annotate(sr *output.ScanResult) {
+	for p := sr.File; p != "/" && p != "."; p = path.Dir(p) {
+		if origin, ok := g.origins[p]; ok {
+			sr.Git = origin
+			return
+		}
+	}
+}
+
+// parseGitRevs splits the comma-separated revs of the git.revs flag.
+func parseGitRevs(revs string) []string {
+	var list []string
+	for _, rev := range strings.Split(revs, ",") {
+		if rev = strings.TrimSpace(rev); rev != "" {
+			list = append(list, rev)
+		}
+	}
+	return list
+}
+
+// diffSource is the changes to the git repository at opts.InputFile: the
+// files added or modified between the commits of opts.GitDiff, or those
+// staged to be committed. Of text files that were modified, only the
+// added lines are scanned: the rest of each line is blanked out, so that
+// hits have the line numbers and offsets of the new version. New files and
+// modified binary files are scanned whole.
+type diffSource struct {
+	repo   string
+	rev    string
+	staged bool
+}
+
+func newDiffSource(opts *Opts) *diffSource {
+	return &diffSource{repo: opts.InputFile, rev: opts.GitDiff, staged: opts.GitStaged}
+}
+
+func (d *diffSource) Import(ss *scratch.Scratch) (string, error) {
+	r, err := git.Open(d.repo)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+
+	changes, err := d.changes(r)
+	if err != nil {
+		return "", errors.Wrapf(err, "error diffing git repository %s", d.repo)
+	}
+	dir, err := ss.Name(d.repo)
+	if err != nil {
+		return "", err
+	}
+	if err := ss.FS().MkdirAll(dir); err != nil {
+		return "", err
+	}
+	for _, c := range changes {
+		if c.Old.ID == c.New.ID {
+			continue
+		}
+		data, err := r.ReadBlob(c.New.ID)
+		if err != nil {
+			return "", err
+		}
+		if !c.Added() && !isBinary(data) {
+			old, err := r.ReadBlob(c.Old.ID)
+			if err != nil {
+				return "", err
+			}
+			if !isBinary(old) {
+				data = onlyLines(data, git.AddedLines(old, data))
+				if data == nil {
+					continue
+				}
+			}
+		}
+		if _, err := ss.CopyReader(bytes.NewReader(data), path.Join(d.repo, c.Path)); err != nil {
+			return "", err
+		}
+	}
+	return dir, nil
+}
+
+// changes returns the staged changes, or those of a range: "a..b" for the
+// changes from a to b, "a...b" for those from where b branched off a, and
+// "a" for those from a to HEAD.
+func (d *diffSource) changes(r *git.Repository) ([]git.Change, error) {
+	if d.staged {
+		tree, err := commitTree(r, "HEAD")
+		if errors.Cause(err) == git.ErrNotFound {
+			return r.DiffIndex(git.ID{})
+		}
+		if err != nil {
+			return nil, err
+		}
+		return r.DiffIndex(tree)
+	}
+
+	base, head := d.rev, "HEAD"
+	mergeBase := false
+	if i := strings.Index(d.rev, ".."); i >= 0 {
+		base, head = d.rev[:i], d.rev[i+2:]
+		if strings.HasPrefix(head, ".") {
+			head, mergeBase = head[1:], true
+		}
+	}
+	if base == "" {
+		base = "HEAD"
+	}
+	if head == "" {
+		head = "HEAD"
+	}
+	headID, err := r.Resolve(head)
+	if err != nil {
+		return nil, err
+	}
+	baseID, err := r.Resolve(base)
+	if err != nil {
+		return nil, err
+	}
+	if mergeBase {
+		if baseID, err = r.MergeBase(baseID, headID); err != nil {
+			return nil, err
+		}
+	}
+	oldTree, err := commitTree(r, baseID.String())
+	if err != nil {
+		return nil, err
+	}
+	newTree, err := commitTree(r, headID.String())
+	if err != nil {
+		return nil, err
+	}
+	return r.DiffTrees(oldTree, newTree)
+}
+
+func commitTree(r *git.Repository, rev string) (git.ID, error) {
+	id, err := r.Resolve(rev)
+	if err != nil {
+		return git.ID{}, err
+	}
+	c, err := r.Commit(id)
+	if err != nil {
+		return git.ID{}, err
+	}
+	return c.Tree, nil
+}
+
+// isBinary is true if data looks binary, as git decides it: if there is a
+// NUL in its first 8000 bytes.
+func isBinary(data []byte) bool {
+	if len(data) > 8000 {
+		data = data[:8000]
+	}
+	return bytes.IndexByte(data, 0) >= 0
+}
+
+// onlyLines returns a copy of data with every byte outside the lines
+// numbered in lines replaced with a space, other than line endings, or nil
+// if there are no lines.
+func onlyLines(data []byte, lines []int) []byte {
+	if len(lines) == 0 {
+		return nil
+	}
+	keep := make(map[int]bool, len(lines))
+	for _, n := range lines {
+		keep[n] = true
+	}
+	out := make([]byte, len(data))
+	line := 1
+	for i, b := range data {
+		switch {
+		case b == '\n':
+			out[i] = b
+			line++
+		case keep[line]:
+			out[i] = b
+		default:
+			out[i] = ' '
+		}
+	}
+	return out
+}
@@ -0,0 +1,109 @@
+package cli
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/joelanford/goscan/utils/git"
+	"github.com/pkg/errors"
+)
+
+// hookMarker is in every hook that goscan installs, so that it knows it
+// may replace them.
+const hookMarker = "# goscan pre-commit hook"
+
+func parseHookFlags(args []string) (*Opts, error) {
+	if len(args) == 0 || args[0] != "install" {
+		return nil, errors.New("usage: goscan hook install [options] [repo]")
+	}
+	var policies string
+	opts := Opts{Command: "hook install"}
+	fs := flag.NewFlagSet("goscan hook install", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Printf("Usage: goscan hook install [options] [repo]\n")
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&opts.KeywordsFile, "words", "", "YAML keywords file")
+	fs.StringVar(&policies, "policies", "all", "Comma-separated list of keyword policies")
+	fs.BoolVar(&opts.HookForce, "force", false, "Replace an existing pre-commit hook that goscan didn't install")
+	fs.Parse(args[1:])
+
+	if opts.KeywordsFile == "" {
+		return nil, errors.New("words file must be defined")
+	}
+	if policies != "all" {
+		opts.Policies = strings.Split(policies, ",")
+	}
+	switch fs.NArg() {
+	case 0:
+		opts.InputFile = "."
+	case 1:
+		opts.InputFile = fs.Arg(0)
+	default:
+		return nil, errors.New("unexpected arguments")
+	}
+	return &opts, nil
+}
+
+// runHookInstall installs a pre-commit hook in the git repository at
+// opts.InputFile that scans the staged changes, and stops the commit if
+// there are hits.
+func runHookInstall(opts *Opts) error {
+	r, err := git.Open(opts.InputFile)
+	if err != nil {
+		return err
+	}
+	hooks := r.HooksDir()
+	r.Close()
+
+	//
+	// The hook runs from the top of the working tree, so it needs the
+	// absolute paths of goscan and the keywords.
+	//
+	exe, err := os.Executable()
+	if err != nil {
+		return errors.Wrapf(err, "error finding goscan executable")
+	}
+	words, err := filepath.Abs(opts.KeywordsFile)
+	if err != nil {
+		return err
+	}
+	args := []string{exe, "scan", "-staged", "-fail-on-hits", "-hitsonly", "-progress", "none", "-words", words}
+	if len(opts.Policies) > 0 {
+		args = append(args, "-policies", strings.Join(opts.Policies, ","))
+	}
+	for i := range args {
+		args[i] = shellQuote(args[i])
+	}
+	script := "#!/bin/sh\n" +
+		hookMarker + ", installed by \"goscan hook install\".\n" +
+		"# It scans the lines added by the staged changes, and stops the commit if\n" +
+		"# there are hits. \"git commit --no-verify\" skips it.\n" +
+		"exec " + strings.Join(args, " ") + " .\n"
+
+	hook := filepath.Join(hooks, "pre-commit")
+	if b, err := ioutil.ReadFile(hook); err == nil && !bytes.Contains(b, []byte(hookMarker)) && !opts.HookForce {
+		return errors.Errorf("%s already exists; use -force to replace it", hook)
+	}
+	if err := os.MkdirAll(hooks, 0755); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(hook, []byte(script), 0755); err != nil {
+		return err
+	}
+	if err := os.Chmod(hook, 0755); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Installed pre-commit hook in %s\n", hook)
+	return nil
+}
+
+// shellQuote quotes s for a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
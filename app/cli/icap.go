@@ -0,0 +1,119 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/joelanford/goscan/app/icap"
+	"github.com/joelanford/goscan/utils/scanner"
+	"github.com/pkg/errors"
+)
+
+func parseICAPFlags(args []string) (*Opts, error) {
+	var policies string
+	opts := Opts{Command: "icap"}
+	fs := flag.NewFlagSet("goscan icap", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Printf("Usage: goscan icap [options]\n")
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&opts.Listen, "listen", ":1344", "Address to listen on")
+	fs.StringVar(&opts.BaseDir, "basedir", os.TempDir(), "Scratch directory for scan unarchiving")
+	fs.StringVar(&opts.KeywordsFile, "words", "", "YAML keywords file, reloaded on SIGHUP")
+	fs.DurationVar(&opts.KeywordsWatch, "words.watch", 0, "How often to check the keywords file for changes, and reload it (0 to only reload on SIGHUP)")
+	fs.StringVar(&policies, "policies", "all", "Comma-separated list of keyword policies")
+	fs.IntVar(&opts.HitContext, "context", 10, "Context to capture around each hit")
+	fs.IntVar(&opts.Parallelism, "parallelism", runtime.NumCPU(), "Number of files to scan at once, across all requests")
+	fs.BoolVar(&opts.Native, "unarchive.native", false, "Use built-in extractors for gzip, bzip2, tar and zip")
+	fs.DurationVar(&opts.UnarchiveTimeout, "unarchive.timeout", 0, "Maximum time to spend unarchiving a single archive (0 for no limit)")
+	fs.BoolVar(&opts.Dedup, "dedup", false, "Scan and unarchive files with identical contents only once, at the cost of reading each file one more time to hash it")
+	fs.Int64Var(&opts.MaxBodySize, "max-body", 1024, "Maximum size (in MB) of an encapsulated body (0 for no limit)")
+	fs.DurationVar(&opts.ScanTimeout, "timeout", time.Minute, "Maximum time to spend on a request, including reading its body (0 for no limit)")
+	fs.StringVar(&opts.ICAPAction, "action", icap.ActionBlock, "What to do with messages with hits (block, annotate)")
+	fs.StringVar(&opts.ICAPBlockPage, "blockpage", "", "html/template file for the block page (default built in)")
+	fs.DurationVar(&opts.ScratchGCAge, "scratch.gc.age", time.Hour, "Remove stale scratch directories older than this at startup (0 to disable)")
+	fs.Parse(args)
+
+	if opts.KeywordsFile == "" {
+		return nil, errors.New("words file must be defined")
+	}
+	if policies != "all" {
+		opts.Policies = strings.Split(policies, ",")
+	}
+	if opts.Parallelism < 1 {
+		return nil, errors.New("parallelism must be > 0")
+	}
+	if opts.MaxBodySize < 0 {
+		return nil, errors.New("max-body must be >= 0")
+	}
+	if fs.NArg() != 0 {
+		return nil, errors.New("unexpected arguments")
+	}
+	return &opts, nil
+}
+
+// runICAP serves ICAP requests until it receives a signal.
+func runICAP(opts *Opts) error {
+	kw, err := loadKeywords(opts)
+	if err != nil {
+		return err
+	}
+
+	if opts.ScratchGCAge > 0 {
+		removeStaleScratch(opts.BaseDir, opts.ScratchGCAge, false, false)
+	}
+
+	s, err := scanner.NewScanner(kw,
+		scanner.BaseDir(opts.BaseDir),
+		scanner.HitContext(opts.HitContext),
+		scanner.Parallelism(opts.Parallelism),
+		scanner.UnarchiveTimeout(opts.UnarchiveTimeout),
+		scanner.NativeUnarchive(opts.Native),
+		scanner.Dedup(opts.Dedup),
+	)
+	if err != nil {
+		return errors.Wrapf(err, "failed to initialize scanner")
+	}
+	icapOpts := []icap.Option{
+		icap.Action(opts.ICAPAction),
+		icap.MaxBodySize(opts.MaxBodySize << 20),
+		icap.Timeout(opts.ScanTimeout),
+	}
+	if opts.ICAPBlockPage != "" {
+		page, err := ioutil.ReadFile(opts.ICAPBlockPage)
+		if err != nil {
+			return errors.Wrapf(err, "error reading block page")
+		}
+		icapOpts = append(icapOpts, icap.BlockPage(string(page)))
+	}
+	srv, err := icap.New(s, icapOpts...)
+	if err != nil {
+		return errors.Wrapf(err, "failed to initialize ICAP server")
+	}
+
+	l, err := net.Listen("tcp", opts.Listen)
+	if err != nil {
+		return err
+	}
+	ctx := setupSignalCancellationContext()
+	go reloadKeywords(ctx, opts, s)
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- srv.Serve(l)
+	}()
+	fmt.Fprintf(os.Stderr, "Listening on %s\n", l.Addr())
+
+	select {
+	case err := <-errChan:
+		srv.Close()
+		return err
+	case <-ctx.Done():
+	}
+	return srv.Close()
+}
@@ -0,0 +1,91 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/joelanford/goscan/utils/scanner"
+)
+
+// progressWriter returns a function that renders scan progress to f, and
+// how often to call it, or nil if progress shouldn't be shown. In auto
+// mode, progress is shown as a live status line when f is a terminal, and
+// as JSON lines otherwise. JSON lines are written less often by default.
+func progressWriter(mode string, interval time.Duration, f *os.File) (func(scanner.Progress), time.Duration) {
+	if mode == "auto" {
+		mode = "json"
+		if isTerminal(f) {
+			mode = "tty"
+		}
+	}
+	if interval == 0 {
+		interval = 500 * time.Millisecond
+		if mode == "json" {
+			interval = 10 * time.Second
+		}
+	}
+	switch mode {
+	case "tty":
+		return func(p scanner.Progress) {
+			fmt.Fprintf(f, "\r\x1b[K%s", progressLine(p))
+			if p.Done {
+				fmt.Fprintln(f)
+			}
+		}, interval
+	case "json":
+		enc := json.NewEncoder(f)
+		return func(p scanner.Progress) {
+			enc.Encode(progressStatus{
+				Progress: p,
+				Elapsed:  p.Elapsed.Seconds(),
+				ETA:      p.ETA.Seconds(),
+			})
+		}, interval
+	}
+	return nil, 0
+}
+
+// progressStatus is a JSON status line, with durations in seconds like the
+// scan summary.
+type progressStatus struct {
+	scanner.Progress
+	Elapsed float64 `json:"elapsed"`
+	ETA     float64 `json:"eta"`
+}
+
+func progressLine(p scanner.Progress) string {
+	eta := "ETA --"
+	if p.Done {
+		eta = "done"
+	} else if p.ETA > 0 {
+		eta = "ETA " + p.ETA.Round(time.Second).String()
+	}
+	return fmt.Sprintf("%s  scanned %d/%d files, %s/%s  archives %d (%d active, depth %d)  workers %d/%d  %s",
+		p.Elapsed.Round(time.Second),
+		p.FilesScanned, p.FilesFound,
+		byteSize(p.BytesScanned), byteSize(p.BytesFound),
+		p.ArchivesUnarchived, p.Unarchiving, p.Depth,
+		p.ActiveWorkers, p.Workers,
+		eta,
+	)
+}
+
+func byteSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,85 @@
+package cli
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/joelanford/goscan/utils/scanner"
+	"github.com/stretchr/testify/assert"
+)
+
+var progress = scanner.Progress{
+	FilesFound:         10,
+	BytesFound:         3 << 20,
+	ArchivesUnarchived: 2,
+	Unarchiving:        1,
+	FilesScanned:       4,
+	BytesScanned:       1 << 20,
+	Depth:              2,
+	ActiveWorkers:      3,
+	Workers:            8,
+	Elapsed:            90 * time.Second,
+	ETA:                3 * time.Minute,
+}
+
+func TestProgressWriterJSON(t *testing.T) {
+	f, err := ioutil.TempFile("", "goscan-progress")
+	assert.NoError(t, err)
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	//
+	// Files that aren't terminals get JSON lines in auto mode, every 10s
+	// unless told otherwise.
+	//
+	fn, interval := progressWriter("auto", 0, f)
+	assert.Equal(t, 10*time.Second, interval)
+	fn(progress)
+	done := progress
+	done.Done, done.ETA = true, 0
+	fn(done)
+
+	data, err := ioutil.ReadFile(f.Name())
+	assert.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if !assert.Len(t, lines, 2) {
+		return
+	}
+	var status map[string]interface{}
+	assert.NoError(t, json.Unmarshal([]byte(lines[0]), &status))
+	assert.Equal(t, 90.0, status["elapsed"])
+	assert.Equal(t, 180.0, status["eta"])
+	assert.Equal(t, 4.0, status["filesScanned"])
+	assert.Equal(t, 10.0, status["filesFound"])
+	assert.Equal(t, false, status["done"])
+	assert.NoError(t, json.Unmarshal([]byte(lines[1]), &status))
+	assert.Equal(t, true, status["done"])
+
+	_, interval = progressWriter("json", time.Second, f)
+	assert.Equal(t, time.Second, interval)
+	fn, _ = progressWriter("none", 0, f)
+	assert.Nil(t, fn)
+}
+
+func TestProgressWriterTTY(t *testing.T) {
+	f, err := ioutil.TempFile("", "goscan-progress")
+	assert.NoError(t, err)
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	fn, interval := progressWriter("tty", 0, f)
+	assert.Equal(t, 500*time.Millisecond, interval)
+	fn(progress)
+	done := progress
+	done.Done = true
+	fn(done)
+
+	data, err := ioutil.ReadFile(f.Name())
+	assert.NoError(t, err)
+	assert.Equal(t, "\r\x1b[K1m30s  scanned 4/10 files, 1.0 MiB/3.0 MiB  archives 2 (1 active, depth 2)  workers 3/8  ETA 3m0s"+
+		"\r\x1b[K1m30s  scanned 4/10 files, 1.0 MiB/3.0 MiB  archives 2 (1 active, depth 2)  workers 3/8  done\n", string(data))
+}
@@ -0,0 +1,90 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/joelanford/goscan/utils/keywords"
+	"github.com/joelanford/goscan/utils/scanner"
+	"github.com/pkg/errors"
+)
+
+// loadKeywords loads and validates the keywords file of opts.
+func loadKeywords(opts *Opts) (*keywords.Keywords, error) {
+	kw, err := keywords.LoadFile(opts.KeywordsFile, opts.Policies)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error loading keywords")
+	}
+	if err := kw.Validate(); err != nil {
+		return nil, errors.Wrapf(err, "invalid keywords")
+	}
+	return kw, nil
+}
+
+// reloadKeywords reloads the keywords of s from the keywords file of opts
+// on SIGHUP, and whenever the file changes if opts.KeywordsWatch is set,
+// until ctx is done. The new keywords only replace the old ones once they
+// have loaded and validated, so scans never wait for them, and a broken
+// file leaves the old ones in place.
+func reloadKeywords(ctx context.Context, opts *Opts, s *scanner.Scanner) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	var tick <-chan time.Time
+	var last keywordsStamp
+	if opts.KeywordsWatch > 0 {
+		ticker := time.NewTicker(opts.KeywordsWatch)
+		defer ticker.Stop()
+		tick = ticker.C
+		last, _ = stampKeywords(opts.KeywordsFile)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+		case <-tick:
+			//
+			// A file that is missing may be in the middle of being
+			// replaced, so it is checked again at the next tick.
+			//
+			stamp, err := stampKeywords(opts.KeywordsFile)
+			if err != nil || stamp == last {
+				continue
+			}
+			last = stamp
+		}
+
+		old := s.Keywords()
+		kw, err := loadKeywords(opts)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reloading keywords, keeping %.12s: %s\n", old.Fingerprint(), err)
+			continue
+		}
+		if kw.Fingerprint() == old.Fingerprint() {
+			continue
+		}
+		s.SetKeywords(kw)
+		fmt.Fprintf(os.Stderr, "Reloaded %d keywords from %s (%.12s)\n", len(kw.Keywords()), opts.KeywordsFile, kw.Fingerprint())
+	}
+}
+
+// keywordsStamp identifies a version of a keywords file.
+type keywordsStamp struct {
+	modTime int64
+	size    int64
+}
+
+func stampKeywords(file string) (keywordsStamp, error) {
+	info, err := os.Stat(file)
+	if err != nil {
+		return keywordsStamp{}, err
+	}
+	return keywordsStamp{modTime: info.ModTime().UnixNano(), size: info.Size()}, nil
+}
@@ -0,0 +1,146 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/joelanford/goscan/utils/scratch"
+	"github.com/pkg/errors"
+)
+
+func parseScratchFlags(args []string) (*Opts, error) {
+	if len(args) == 0 {
+		return nil, errors.New("usage: goscan scratch <gc|inspect> [options]")
+	}
+
+	var opts Opts
+	fs := flag.NewFlagSet("goscan scratch "+args[0], flag.ExitOnError)
+	switch args[0] {
+	case "gc":
+		opts.Command = "scratch gc"
+		fs.Usage = func() {
+			fmt.Printf("Usage: goscan scratch gc [options]\n")
+			fs.PrintDefaults()
+		}
+		fs.StringVar(&opts.BaseDir, "basedir", os.TempDir(), "Scratch directory for scan unarchiving")
+		fs.DurationVar(&opts.ScratchGCAge, "age", time.Hour, "Only remove stale scratch directories older than this")
+		fs.BoolVar(&opts.ScratchGCKept, "kept", false, "Also remove scratch directories kept with keep-scratch")
+		fs.BoolVar(&opts.DryRun, "dryrun", false, "List stale scratch directories without removing them")
+	case "inspect":
+		opts.Command = "scratch inspect"
+		fs.Usage = func() {
+			fmt.Printf("Usage: goscan scratch inspect <scratchdir>\n")
+			fs.PrintDefaults()
+		}
+	default:
+		return nil, errors.Errorf("unknown scratch command %q", args[0])
+	}
+	fs.Parse(args[1:])
+
+	switch opts.Command {
+	case "scratch gc":
+		if opts.ScratchGCAge < 0 {
+			return nil, errors.New("age must be >= 0")
+		}
+		if fs.NArg() != 0 {
+			return nil, errors.New("unexpected arguments")
+		}
+	case "scratch inspect":
+		if fs.NArg() != 1 {
+			return nil, errors.New("must define exactly one scratch directory to inspect")
+		}
+		opts.InputFile = fs.Arg(0)
+	}
+	return &opts, nil
+}
+
+func runScratchGC(opts *Opts) error {
+	if removeStaleScratch(opts.BaseDir, opts.ScratchGCAge, opts.ScratchGCKept, opts.DryRun) {
+		return errors.New("some stale scratch directories could not be removed")
+	}
+	return nil
+}
+
+// removeStaleScratch removes the scratch directories left behind in baseDir
+// by goscan processes that no longer exist, reporting each one on stderr.
+// It returns true if any could not be removed.
+func removeStaleScratch(baseDir string, minAge time.Duration, kept, dryRun bool) bool {
+	stale, err := scratch.FindStale(scratch.SearchDirs(baseDir), minAge, kept)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error finding stale scratch directories: %s\n", err)
+		return true
+	}
+
+	failed := false
+	for _, d := range stale {
+		owner := "unknown process"
+		if d.Owner != nil {
+			owner = fmt.Sprintf("pid %d", d.Owner.PID)
+		}
+		if dryRun {
+			fmt.Fprintf(os.Stderr, "Stale scratch directory %s (%s, %s old)\n", d.Path, owner, d.Age.Round(time.Second))
+			continue
+		}
+		if err := d.Remove(); err != nil {
+			fmt.Fprintf(os.Stderr, "error removing stale scratch directory: %s\n", err)
+			failed = true
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "Removed stale scratch directory %s (%s, %s old)\n", d.Path, owner, d.Age.Round(time.Second))
+	}
+	return failed
+}
+
+// runScratchInspect prints the manifest of a kept scratch directory, with
+// each archive followed by the files that were unarchived from it.
+func runScratchInspect(opts *Opts) error {
+	m, err := scratch.ReadManifest(opts.InputFile)
+	if err != nil {
+		return errors.Wrapf(err, "error reading scratch manifest")
+	}
+	sort.Slice(m.Files, func(i, j int) bool {
+		return m.Files[i].Path < m.Files[j].Path
+	})
+	archives := make(map[string]scratch.ManifestArchive, len(m.Archives))
+	for _, a := range m.Archives {
+		archives[a.Path] = a
+	}
+
+	fmt.Printf("Scratch directory: %s\n", m.Dir)
+	fmt.Printf("Created:           %s\n", m.Created.Format(time.RFC3339))
+	fmt.Printf("Files:             %d\n", len(m.Files))
+	fmt.Printf("Archives:          %d\n\n", len(m.Archives))
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "PATH\tSIZE\tEXTRACTOR\tEXIT\tOUT\tTIME\tSCRATCH PATH\tUNARCHIVED INTO\n")
+	for _, f := range m.Files {
+		a, ok := archives[f.Path]
+		if !ok {
+			fmt.Fprintf(w, "%s\t%d\t\t\t\t\t%s\t\n", f.Path, f.Size, f.ScratchPath)
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\t%d\t%d\t%.3fs\t%s\t%s\n", f.Path, f.Size, a.Extractor, a.ExitStatus, a.BytesOut, a.Duration, f.ScratchPath, a.ScratchPath)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	//
+	// Errors are listed after the table, since they're too long for it
+	//
+	first := true
+	for _, f := range m.Files {
+		if a, ok := archives[f.Path]; ok && a.Error != "" {
+			if first {
+				fmt.Printf("\nErrors:\n")
+				first = false
+			}
+			fmt.Printf("  %s: %s\n", f.Path, a.Error)
+		}
+	}
+	return nil
+}
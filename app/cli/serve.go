@@ -0,0 +1,125 @@
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/joelanford/goscan/app/server"
+	"github.com/joelanford/goscan/utils/scanner"
+	"github.com/pkg/errors"
+)
+
+func parseServeFlags(args []string) (*Opts, error) {
+	var policies string
+	opts := Opts{Command: "serve"}
+	fs := flag.NewFlagSet("goscan serve", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Printf("Usage: goscan serve [options]\n")
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&opts.Listen, "listen", ":8080", "Address to listen on")
+	fs.StringVar(&opts.BaseDir, "basedir", os.TempDir(), "Scratch directory for scan unarchiving, and for job uploads")
+	fs.StringVar(&opts.KeywordsFile, "words", "", "YAML keywords file, reloaded on SIGHUP")
+	fs.DurationVar(&opts.KeywordsWatch, "words.watch", 0, "How often to check the keywords file for changes, and reload it (0 to only reload on SIGHUP)")
+	fs.StringVar(&policies, "policies", "all", "Comma-separated list of keyword policies")
+	fs.IntVar(&opts.HitContext, "context", 10, "Context to capture around each hit")
+	fs.IntVar(&opts.Parallelism, "parallelism", runtime.NumCPU(), "Number of files to scan at once, across all requests")
+	fs.BoolVar(&opts.Native, "unarchive.native", false, "Use built-in extractors for gzip, bzip2, tar and zip")
+	fs.DurationVar(&opts.UnarchiveTimeout, "unarchive.timeout", 0, "Maximum time to spend unarchiving a single archive (0 for no limit)")
+	fs.BoolVar(&opts.Metadata, "metadata", false, "Include size, SHA-256, file type, mtime and mode of each file in results")
+	fs.BoolVar(&opts.LegacyHashes, "metadata.legacyhashes", false, "Also include MD5 and SHA-1 hashes in file metadata")
+	fs.BoolVar(&opts.Dedup, "dedup", false, "Scan and unarchive files with identical contents only once, at the cost of reading each file one more time to hash it")
+	fs.Int64Var(&opts.MaxBodySize, "max-body", 1024, "Maximum size (in MB) of a request body (0 for no limit)")
+	fs.DurationVar(&opts.ScanTimeout, "timeout", 10*time.Minute, "Maximum time to spend on a scan (0 for no limit)")
+	fs.DurationVar(&opts.JobTTL, "job.ttl", time.Hour, "How long to keep the results of finished jobs")
+	fs.DurationVar(&opts.ScratchGCAge, "scratch.gc.age", time.Hour, "Remove stale scratch directories older than this at startup (0 to disable)")
+	fs.Parse(args)
+
+	if opts.KeywordsFile == "" {
+		return nil, errors.New("words file must be defined")
+	}
+	if policies != "all" {
+		opts.Policies = strings.Split(policies, ",")
+	}
+	if opts.Parallelism < 1 {
+		return nil, errors.New("parallelism must be > 0")
+	}
+	if opts.MaxBodySize < 0 {
+		return nil, errors.New("max-body must be >= 0")
+	}
+	if fs.NArg() != 0 {
+		return nil, errors.New("unexpected arguments")
+	}
+	return &opts, nil
+}
+
+// runServe serves scans over HTTP until it receives a signal, and then
+// waits for the requests and jobs in progress to be canceled.
+func runServe(opts *Opts) error {
+	kw, err := loadKeywords(opts)
+	if err != nil {
+		return err
+	}
+
+	if opts.ScratchGCAge > 0 {
+		removeStaleScratch(opts.BaseDir, opts.ScratchGCAge, false, false)
+	}
+
+	s, err := scanner.NewScanner(kw,
+		scanner.BaseDir(opts.BaseDir),
+		scanner.HitContext(opts.HitContext),
+		scanner.Parallelism(opts.Parallelism),
+		scanner.Metadata(opts.Metadata),
+		scanner.LegacyHashes(opts.LegacyHashes),
+		scanner.UnarchiveTimeout(opts.UnarchiveTimeout),
+		scanner.NativeUnarchive(opts.Native),
+		scanner.Dedup(opts.Dedup),
+	)
+	if err != nil {
+		return errors.Wrapf(err, "failed to initialize scanner")
+	}
+	srv, err := server.New(s,
+		server.MaxBodySize(opts.MaxBodySize<<20),
+		server.Timeout(opts.ScanTimeout),
+		server.JobTTL(opts.JobTTL),
+		server.SpoolDir(opts.BaseDir),
+	)
+	if err != nil {
+		return errors.Wrapf(err, "failed to initialize server")
+	}
+
+	ctx := setupSignalCancellationContext()
+	go reloadKeywords(ctx, opts, s)
+	hs := &http.Server{
+		Addr:              opts.Listen,
+		Handler:           srv,
+		ReadHeaderTimeout: 10 * time.Second,
+		BaseContext:       func(_ net.Listener) context.Context { return ctx },
+	}
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- hs.ListenAndServe()
+	}()
+	fmt.Fprintf(os.Stderr, "Listening on %s\n", opts.Listen)
+
+	select {
+	case err := <-errChan:
+		return err
+	case <-ctx.Done():
+	}
+
+	//
+	// Requests in progress see the canceled context, and stop their scans.
+	//
+	srv.Close()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	return hs.Shutdown(shutdownCtx)
+}
@@ -0,0 +1,293 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/joelanford/goscan/utils/output"
+	"github.com/joelanford/goscan/utils/scanner"
+	"github.com/joelanford/goscan/utils/watch"
+	"github.com/pkg/errors"
+)
+
+func parseWatchFlags(args []string) (*Opts, error) {
+	var policies string
+	opts := Opts{Command: "watch"}
+	fs := flag.NewFlagSet("goscan watch", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Printf("Usage: goscan watch [options] <dir>\n")
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&opts.BaseDir, "basedir", os.TempDir(), "Scratch directory for scan unarchiving")
+	fs.StringVar(&opts.KeywordsFile, "words", "", "YAML keywords file, reloaded on SIGHUP")
+	fs.DurationVar(&opts.KeywordsWatch, "words.watch", 0, "How often to check the keywords file for changes, and reload it (0 to only reload on SIGHUP)")
+	fs.StringVar(&policies, "policies", "all", "Comma-separated list of keyword policies")
+	fs.IntVar(&opts.HitContext, "context", 10, "Context to capture around each hit")
+	fs.BoolVar(&opts.HitsOnly, "hitsonly", false, "Only output results containing hits or errors")
+	fs.IntVar(&opts.Parallelism, "parallelism", runtime.NumCPU(), "Number of files to scan at once, across all scans")
+	fs.IntVar(&opts.WatchScans, "scans", 2, "Number of landed files to scan at once")
+	fs.BoolVar(&opts.Native, "unarchive.native", false, "Use built-in extractors for gzip, bzip2, tar and zip")
+	fs.DurationVar(&opts.UnarchiveTimeout, "unarchive.timeout", 0, "Maximum time to spend unarchiving a single archive (0 for no limit)")
+	fs.BoolVar(&opts.Metadata, "metadata", false, "Include size, SHA-256, file type, mtime and mode of each file in results")
+	fs.BoolVar(&opts.LegacyHashes, "metadata.legacyhashes", false, "Also include MD5 and SHA-1 hashes in file metadata")
+	fs.BoolVar(&opts.Dedup, "dedup", false, "Scan and unarchive files with identical contents only once, at the cost of reading each file one more time to hash it")
+	fs.StringVar(&opts.WatchSink, "sink", "-", "Where to send the summary of each file: \"-\" for stdout, a file to append to, or an http(s) URL to POST to")
+	fs.DurationVar(&opts.WatchDebounce, "debounce", 2*time.Second, "How long a file must be left alone, once closed, before it is scanned")
+	fs.DurationVar(&opts.WatchPoll, "poll", 0, "Poll the directory for changes this often, rather than using inotify (0 to use inotify where available)")
+	fs.BoolVar(&opts.WatchExisting, "existing", false, "Also scan the files that are in the directory at startup")
+	fs.BoolVar(&opts.WatchMove, "move", false, "Move scanned files to the clean or quarantine directory")
+	fs.StringVar(&opts.CleanDir, "move.clean", "", "Directory for files without hits or errors (default \"<dir>/clean\")")
+	fs.StringVar(&opts.QuarantineDir, "move.quarantine", "", "Directory for files with hits or errors (default \"<dir>/quarantine\")")
+	fs.DurationVar(&opts.ScratchGCAge, "scratch.gc.age", time.Hour, "Remove stale scratch directories older than this at startup (0 to disable)")
+	fs.Parse(args)
+
+	if opts.KeywordsFile == "" {
+		return nil, errors.New("words file must be defined")
+	}
+	if policies != "all" {
+		opts.Policies = strings.Split(policies, ",")
+	}
+	if opts.Parallelism < 1 {
+		return nil, errors.New("parallelism must be > 0")
+	}
+	if opts.WatchScans < 1 {
+		return nil, errors.New("scans must be > 0")
+	}
+	if fs.NArg() != 1 {
+		return nil, errors.New("directory to watch must be defined")
+	}
+
+	//
+	// The watcher reports absolute paths, which are moved relative to the
+	// watched directory.
+	//
+	dir, err := filepath.Abs(fs.Arg(0))
+	if err != nil {
+		return nil, err
+	}
+	opts.InputFile = dir
+	if opts.CleanDir == "" {
+		opts.CleanDir = filepath.Join(opts.InputFile, "clean")
+	}
+	if opts.QuarantineDir == "" {
+		opts.QuarantineDir = filepath.Join(opts.InputFile, "quarantine")
+	}
+	return &opts, nil
+}
+
+// runWatch scans the files that land in a directory until it receives a
+// signal, and then waits for the scans in progress to be canceled.
+func runWatch(opts *Opts) error {
+	kw, err := loadKeywords(opts)
+	if err != nil {
+		return err
+	}
+
+	if opts.ScratchGCAge > 0 {
+		removeStaleScratch(opts.BaseDir, opts.ScratchGCAge, false, false)
+	}
+
+	s, err := scanner.NewScanner(kw,
+		scanner.BaseDir(opts.BaseDir),
+		scanner.HitContext(opts.HitContext),
+		scanner.Parallelism(opts.Parallelism),
+		scanner.Metadata(opts.Metadata),
+		scanner.LegacyHashes(opts.LegacyHashes),
+		scanner.UnarchiveTimeout(opts.UnarchiveTimeout),
+		scanner.NativeUnarchive(opts.Native),
+		scanner.Dedup(opts.Dedup),
+	)
+	if err != nil {
+		return errors.Wrapf(err, "failed to initialize scanner")
+	}
+
+	sink, closeSink, err := openSink(opts.WatchSink)
+	if err != nil {
+		return err
+	}
+	defer closeSink()
+
+	watchOpts := []watch.Option{
+		watch.Debounce(opts.WatchDebounce),
+		watch.Poll(opts.WatchPoll),
+		watch.Existing(opts.WatchExisting),
+	}
+	if opts.WatchMove {
+		for _, dir := range []string{opts.CleanDir, opts.QuarantineDir} {
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				return err
+			}
+		}
+		watchOpts = append(watchOpts, watch.Exclude(opts.CleanDir, opts.QuarantineDir))
+	}
+	w, err := watch.New(opts.InputFile, watchOpts...)
+	if err != nil {
+		return errors.Wrapf(err, "failed to initialize watcher")
+	}
+
+	ctx := setupSignalCancellationContext()
+	go reloadKeywords(ctx, opts, s)
+	l := &landing{
+		ctx:      ctx,
+		opts:     opts,
+		scanner:  s,
+		sink:     sink,
+		sem:      make(chan struct{}, opts.WatchScans),
+		inflight: make(map[string]bool),
+	}
+	fmt.Fprintf(os.Stderr, "Watching %s\n", opts.InputFile)
+	err = w.Run(ctx, l.land)
+	l.wg.Wait()
+	return err
+}
+
+// landing scans the files that land in a watched directory, each in its
+// own scan, and no more than opts.WatchScans at once.
+type landing struct {
+	ctx     context.Context
+	opts    *Opts
+	scanner *scanner.Scanner
+	sink    output.SummaryWriter
+	sem     chan struct{}
+	wg      sync.WaitGroup
+
+	mu       sync.Mutex
+	inflight map[string]bool
+	sinkMu   sync.Mutex
+}
+
+// land starts scanning the file at path, unless it is already being
+// scanned, in which case that scan notices if it changed.
+func (l *landing) land(path string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.inflight[path] {
+		return
+	}
+	l.inflight[path] = true
+	l.wg.Add(1)
+	go func() {
+		defer l.wg.Done()
+		defer func() {
+			l.mu.Lock()
+			delete(l.inflight, path)
+			l.mu.Unlock()
+		}()
+		select {
+		case l.sem <- struct{}{}:
+		case <-l.ctx.Done():
+			return
+		}
+		defer func() { <-l.sem }()
+		l.scan(path)
+	}()
+}
+
+func (l *landing) scan(path string) {
+	//
+	// A file that changes while it is scanned is scanned again, so that
+	// what is reported, and moved, is what was scanned.
+	//
+	var sum output.ScanSummary
+	err := watch.Settle(path, func() error {
+		kw := l.scanner.Keywords()
+		sum = output.ScanSummary{
+			InputFile:           path,
+			Results:             make([]output.ScanResult, 0),
+			KeywordsFingerprint: kw.Fingerprint(),
+		}
+		start := time.Now()
+		err := l.scanner.ScanWith(l.ctx, kw, scanner.File(path), scanner.HandlerFunc(func(sr output.ScanResult) error {
+			sum.Add(sr, l.opts.HitsOnly)
+			return nil
+		}))
+		sum.Stats.Duration = time.Since(start).Seconds()
+		return err
+	})
+	if err != nil {
+		if l.ctx.Err() == nil {
+			fmt.Fprintf(os.Stderr, "Error scanning %s: %s\n", path, err)
+		}
+		return
+	}
+
+	l.sinkMu.Lock()
+	err = l.sink.WriteSummary(sum)
+	l.sinkMu.Unlock()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing summary of %s: %s\n", path, err)
+	}
+
+	msg := fmt.Sprintf("Scanned %s: %d hits in %d of %d files", path, sum.Stats.TotalHits, sum.Stats.FilesHit, sum.Stats.FilesScanned)
+	if l.opts.WatchMove {
+		dest, err := watch.Move(l.opts.InputFile, path, landingDir(l.opts, sum))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s, and could not be moved: %s\n", msg, err)
+			return
+		}
+		msg += ", moved to " + dest
+	}
+	fmt.Fprintln(os.Stderr, msg)
+}
+
+// landingDir returns the directory that a scanned file with the summary
+// sum is moved to: the quarantine directory if it had hits or errors, or
+// wasn't scanned in full, and the clean directory otherwise.
+func landingDir(opts *Opts, sum output.ScanSummary) string {
+	if sum.Stats.TotalHits > 0 || sum.Stats.TotalErrors > 0 || sum.Incomplete {
+		return opts.QuarantineDir
+	}
+	return opts.CleanDir
+}
+
+// openSink opens where watch sends summaries: stdout for "-", an http(s)
+// URL that each summary is POSTed to, or else a file that they are
+// appended to. Written to stdout or a file, each summary is a JSON line.
+func openSink(sink string) (output.SummaryWriter, func() error, error) {
+	switch {
+	case sink == "-":
+		return output.NewJSONSummaryWriter(os.Stdout, "", ""), func() error { return nil }, nil
+	case strings.HasPrefix(sink, "http://") || strings.HasPrefix(sink, "https://"):
+		return &httpSink{url: sink, client: &http.Client{Timeout: 30 * time.Second}}, func() error { return nil }, nil
+	}
+	f, err := os.OpenFile(sink, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "error opening sink")
+	}
+	return output.NewJSONSummaryWriter(f, "", ""), f.Close, nil
+}
+
+// httpSink POSTs each summary to a URL as JSON.
+type httpSink struct {
+	url    string
+	client *http.Client
+}
+
+func (s *httpSink) WriteSummary(sum output.ScanSummary) error {
+	body, err := json.Marshal(sum)
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Post(s.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return errors.Errorf("sink returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package cli
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/joelanford/goscan/utils/keywords"
+	"github.com/joelanford/goscan/utils/output"
+	"github.com/joelanford/goscan/utils/scanner"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLandingDir(t *testing.T) {
+	opts := &Opts{CleanDir: "clean", QuarantineDir: "quarantine"}
+	tests := []struct {
+		name     string
+		sum      output.ScanSummary
+		expected string
+	}{
+		{name: "clean", expected: "clean"},
+		{name: "hits", sum: output.ScanSummary{Stats: output.ScanStats{TotalHits: 1}}, expected: "quarantine"},
+		{name: "errors", sum: output.ScanSummary{Stats: output.ScanStats{TotalErrors: 1}}, expected: "quarantine"},
+		{name: "incomplete", sum: output.ScanSummary{Incomplete: true}, expected: "quarantine"},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.expected, landingDir(opts, test.sum), test.name)
+	}
+}
+
+type summaries []output.ScanSummary
+
+func (s *summaries) WriteSummary(sum output.ScanSummary) error {
+	*s = append(*s, sum)
+	return nil
+}
+
+func TestLandingScan(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goscan-watch")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	dir, err = filepath.EvalSymlinks(dir)
+	assert.NoError(t, err)
+	root := filepath.Join(dir, "in")
+	opts := &Opts{
+		InputFile:     root,
+		WatchMove:     true,
+		CleanDir:      filepath.Join(root, "clean"),
+		QuarantineDir: filepath.Join(root, "quarantine"),
+	}
+
+	kw, err := keywords.LoadReader(strings.NewReader("- word: password\n"), nil)
+	assert.NoError(t, err)
+	s, err := scanner.NewScanner(kw, scanner.BaseDir(dir), scanner.Parallelism(1))
+	assert.NoError(t, err)
+	var sink summaries
+	l := &landing{ctx: context.Background(), opts: opts, scanner: s, sink: &sink}
+
+	land := func(name, data string) string {
+		path := filepath.Join(root, name)
+		assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		assert.NoError(t, ioutil.WriteFile(path, []byte(data), 0644))
+		return path
+	}
+
+	//
+	// Each landed file is reported, and moved by what was found in it. A
+	// file that lands again under the same name is moved next to the
+	// first.
+	//
+	l.scan(land("sub/a.txt", "my password"))
+	l.scan(land("sub/b.txt", "nothing"))
+	l.scan(land("sub/b.txt", "still nothing"))
+	if assert.Len(t, sink, 3) {
+		assert.Equal(t, filepath.Join(root, "sub", "a.txt"), sink[0].InputFile)
+		assert.Equal(t, 1, sink[0].Stats.TotalHits)
+		assert.Equal(t, 0, sink[1].Stats.TotalHits)
+		assert.Equal(t, kw.Fingerprint(), sink[1].KeywordsFingerprint)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(opts.QuarantineDir, "sub", "a.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, "my password", string(data))
+	clean, err := filepath.Glob(filepath.Join(opts.CleanDir, "sub", "b.txt*"))
+	assert.NoError(t, err)
+	assert.Len(t, clean, 2)
+	left, err := filepath.Glob(filepath.Join(root, "sub", "*"))
+	assert.NoError(t, err)
+	assert.Empty(t, left)
+
+	//
+	// Files that are gone by the time they are scanned are neither reported
+	// nor moved.
+	//
+	l.scan(filepath.Join(root, "missing.txt"))
+	assert.Len(t, sink, 3)
+
+	//
+	// A watched directory given relative to the working directory still
+	// has the absolute paths that the watcher reports moved.
+	//
+	wd, err := os.Getwd()
+	assert.NoError(t, err)
+	assert.NoError(t, os.Chdir(dir))
+	defer os.Chdir(wd)
+	l.opts, err = parseWatchFlags([]string{"-words", "kw.yaml", "-move", "in"})
+	assert.NoError(t, err)
+	assert.Equal(t, root, l.opts.InputFile)
+	assert.Equal(t, opts.QuarantineDir, l.opts.QuarantineDir)
+
+	l.scan(land("c.txt", "another password"))
+	assert.Len(t, sink, 4)
+	_, err = os.Stat(filepath.Join(opts.QuarantineDir, "c.txt"))
+	assert.NoError(t, err)
+}
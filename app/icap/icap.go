@@ -0,0 +1,478 @@
+// Package icap implements an ICAP (RFC 3507) server that scans the bodies
+// of HTTP requests and responses for keywords, so that proxies can scan
+// traffic in flight.
+package icap
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/textproto"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/joelanford/goscan/utils/keywords"
+	"github.com/joelanford/goscan/utils/output"
+	"github.com/joelanford/goscan/utils/scanner"
+	"github.com/pkg/errors"
+)
+
+const (
+	// ActionBlock replaces messages with hits with a block page.
+	ActionBlock = "block"
+
+	// ActionAnnotate passes messages with hits through, with headers that
+	// describe the hits added to them.
+	ActionAnnotate = "annotate"
+)
+
+// idleTimeout is how long a connection may wait between requests.
+const idleTimeout = 5 * time.Minute
+
+const defaultBlockPage = `<!DOCTYPE html>
+<html>
+<head><title>Blocked</title></head>
+<body>
+<h1>Blocked</h1>
+<p>This content was blocked because it contains {{.Hits}} keyword hit(s){{if .Policies}} for the policies {{join .Policies ", "}}{{end}}.</p>
+{{if .URL}}<p>{{.URL}}</p>{{end}}
+</body>
+</html>
+`
+
+type Option func(*Server) error
+
+// Action sets what is done with messages that have hits, either
+// ActionBlock (the default) or ActionAnnotate.
+func Action(a string) Option {
+	return func(s *Server) error {
+		if a != ActionBlock && a != ActionAnnotate {
+			return errors.Errorf("error: unknown action %q", a)
+		}
+		s.action = a
+		return nil
+	}
+}
+
+// BlockPage sets the html/template that renders the block page. It is
+// executed with a BlockInfo.
+func BlockPage(text string) Option {
+	return func(s *Server) error {
+		t, err := template.New("block").Funcs(template.FuncMap{"join": strings.Join}).Parse(text)
+		if err != nil {
+			return errors.Wrap(err, "error parsing block page")
+		}
+		s.blockPage = t
+		return nil
+	}
+}
+
+// MaxBodySize limits the size of encapsulated bodies to n bytes. Larger
+// bodies are answered with an error, for the client to apply its own
+// policy. A limit of zero means no limit.
+func MaxBodySize(n int64) Option {
+	return func(s *Server) error {
+		if n < 0 {
+			return errors.New("error: max body size must be >= 0")
+		}
+		s.maxBodySize = n
+		return nil
+	}
+}
+
+// Timeout limits how long a request may take, including reading its body.
+// A timeout of zero means no limit.
+func Timeout(d time.Duration) Option {
+	return func(s *Server) error {
+		if d < 0 {
+			return errors.New("error: timeout must be >= 0")
+		}
+		s.timeout = d
+		return nil
+	}
+}
+
+// BlockInfo describes the hits of a blocked message to the block page.
+type BlockInfo struct {
+	URL      string
+	Hits     int
+	Policies []string
+}
+
+// Server is an ICAP server with REQMOD and RESPMOD services. Any service
+// path is accepted; OPTIONS requests for paths ending in "reqmod" or
+// "respmod" advertise only that method.
+//
+// Encapsulated bodies are scanned as streams, so compressed bodies and
+// archives are scanned like any other input. Messages without hits are
+// answered with 204 No Content when the client allows it, and returned
+// unmodified otherwise. Messages with hits are either replaced with a 403
+// response with the block page, or returned with X-Goscan-Hits and
+// X-Goscan-Policies headers added, depending on the action. Responses to
+// messages with hits carry the same headers.
+//
+// The ISTag of responses is derived from the fingerprint of the keywords,
+// so that clients drop cached responses when the keywords change.
+type Server struct {
+	scanner *scanner.Scanner
+	action  string
+	timeout time.Duration
+
+	blockPage   *template.Template
+	maxBodySize int64
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu        sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+}
+
+// New returns a server that scans with s. Each request is scanned with the
+// keywords s has when it starts.
+func New(s *scanner.Scanner, opts ...Option) (*Server, error) {
+	srv := &Server{
+		scanner:   s,
+		action:    ActionBlock,
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[net.Conn]struct{}),
+	}
+	if err := BlockPage(defaultBlockPage)(srv); err != nil {
+		return nil, err
+	}
+	for _, o := range opts {
+		if err := o(srv); err != nil {
+			return nil, err
+		}
+	}
+	srv.ctx, srv.cancel = context.WithCancel(context.Background())
+	return srv, nil
+}
+
+// Serve accepts connections on l until the server is closed, in which case
+// it returns nil.
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.ctx.Err() != nil {
+		s.mu.Unlock()
+		return nil
+	}
+	s.listeners[l] = struct{}{}
+	s.mu.Unlock()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if s.ctx.Err() != nil {
+				return nil
+			}
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				time.Sleep(10 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+		s.mu.Lock()
+		if s.ctx.Err() != nil {
+			s.mu.Unlock()
+			conn.Close()
+			return nil
+		}
+		s.conns[conn] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+		go s.serveConn(conn)
+	}
+}
+
+// Close stops the listeners, cancels the scans in progress, closes the
+// connections, and waits for them to be done.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.cancel()
+	for l := range s.listeners {
+		l.Close()
+	}
+	for c := range s.conns {
+		c.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return nil
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		conn.Close()
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+	}()
+
+	br := bufio.NewReader(conn)
+	bw := bufio.NewWriter(conn)
+	readContinue := func() error {
+		bw.WriteString("ICAP/1.0 100 Continue\r\n\r\n")
+		return bw.Flush()
+	}
+	for {
+		conn.SetReadDeadline(time.Now().Add(idleTimeout))
+		req, err := readRequest(br, readContinue)
+		if err != nil {
+			if pe, ok := err.(protocolError); ok {
+				s.writeError(bw, pe.code, pe)
+			}
+			return
+		}
+		if s.timeout > 0 {
+			conn.SetReadDeadline(time.Now().Add(s.timeout))
+		} else {
+			conn.SetReadDeadline(time.Time{})
+		}
+
+		//
+		// After an error, the rest of the body may still be on its way, so
+		// the connection can't be used for another request.
+		//
+		resp, err := s.handle(req)
+		if err != nil {
+			code := 500
+			if pe, ok := errors.Cause(err).(protocolError); ok {
+				code = pe.code
+			}
+			s.writeError(bw, code, err)
+			return
+		}
+		if err := resp.write(bw); err != nil {
+			return
+		}
+		if strings.EqualFold(req.Header.Get("Connection"), "close") {
+			return
+		}
+	}
+}
+
+func (s *Server) writeError(w *bufio.Writer, code int, err error) {
+	h := header(s.scanner.Keywords())
+	h.Set("Connection", "close")
+	h.Set("X-Goscan-Error", strings.Map(func(r rune) rune {
+		if r == '\r' || r == '\n' {
+			return ' '
+		}
+		return r
+	}, err.Error()))
+	resp := response{Code: code, Header: h}
+	resp.write(w)
+}
+
+// header returns the headers of every response to a request that was
+// handled with kw.
+func header(kw *keywords.Keywords) textproto.MIMEHeader {
+	return textproto.MIMEHeader{
+		"ISTag":   {strconv.Quote("goscan-" + kw.Fingerprint()[:16])},
+		"Service": {"goscan"},
+	}
+}
+
+func (s *Server) handle(req *request) (*response, error) {
+	switch req.Method {
+	case "OPTIONS":
+		if req.Body != nil {
+			if _, err := io.Copy(ioutil.Discard, req.Body); err != nil {
+				return nil, err
+			}
+		}
+		return s.options(req), nil
+	case "REQMOD", "RESPMOD":
+		return s.modify(req)
+	default:
+		return nil, protocolError{code: 501, err: errors.Errorf("method %s not implemented", req.Method)}
+	}
+}
+
+func (s *Server) options(req *request) *response {
+	methods := "REQMOD, RESPMOD"
+	if u, err := url.Parse(req.URI); err == nil {
+		switch p := strings.ToLower(u.Path); {
+		case strings.HasSuffix(p, "respmod"):
+			methods = "RESPMOD"
+		case strings.HasSuffix(p, "reqmod"):
+			methods = "REQMOD"
+		}
+	}
+	h := header(s.scanner.Keywords())
+	h.Set("Methods", methods)
+	h.Set("Allow", "204")
+	h.Set("Options-TTL", "3600")
+	return &response{Code: 200, Header: h}
+}
+
+// modify scans the body of a REQMOD or RESPMOD request, and returns the
+// response to it.
+func (s *Server) modify(req *request) (*response, error) {
+	//
+	// The message that is modified is the request for REQMOD, and the
+	// response for RESPMOD.
+	//
+	hdr := req.ReqHdr
+	if req.Method == "RESPMOD" {
+		hdr = req.ResHdr
+	}
+	info := BlockInfo{URL: requestURL(req.ReqHdr)}
+	kw := s.scanner.Keywords()
+
+	//
+	// The body is kept when it might have to be sent back.
+	//
+	var body bytes.Buffer
+	if req.Body != nil {
+		ctx, cancel := context.WithCancel(s.ctx)
+		defer cancel()
+		if s.timeout > 0 {
+			ctx, cancel = context.WithTimeout(ctx, s.timeout)
+			defer cancel()
+		}
+		var r io.Reader = req.Body
+		if s.maxBodySize > 0 {
+			r = &limitReader{r: r, n: s.maxBodySize}
+		}
+		if s.action == ActionAnnotate || !req.allow204() {
+			r = io.TeeReader(r, &body)
+		}
+
+		policies := make(map[string]bool)
+		err := s.scanner.ScanWith(ctx, kw, scanner.Stream(bodyName(info.URL), r), scanner.HandlerFunc(func(sr output.ScanResult) error {
+			for _, hit := range sr.Hits {
+				info.Hits++
+				for p := range hit.Policies {
+					policies[p] = true
+				}
+			}
+			return nil
+		}))
+		if err == nil {
+			_, err = io.Copy(ioutil.Discard, r)
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "error scanning body")
+		}
+		for p := range policies {
+			info.Policies = append(info.Policies, p)
+		}
+		sort.Strings(info.Policies)
+	}
+
+	h := header(kw)
+	if info.Hits == 0 {
+		if req.allow204() {
+			return &response{Code: 204, Header: h}, nil
+		}
+		return message(req, h, hdr, body.Bytes()), nil
+	}
+
+	annotations := textproto.MIMEHeader{"X-Goscan-Hits": {strconv.Itoa(info.Hits)}}
+	if len(info.Policies) > 0 {
+		annotations["X-Goscan-Policies"] = []string{strings.Join(info.Policies, ",")}
+	}
+	for k, v := range annotations {
+		h[k] = v
+	}
+	if s.action == ActionAnnotate {
+		if hdr != nil {
+			hdr = addHeaders(hdr, annotations)
+		}
+		return message(req, h, hdr, body.Bytes()), nil
+	}
+
+	var page bytes.Buffer
+	if err := s.blockPage.Execute(&page, info); err != nil {
+		return nil, errors.Wrap(err, "error rendering block page")
+	}
+	resHdr := fmt.Sprintf("HTTP/1.1 403 Forbidden\r\nContent-Type: text/html; charset=utf-8\r\nContent-Length: %d\r\nCache-Control: no-store\r\nConnection: close\r\n\r\n", page.Len())
+	return &response{
+		Code:     200,
+		Header:   h,
+		ResHdr:   []byte(resHdr),
+		BodyType: "res-body",
+		Body:     page.Bytes(),
+	}, nil
+}
+
+// message returns a response with the message of req, with its headers
+// replaced with hdr and its body with body.
+func message(req *request, h textproto.MIMEHeader, hdr, body []byte) *response {
+	resp := &response{Code: 200, Header: h}
+	if req.Method == "RESPMOD" {
+		resp.ResHdr = hdr
+	} else {
+		resp.ReqHdr = hdr
+	}
+	if req.BodyType != "" {
+		resp.BodyType = req.BodyType
+		resp.Body = body
+	}
+	return resp
+}
+
+// requestURL returns the URL in the request line of the encapsulated HTTP
+// request headers hdr, or an empty string.
+func requestURL(hdr []byte) string {
+	line := hdr
+	if i := bytes.IndexByte(hdr, '\n'); i >= 0 {
+		line = hdr[:i]
+	}
+	parts := strings.Fields(string(line))
+	if len(parts) != 3 {
+		return ""
+	}
+	return parts[1]
+}
+
+// bodyName returns the name that the body of a message for rawURL is
+// scanned as.
+func bodyName(rawURL string) string {
+	if u, err := url.Parse(rawURL); err == nil {
+		if name := path.Base(u.Path); name != "." && name != "/" {
+			return name
+		}
+	}
+	return "body"
+}
+
+var errTooLarge = errors.New("body exceeds maximum size")
+
+// limitReader returns errTooLarge once more than n bytes are read from r.
+type limitReader struct {
+	r   io.Reader
+	n   int64
+	err error
+}
+
+func (l *limitReader) Read(p []byte) (int, error) {
+	if l.err != nil {
+		return 0, l.err
+	}
+	if int64(len(p)) > l.n+1 {
+		p = p[:l.n+1]
+	}
+	n, err := l.r.Read(p)
+	if int64(n) > l.n {
+		n, l.err = int(l.n), errTooLarge
+		return n, l.err
+	}
+	l.n -= int64(n)
+	return n, err
+}
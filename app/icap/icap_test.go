@@ -0,0 +1,213 @@
+package icap_test
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/textproto"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/joelanford/goscan/app/icap"
+	"github.com/joelanford/goscan/utils/keywords"
+	"github.com/joelanford/goscan/utils/scanner"
+	"github.com/stretchr/testify/assert"
+)
+
+func newServer(t *testing.T, opts ...icap.Option) net.Conn {
+	kw, err := keywords.LoadReader(strings.NewReader("- word: password\n  policies:\n    p1: x\n"), nil)
+	assert.NoError(t, err)
+	base, err := ioutil.TempDir("", "goscan-icap")
+	assert.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(base) })
+
+	s, err := scanner.NewScanner(kw, scanner.BaseDir(base), scanner.Parallelism(2), scanner.NativeUnarchive(true))
+	assert.NoError(t, err)
+	srv, err := icap.New(s, opts...)
+	assert.NoError(t, err)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	go srv.Serve(l)
+	t.Cleanup(func() { srv.Close() })
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	assert.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// client is a minimal ICAP client.
+type client struct {
+	conn net.Conn
+	br   *bufio.Reader
+}
+
+type response struct {
+	code   int
+	header textproto.MIMEHeader
+	hdr    string
+	body   string
+}
+
+// do sends a request with the encapsulated request headers reqHdr, the
+// response headers resHdr, and body. With a preview of n bytes, it waits
+// for 100 Continue before sending the rest of the body.
+func (c *client) do(t *testing.T, method, reqHdr, resHdr, body string, allow204 bool, preview int) response {
+	var enc []string
+	off := 0
+	if reqHdr != "" {
+		enc = append(enc, fmt.Sprintf("req-hdr=%d", off))
+		off += len(reqHdr)
+	}
+	if resHdr != "" {
+		enc = append(enc, fmt.Sprintf("res-hdr=%d", off))
+		off += len(resHdr)
+	}
+	bodyType := "req-body"
+	if method == "RESPMOD" {
+		bodyType = "res-body"
+	}
+	enc = append(enc, fmt.Sprintf("%s=%d", bodyType, off))
+
+	var req bytes.Buffer
+	fmt.Fprintf(&req, "%s icap://localhost/%s ICAP/1.0\r\nHost: localhost\r\n", method, strings.ToLower(method))
+	fmt.Fprintf(&req, "Encapsulated: %s\r\n", strings.Join(enc, ", "))
+	if allow204 {
+		req.WriteString("Allow: 204\r\n")
+	}
+	rest := body
+	if preview >= 0 {
+		if preview > len(body) {
+			preview = len(body)
+		}
+		fmt.Fprintf(&req, "Preview: %d\r\n", preview)
+		rest = body[preview:]
+		body = body[:preview]
+	}
+	req.WriteString("\r\n" + reqHdr + resHdr)
+	if body != "" {
+		fmt.Fprintf(&req, "%x\r\n%s\r\n", len(body), body)
+	}
+	if preview >= 0 && rest == "" {
+		req.WriteString("0; ieof\r\n\r\n")
+	} else {
+		req.WriteString("0\r\n\r\n")
+	}
+	_, err := c.conn.Write(req.Bytes())
+	assert.NoError(t, err)
+
+	if preview >= 0 && rest != "" {
+		tp := textproto.NewReader(c.br)
+		line, err := tp.ReadLine()
+		assert.NoError(t, err)
+		assert.Equal(t, "ICAP/1.0 100 Continue", line)
+		_, err = tp.ReadMIMEHeader()
+		assert.NoError(t, err)
+		_, err = fmt.Fprintf(c.conn, "%x\r\n%s\r\n0\r\n\r\n", len(rest), rest)
+		assert.NoError(t, err)
+	}
+	return c.read(t)
+}
+
+func (c *client) read(t *testing.T) response {
+	tp := textproto.NewReader(c.br)
+	line, err := tp.ReadLine()
+	assert.NoError(t, err)
+	var resp response
+	fmt.Sscanf(line, "ICAP/1.0 %d", &resp.code)
+	resp.header, err = tp.ReadMIMEHeader()
+	assert.NoError(t, err)
+
+	//
+	// The encapsulated headers run up to the body offset, and the body is
+	// chunked.
+	//
+	var hdrLen int
+	bodyType := ""
+	for _, f := range strings.Split(resp.header.Get("Encapsulated"), ",") {
+		kv := strings.SplitN(strings.TrimSpace(f), "=", 2)
+		if strings.HasSuffix(kv[0], "-body") {
+			hdrLen, _ = strconv.Atoi(kv[1])
+			bodyType = kv[0]
+		}
+	}
+	if bodyType == "" {
+		return resp
+	}
+	hdr := make([]byte, hdrLen)
+	_, err = io.ReadFull(c.br, hdr)
+	assert.NoError(t, err)
+	resp.hdr = string(hdr)
+	if bodyType == "null-body" {
+		return resp
+	}
+	var body bytes.Buffer
+	for {
+		line, err := tp.ReadLine()
+		assert.NoError(t, err)
+		n, err := strconv.ParseInt(line, 16, 64)
+		assert.NoError(t, err)
+		if n == 0 {
+			tp.ReadLine()
+			break
+		}
+		_, err = io.CopyN(&body, c.br, n)
+		assert.NoError(t, err)
+		tp.ReadLine()
+	}
+	resp.body = body.String()
+	return resp
+}
+
+const reqHdr = "POST /upload/notes.txt HTTP/1.1\r\nHost: example.com\r\n\r\n"
+
+func TestBlock(t *testing.T) {
+	conn := newServer(t)
+	c := &client{conn: conn, br: bufio.NewReader(conn)}
+
+	fmt.Fprintf(conn, "OPTIONS icap://localhost/reqmod ICAP/1.0\r\nHost: localhost\r\n\r\n")
+	resp := c.read(t)
+	assert.Equal(t, 200, resp.code)
+	assert.Equal(t, "REQMOD", resp.header.Get("Methods"))
+	assert.NotEmpty(t, resp.header.Get("ISTag"))
+
+	resp = c.do(t, "REQMOD", reqHdr, "", "nothing to see", true, -1)
+	assert.Equal(t, 204, resp.code)
+
+	resp = c.do(t, "REQMOD", reqHdr, "", "nothing to see", false, -1)
+	assert.Equal(t, 200, resp.code)
+	assert.Equal(t, reqHdr, resp.hdr)
+	assert.Equal(t, "nothing to see", resp.body)
+
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write([]byte("the password is hunter2"))
+	zw.Close()
+	resHdr := "HTTP/1.1 200 OK\r\nContent-Encoding: gzip\r\n\r\n"
+	resp = c.do(t, "RESPMOD", "GET /a HTTP/1.1\r\nHost: example.com\r\n\r\n", resHdr, gz.String(), true, 4)
+	assert.Equal(t, 200, resp.code)
+	assert.Equal(t, "p1", resp.header.Get("X-Goscan-Policies"))
+	assert.True(t, strings.HasPrefix(resp.hdr, "HTTP/1.1 403 Forbidden\r\n"))
+	assert.Contains(t, resp.body, "Blocked")
+}
+
+func TestAnnotate(t *testing.T) {
+	conn := newServer(t, icap.Action(icap.ActionAnnotate), icap.MaxBodySize(64))
+	c := &client{conn: conn, br: bufio.NewReader(conn)}
+
+	resp := c.do(t, "REQMOD", reqHdr, "", "my password", true, 0)
+	assert.Equal(t, 200, resp.code)
+	assert.Equal(t, "1", resp.header.Get("X-Goscan-Hits"))
+	assert.Contains(t, resp.hdr, "\r\nX-Goscan-Hits: 1\r\nX-Goscan-Policies: p1\r\n\r\n")
+	assert.Equal(t, "my password", resp.body)
+
+	resp = c.do(t, "REQMOD", reqHdr, "", strings.Repeat("x", 100), true, -1)
+	assert.Equal(t, 500, resp.code)
+	assert.Contains(t, resp.header.Get("X-Goscan-Error"), "maximum size")
+}
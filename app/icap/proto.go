@@ -0,0 +1,342 @@
+package icap
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net/textproto"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var statusText = map[int]string{
+	100: "Continue",
+	200: "OK",
+	204: "No Content",
+	400: "Bad Request",
+	404: "ICAP Service Not Found",
+	405: "Method Not Allowed For Service",
+	500: "Server Error",
+	501: "Method Not Implemented",
+	505: "ICAP Version Not Supported",
+}
+
+// request is an ICAP request. The encapsulated HTTP headers are kept as
+// they were sent, and the encapsulated body, if any, is read from Body.
+type request struct {
+	Method string
+	URI    string
+	Header textproto.MIMEHeader
+
+	ReqHdr []byte
+	ResHdr []byte
+
+	// BodyType is "req-body" or "res-body", or empty if there is no body.
+	BodyType string
+	Body     *chunkReader
+}
+
+// protocolError is an error caused by a malformed request.
+type protocolError struct {
+	code int
+	err  error
+}
+
+func (e protocolError) Error() string {
+	return e.err.Error()
+}
+
+func badRequest(format string, args ...interface{}) error {
+	return protocolError{code: 400, err: errors.Errorf(format, args...)}
+}
+
+// readRequest reads the next request from br. When a preview of the body
+// has been read and the client is waiting for the rest, readContinue is
+// called to ask for it.
+func readRequest(br *bufio.Reader, readContinue func() error) (*request, error) {
+	tp := textproto.NewReader(br)
+	line, err := tp.ReadLine()
+	if err != nil {
+		return nil, err
+	}
+	parts := strings.Fields(line)
+	if len(parts) != 3 {
+		return nil, badRequest("malformed request line %q", line)
+	}
+	if parts[2] != "ICAP/1.0" {
+		return nil, protocolError{code: 505, err: errors.Errorf("unsupported version %q", parts[2])}
+	}
+	header, err := tp.ReadMIMEHeader()
+	if err != nil {
+		return nil, badRequest("malformed headers: %v", err)
+	}
+	req := &request{
+		Method: parts[0],
+		URI:    parts[1],
+		Header: header,
+	}
+
+	sections, err := parseEncapsulated(header.Get("Encapsulated"))
+	if err != nil {
+		return nil, err
+	}
+	for i, sec := range sections {
+		if !strings.HasSuffix(sec.name, "-hdr") {
+			if i != len(sections)-1 {
+				return nil, badRequest("%s must be the last encapsulated section", sec.name)
+			}
+			if sec.name != "null-body" {
+				req.BodyType = sec.name
+			}
+			break
+		}
+		if i == len(sections)-1 {
+			return nil, badRequest("encapsulated headers must be followed by a body section")
+		}
+		b := make([]byte, sections[i+1].offset-sec.offset)
+		if _, err := io.ReadFull(br, b); err != nil {
+			return nil, err
+		}
+		switch sec.name {
+		case "req-hdr":
+			req.ReqHdr = b
+		case "res-hdr":
+			req.ResHdr = b
+		}
+	}
+
+	if req.BodyType != "" {
+		req.Body = &chunkReader{br: br}
+		if header.Get("Preview") != "" {
+			req.Body.readContinue = readContinue
+		}
+	}
+	return req, nil
+}
+
+// allow204 returns whether the client accepts a 204 response in place of
+// its unmodified message.
+func (req *request) allow204() bool {
+	for _, v := range req.Header["Allow"] {
+		for _, a := range strings.Split(v, ",") {
+			if strings.TrimSpace(a) == "204" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+type section struct {
+	name   string
+	offset int
+}
+
+// parseEncapsulated parses an Encapsulated header, such as
+// "req-hdr=0, res-hdr=137, res-body=296".
+func parseEncapsulated(v string) ([]section, error) {
+	if v == "" {
+		return nil, nil
+	}
+	var sections []section
+	for _, f := range strings.Split(v, ",") {
+		kv := strings.SplitN(strings.TrimSpace(f), "=", 2)
+		if len(kv) != 2 {
+			return nil, badRequest("malformed Encapsulated header %q", v)
+		}
+		off, err := strconv.Atoi(kv[1])
+		if err != nil || off < 0 {
+			return nil, badRequest("malformed Encapsulated header %q", v)
+		}
+		switch kv[0] {
+		case "req-hdr", "res-hdr", "req-body", "res-body", "null-body", "opt-body":
+		default:
+			return nil, badRequest("unknown encapsulated section %q", kv[0])
+		}
+		if n := len(sections); n > 0 && off < sections[n-1].offset {
+			return nil, badRequest("malformed Encapsulated header %q", v)
+		}
+		sections = append(sections, section{name: kv[0], offset: off})
+	}
+	return sections, nil
+}
+
+// chunkReader reads a chunked encapsulated body. If readContinue is set,
+// the body starts with a preview, and readContinue is called to ask for
+// the rest of it once the preview has been read, unless the client said
+// that the preview was all of it.
+type chunkReader struct {
+	br           *bufio.Reader
+	readContinue func() error
+
+	remaining int64
+	eof       bool
+	err       error
+}
+
+func (c *chunkReader) Read(p []byte) (int, error) {
+	for c.remaining == 0 {
+		if c.err != nil {
+			return 0, c.err
+		}
+		if c.eof {
+			return 0, io.EOF
+		}
+		c.err = c.next()
+	}
+	if int64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+	n, err := c.br.Read(p)
+	c.remaining -= int64(n)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err == nil && c.remaining == 0 {
+		err = c.readCRLF()
+	}
+	if err != nil {
+		c.err = err
+	}
+	return n, err
+}
+
+func (c *chunkReader) next() error {
+	line, err := c.readLine()
+	if err != nil {
+		return err
+	}
+	size, ext := line, ""
+	if i := strings.IndexByte(line, ';'); i >= 0 {
+		size, ext = line[:i], line[i+1:]
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(size), 16, 64)
+	if err != nil || n < 0 {
+		return badRequest("malformed chunk size %q", line)
+	}
+	if n > 0 {
+		c.remaining = n
+		return nil
+	}
+
+	//
+	// The last chunk may be followed by trailers, which are ignored, up
+	// to an empty line.
+	//
+	for {
+		l, err := c.readLine()
+		if err != nil {
+			return err
+		}
+		if l == "" {
+			break
+		}
+	}
+	if c.readContinue != nil && strings.TrimSpace(ext) != "ieof" {
+		readContinue := c.readContinue
+		c.readContinue = nil
+		return readContinue()
+	}
+	c.readContinue = nil
+	c.eof = true
+	return nil
+}
+
+func (c *chunkReader) readLine() (string, error) {
+	line, err := c.br.ReadString('\n')
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return strings.TrimRight(line, "\r\n"), err
+}
+
+func (c *chunkReader) readCRLF() error {
+	l, err := c.readLine()
+	if err == nil && l != "" {
+		err = badRequest("malformed chunk")
+	}
+	return err
+}
+
+// response is an ICAP response. Encapsulated headers are written in order,
+// followed by the body if BodyType is set.
+type response struct {
+	Code   int
+	Header textproto.MIMEHeader
+
+	ReqHdr []byte
+	ResHdr []byte
+
+	BodyType string
+	Body     []byte
+}
+
+func (resp *response) write(w *bufio.Writer) error {
+	var enc []string
+	off := 0
+	if resp.ReqHdr != nil {
+		enc = append(enc, fmt.Sprintf("req-hdr=%d", off))
+		off += len(resp.ReqHdr)
+	}
+	if resp.ResHdr != nil {
+		enc = append(enc, fmt.Sprintf("res-hdr=%d", off))
+		off += len(resp.ResHdr)
+	}
+	if resp.BodyType != "" {
+		enc = append(enc, fmt.Sprintf("%s=%d", resp.BodyType, off))
+	} else {
+		enc = append(enc, fmt.Sprintf("null-body=%d", off))
+	}
+	if resp.Header == nil {
+		resp.Header = make(textproto.MIMEHeader)
+	}
+	resp.Header.Set("Encapsulated", strings.Join(enc, ", "))
+
+	fmt.Fprintf(w, "ICAP/1.0 %d %s\r\n", resp.Code, statusText[resp.Code])
+	keys := make([]string, 0, len(resp.Header))
+	for k := range resp.Header {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, v := range resp.Header[k] {
+			fmt.Fprintf(w, "%s: %s\r\n", k, v)
+		}
+	}
+	w.WriteString("\r\n")
+	w.Write(resp.ReqHdr)
+	w.Write(resp.ResHdr)
+	if resp.BodyType != "" {
+		if len(resp.Body) > 0 {
+			fmt.Fprintf(w, "%x\r\n", len(resp.Body))
+			w.Write(resp.Body)
+			w.WriteString("\r\n")
+		}
+		w.WriteString("0\r\n\r\n")
+	}
+	return w.Flush()
+}
+
+// addHeaders returns the encapsulated HTTP headers hdr with the headers h
+// added to the end.
+func addHeaders(hdr []byte, h textproto.MIMEHeader) []byte {
+	var buf bytes.Buffer
+	buf.Write(bytes.TrimRight(hdr, "\r\n"))
+	buf.WriteString("\r\n")
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, v := range h[k] {
+			fmt.Fprintf(&buf, "%s: %s\r\n", k, v)
+		}
+	}
+	buf.WriteString("\r\n")
+	return buf.Bytes()
+}
@@ -0,0 +1,258 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/joelanford/goscan/utils/keywords"
+	"github.com/joelanford/goscan/utils/output"
+	"github.com/joelanford/goscan/utils/scanner"
+	"github.com/pkg/errors"
+)
+
+const (
+	jobRunning  = "running"
+	jobDone     = "done"
+	jobFailed   = "failed"
+	jobCanceled = "canceled"
+)
+
+// Job is an asynchronous scan.
+type Job struct {
+	ID       string              `json:"id"`
+	Status   string              `json:"status"`
+	Created  time.Time           `json:"created"`
+	Finished *time.Time          `json:"finished,omitempty"`
+	Error    string              `json:"error,omitempty"`
+	Summary  *output.ScanSummary `json:"summary,omitempty"`
+
+	cancel context.CancelFunc
+}
+
+// jobs holds the jobs of a server. Finished jobs are removed once they are
+// older than ttl.
+type jobs struct {
+	ttl time.Duration
+
+	mu   sync.Mutex
+	byID map[string]*Job
+}
+
+func newJobs(ttl time.Duration) *jobs {
+	return &jobs{
+		ttl:  ttl,
+		byID: make(map[string]*Job),
+	}
+}
+
+func (js *jobs) add(j *Job) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	js.expire()
+	js.byID[j.ID] = j
+}
+
+// get returns a copy of the job with id.
+func (js *jobs) get(id string) (Job, bool) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	js.expire()
+	j, ok := js.byID[id]
+	if !ok {
+		return Job{}, false
+	}
+	return *j, true
+}
+
+// remove removes the job with id, and cancels it if it is running.
+func (js *jobs) remove(id string) bool {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	j, ok := js.byID[id]
+	if !ok {
+		return false
+	}
+	if j.Status == jobRunning {
+		j.cancel()
+	}
+	delete(js.byID, id)
+	return true
+}
+
+func (js *jobs) finish(id string, sum *output.ScanSummary, err error) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	j, ok := js.byID[id]
+	if !ok {
+		return
+	}
+	now := time.Now()
+	j.Finished = &now
+	switch {
+	case err == nil:
+		j.Status = jobDone
+		j.Summary = sum
+	case errors.Cause(err) == context.Canceled:
+		j.Status = jobCanceled
+		j.Error = err.Error()
+	default:
+		j.Status = jobFailed
+		j.Error = err.Error()
+	}
+}
+
+func (js *jobs) running() int {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	n := 0
+	for _, j := range js.byID {
+		if j.Status == jobRunning {
+			n++
+		}
+	}
+	return n
+}
+
+func (js *jobs) expire() {
+	for id, j := range js.byID {
+		if j.Finished != nil && time.Since(*j.Finished) > js.ttl {
+			delete(js.byID, id)
+		}
+	}
+}
+
+// spooled is an uploaded file that is waiting to be scanned.
+type spooled struct {
+	name string
+	path string
+}
+
+func (s *Server) handleCreateJob(w http.ResponseWriter, r *http.Request) {
+	if s.maxBodySize > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, s.maxBodySize)
+	}
+	hitsOnly := r.URL.Query().Get("hitsonly") == "true"
+
+	//
+	// The request body is gone once we respond, so it is written to disk
+	// until the job scans it.
+	//
+	var files []spooled
+	removeFiles := func() {
+		for _, f := range files {
+			os.Remove(f.path)
+		}
+	}
+	err := eachFile(r, func(name string, body io.Reader) error {
+		f, err := os.CreateTemp(s.spoolDir, "goscan-job")
+		if err != nil {
+			return err
+		}
+		files = append(files, spooled{name: name, path: f.Name()})
+		_, err = io.Copy(f, body)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		return err
+	})
+	if err != nil {
+		removeFiles()
+		writeError(w, err)
+		return
+	}
+
+	id, err := newJobID()
+	if err != nil {
+		removeFiles()
+		writeError(w, err)
+		return
+	}
+	ctx, cancel := context.WithCancel(s.ctx)
+	j := &Job{
+		ID:      id,
+		Status:  jobRunning,
+		Created: time.Now(),
+		cancel:  cancel,
+	}
+	created := *j
+	s.jobs.add(j)
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer cancel()
+		defer removeFiles()
+		ctx := ctx
+		if s.timeout > 0 {
+			var cancelTimeout context.CancelFunc
+			ctx, cancelTimeout = context.WithTimeout(ctx, s.timeout)
+			defer cancelTimeout()
+		}
+
+		kw := s.scanner.Keywords()
+		sum := &output.ScanSummary{
+			Results:             make([]output.ScanResult, 0),
+			KeywordsFingerprint: kw.Fingerprint(),
+		}
+		h := scanner.HandlerFunc(func(sr output.ScanResult) error {
+			sum.Add(sr, hitsOnly)
+			return nil
+		})
+		start := time.Now()
+		var names []string
+		var err error
+		for _, f := range files {
+			names = append(names, f.name)
+			if err = s.scanSpooled(ctx, kw, f, h); err != nil {
+				break
+			}
+		}
+		sum.InputFile = strings.Join(names, ", ")
+		sum.Stats.Duration = time.Since(start).Seconds()
+		s.jobs.finish(id, sum, err)
+	}()
+
+	w.Header().Set("Location", "/jobs/"+id)
+	writeJSON(w, http.StatusAccepted, created)
+}
+
+func (s *Server) scanSpooled(ctx context.Context, kw *keywords.Keywords, f spooled, h scanner.Handler) error {
+	r, err := os.Open(f.path)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return s.scan(ctx, kw, scanner.Stream(f.name, r), h)
+}
+
+func (s *Server) handleGetJob(w http.ResponseWriter, r *http.Request, id string) {
+	j, ok := s.jobs.get(id)
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "job not found"})
+		return
+	}
+	writeJSON(w, http.StatusOK, j)
+}
+
+func (s *Server) handleDeleteJob(w http.ResponseWriter, r *http.Request, id string) {
+	if !s.jobs.remove(id) {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "job not found"})
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func newJobID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,319 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/joelanford/goscan/utils/keywords"
+	"github.com/joelanford/goscan/utils/output"
+	"github.com/joelanford/goscan/utils/scanner"
+	"github.com/pkg/errors"
+)
+
+type Option func(*Server) error
+
+// MaxBodySize limits the size of request bodies to n bytes. A limit of
+// zero means no limit.
+func MaxBodySize(n int64) Option {
+	return func(s *Server) error {
+		if n < 0 {
+			return errors.New("error: max body size must be >= 0")
+		}
+		s.maxBodySize = n
+		return nil
+	}
+}
+
+// Timeout limits how long a scan may take, including reading the request
+// body of a synchronous scan. A timeout of zero means no limit.
+func Timeout(d time.Duration) Option {
+	return func(s *Server) error {
+		if d < 0 {
+			return errors.New("error: timeout must be >= 0")
+		}
+		s.timeout = d
+		return nil
+	}
+}
+
+// JobTTL is how long the results of finished jobs are kept.
+func JobTTL(d time.Duration) Option {
+	return func(s *Server) error {
+		if d <= 0 {
+			return errors.New("error: job TTL must be > 0")
+		}
+		s.jobTTL = d
+		return nil
+	}
+}
+
+// SpoolDir is the directory that job uploads are written to until they
+// are scanned.
+func SpoolDir(dir string) Option {
+	return func(s *Server) error {
+		s.spoolDir = dir
+		return nil
+	}
+}
+
+// Server is an HTTP scanning service. Scans share the parallelism of its
+// scanner, whatever the number of requests.
+//
+//	POST   /scan       scan the request body, and return the summary
+//	POST   /jobs       start scanning the request body, and return the job
+//	GET    /jobs/{id}  return a job, with its summary once it is done
+//	DELETE /jobs/{id}  cancel and remove a job
+//	GET    /healthz    report that the server is up
+//
+// Bodies are either a raw file, named by the name query parameter, or a
+// multipart form whose file parts are all scanned. With hitsonly=true,
+// only results with hits or errors are returned. /scan returns the summary
+// as JSON, or as a JSON line per result followed by a line with the stats
+// with format=ndjson or an Accept header of application/x-ndjson.
+type Server struct {
+	scanner *scanner.Scanner
+
+	maxBodySize int64
+	timeout     time.Duration
+	jobTTL      time.Duration
+	spoolDir    string
+
+	mux    *http.ServeMux
+	active int64
+	jobs   *jobs
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// New returns a server that scans with s. Each request is scanned with
+// the keywords s has when it starts.
+func New(s *scanner.Scanner, opts ...Option) (*Server, error) {
+	srv := &Server{
+		scanner: s,
+		jobTTL:  time.Hour,
+		mux:     http.NewServeMux(),
+	}
+	for _, o := range opts {
+		if err := o(srv); err != nil {
+			return nil, err
+		}
+	}
+	srv.jobs = newJobs(srv.jobTTL)
+	srv.ctx, srv.cancel = context.WithCancel(context.Background())
+
+	srv.mux.HandleFunc("/scan", method("POST", srv.handleScan))
+	srv.mux.HandleFunc("/jobs", method("POST", srv.handleCreateJob))
+	srv.mux.HandleFunc("/jobs/", func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimPrefix(r.URL.Path, "/jobs/")
+		switch r.Method {
+		case "GET":
+			srv.handleGetJob(w, r, id)
+		case "DELETE":
+			srv.handleDeleteJob(w, r, id)
+		default:
+			w.Header().Set("Allow", "GET, DELETE")
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		}
+	})
+	srv.mux.HandleFunc("/healthz", method("GET", srv.handleHealth))
+	return srv, nil
+}
+
+// method only lets requests with method m through to h.
+func method(m string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != m {
+			w.Header().Set("Allow", m)
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+			return
+		}
+		h(w, r)
+	}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// Close cancels the running jobs, and waits for them to stop.
+func (s *Server) Close() {
+	s.cancel()
+	s.wg.Wait()
+}
+
+func (s *Server) handleScan(w http.ResponseWriter, r *http.Request) {
+	if s.maxBodySize > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, s.maxBodySize)
+	}
+	ctx := r.Context()
+	if s.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.timeout)
+		defer cancel()
+	}
+	q := r.URL.Query()
+	hitsOnly := q.Get("hitsonly") == "true"
+	ndjson := q.Get("format") == "ndjson" || strings.Contains(r.Header.Get("Accept"), "application/x-ndjson")
+
+	//
+	// NDJSON results are streamed as they are found, so errors after the
+	// first one can only be reported in the last line.
+	//
+	kw := s.scanner.Keywords()
+	var sum output.ScanSummary
+	sum.Results = make([]output.ScanResult, 0)
+	sum.KeywordsFingerprint = kw.Fingerprint()
+	var enc *json.Encoder
+	if ndjson {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		enc = json.NewEncoder(w)
+	}
+	flusher, _ := w.(http.Flusher)
+	h := scanner.HandlerFunc(func(sr output.ScanResult) error {
+		sum.Add(sr, hitsOnly)
+		if enc != nil && (!hitsOnly || len(sr.Hits) > 0 || len(sr.Errors) > 0) {
+			if err := enc.Encode(sr); err != nil {
+				return err
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		return nil
+	})
+
+	start := time.Now()
+	var names []string
+	err := eachFile(r, func(name string, body io.Reader) error {
+		names = append(names, name)
+		return s.scan(ctx, kw, scanner.Stream(name, body), h)
+	})
+	sum.InputFile = strings.Join(names, ", ")
+	sum.Stats.Duration = time.Since(start).Seconds()
+
+	if enc != nil {
+		line := summaryLine{
+			InputFile:           sum.InputFile,
+			Stats:               sum.Stats,
+			Incomplete:          sum.Incomplete,
+			KeywordsFingerprint: sum.KeywordsFingerprint,
+		}
+		if err != nil {
+			line.Error = err.Error()
+		}
+		enc.Encode(line)
+		return
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	output.NewJSONSummaryWriter(w, "", "").WriteSummary(sum)
+}
+
+// summaryLine is the last line of an NDJSON response.
+type summaryLine struct {
+	InputFile  string           `json:"inputFile"`
+	Stats      output.ScanStats `json:"stats"`
+	Incomplete bool             `json:"incomplete,omitempty"`
+	Error      string           `json:"error,omitempty"`
+
+	KeywordsFingerprint string `json:"keywordsFingerprint,omitempty"`
+}
+
+// scan scans src for kw, counting it as an active scan.
+func (s *Server) scan(ctx context.Context, kw *keywords.Keywords, src scanner.Source, h scanner.Handler) error {
+	atomic.AddInt64(&s.active, 1)
+	defer atomic.AddInt64(&s.active, -1)
+	return s.scanner.ScanWith(ctx, kw, src, h)
+}
+
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status":   "ok",
+		"keywords": s.scanner.Keywords().Fingerprint(),
+		"scans":    atomic.LoadInt64(&s.active),
+		"jobs":     s.jobs.running(),
+	})
+}
+
+// eachFile calls f with the name and contents of each file in the body of
+// r, in order: each file part of a multipart form, or else the whole body.
+func eachFile(r *http.Request, f func(name string, body io.Reader) error) error {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		name := r.URL.Query().Get("name")
+		if name == "" {
+			name = "upload"
+		}
+		return f(name, r.Body)
+	}
+
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return badRequest{err}
+	}
+	n := 0
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return badRequest{err}
+		}
+		if part.FileName() == "" {
+			part.Close()
+			continue
+		}
+		n++
+		err = f(part.FileName(), part)
+		part.Close()
+		if err != nil {
+			return err
+		}
+	}
+	if n == 0 {
+		return badRequest{errors.New("no files in multipart form")}
+	}
+	return nil
+}
+
+// badRequest is an error caused by a malformed request.
+type badRequest struct {
+	error
+}
+
+// writeError writes err with the status code that best describes it.
+func writeError(w http.ResponseWriter, err error) {
+	code := http.StatusInternalServerError
+	cause := errors.Cause(err)
+	if br, ok := cause.(badRequest); ok {
+		code = http.StatusBadRequest
+		cause = br.error
+	}
+	if _, ok := cause.(*http.MaxBytesError); ok {
+		code = http.StatusRequestEntityTooLarge
+	} else if cause == context.DeadlineExceeded {
+		code = http.StatusGatewayTimeout
+	} else if cause == context.Canceled {
+		code = http.StatusServiceUnavailable
+	}
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,90 @@
+package server_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/joelanford/goscan/app/server"
+	"github.com/joelanford/goscan/utils/keywords"
+	"github.com/joelanford/goscan/utils/output"
+	"github.com/joelanford/goscan/utils/scanner"
+	"github.com/stretchr/testify/assert"
+)
+
+func newServer(t *testing.T, opts ...server.Option) *httptest.Server {
+	kw, err := keywords.LoadReader(strings.NewReader("- word: password\n"), nil)
+	assert.NoError(t, err)
+	base, err := ioutil.TempDir("", "goscan-server")
+	assert.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(base) })
+
+	s, err := scanner.NewScanner(kw, scanner.BaseDir(base), scanner.Parallelism(2))
+	assert.NoError(t, err)
+	srv, err := server.New(s, append([]server.Option{server.SpoolDir(base)}, opts...)...)
+	assert.NoError(t, err)
+	ts := httptest.NewServer(srv)
+	t.Cleanup(func() {
+		ts.Close()
+		srv.Close()
+	})
+	return ts
+}
+
+func TestScan(t *testing.T) {
+	ts := newServer(t, server.MaxBodySize(64))
+
+	resp, err := http.Post(ts.URL+"/scan?name=a.txt", "application/octet-stream", strings.NewReader("my password"))
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	var sum output.ScanSummary
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&sum))
+	assert.Equal(t, "a.txt", sum.InputFile)
+	assert.Equal(t, 1, sum.Stats.TotalHits)
+
+	resp, err = http.Post(ts.URL+"/scan", "application/octet-stream", strings.NewReader(strings.Repeat("x", 100)))
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+}
+
+func TestJobs(t *testing.T) {
+	ts := newServer(t)
+
+	resp, err := http.Post(ts.URL+"/jobs?name=a.txt", "application/octet-stream", strings.NewReader("my password"))
+	assert.NoError(t, err)
+	var j server.Job
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&j))
+	resp.Body.Close()
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+	assert.Equal(t, "/jobs/"+j.ID, resp.Header.Get("Location"))
+
+	for i := 0; i < 100 && j.Status == "running"; i++ {
+		time.Sleep(10 * time.Millisecond)
+		resp, err = http.Get(ts.URL + "/jobs/" + j.ID)
+		assert.NoError(t, err)
+		assert.NoError(t, json.NewDecoder(resp.Body).Decode(&j))
+		resp.Body.Close()
+	}
+	assert.Equal(t, "done", j.Status)
+	if assert.NotNil(t, j.Summary) {
+		assert.Equal(t, 1, j.Summary.Stats.TotalHits)
+	}
+
+	req, _ := http.NewRequest("DELETE", ts.URL+"/jobs/"+j.ID, nil)
+	resp, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	resp, err = http.Get(ts.URL + "/jobs/" + j.ID)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
@@ -21,9 +21,13 @@ type Machine struct {
 }
 
 type Term struct {
-	Pos     int
-	Word    []byte
-	Context []byte
+	Pos      int
+	End      int
+	Line     int
+	Column   int
+	Word     []byte
+	Context  []byte
+	LineText []byte
 }
 
 func (m *Machine) Build(keywords [][]byte) (err error) {
@@ -121,7 +125,12 @@ func (m *Machine) setF(inState, outState int) {
 }
 
 func (m *Machine) MultiPatternSearch(content []byte, context int, returnImmediately bool) [](*Term) {
+	return m.multiPatternSearch(content, context, returnImmediately, lineState{})
+}
+
+func (m *Machine) multiPatternSearch(content []byte, context int, returnImmediately bool, ls lineState) [](*Term) {
 	terms := make([](*Term), 0)
+	offset := ls.offset
 
 	state := ROOT_STATE
 	for pos, c := range content {
@@ -134,11 +143,14 @@ func (m *Machine) MultiPatternSearch(content []byte, context int, returnImmediat
 			if val, ok := m.output[state]; ok != false {
 				for _, word := range val {
 					term := new(Term)
-					term.Pos = pos - len(word) + 1
+					wordBegin := pos - len(word) + 1
+					term.Pos = offset + wordBegin
+					term.End = term.Pos + len(word)
 					term.Word = word
+					term.Line, term.Column, term.LineText = ls.locate(content, offset, term.Pos, term.End)
 
-					contextBegin := term.Pos - context
-					contextEnd := term.Pos + len(word) + context
+					contextBegin := wordBegin - context
+					contextEnd := wordBegin + len(word) + context
 					if contextBegin < 0 {
 						contextBegin = 0
 					}
@@ -154,6 +166,7 @@ func (m *Machine) MultiPatternSearch(content []byte, context int, returnImmediat
 				}
 			}
 		}
+		ls.advance(c, offset+pos)
 	}
 
 	return terms
@@ -166,12 +179,24 @@ func (m *Machine) MultiPatternSearchReadSeeker(f io.ReadSeeker, context int, ret
 		return nil, errors.New("context cannot exceed " + strconv.Itoa(maxContext) + " bytes")
 	}
 
+	type chunk struct {
+		buf []byte
+		ls  lineState
+	}
+
 	errChan := make(chan error)
-	bufChan := make(chan []byte)
+	bufChan := make(chan chunk)
 	termsChan := make(chan *Term)
 
 	go func() {
 		defer close(bufChan)
+
+		//
+		// Chunks overlap so that matches and context spanning a chunk
+		// boundary are found. The line state of each chunk is carried
+		// over from the non-overlapping part of the previous chunk.
+		//
+		var ls lineState
 		for i := int64(0); true; i += 1036288 {
 			_, err := f.Seek(i, 0)
 			if err != nil {
@@ -179,15 +204,23 @@ func (m *Machine) MultiPatternSearchReadSeeker(f io.ReadSeeker, context int, ret
 				return
 			}
 			buf := make([]byte, 1048576)
-			len, err := f.Read(buf)
-			if err != nil {
+			len, err := io.ReadFull(f, buf)
+			if err != nil && err != io.ErrUnexpectedEOF {
 				if err == io.EOF {
 					return
 				}
 				errChan <- err
 				return
 			}
-			bufChan <- buf[0:len]
+			ls.offset = int(i)
+			bufChan <- chunk{buf: buf[0:len], ls: ls}
+			stride := len
+			if stride > 1036288 {
+				stride = 1036288
+			}
+			for pos, c := range buf[0:stride] {
+				ls.advance(c, int(i)+pos)
+			}
 		}
 	}()
 
@@ -196,8 +229,8 @@ func (m *Machine) MultiPatternSearchReadSeeker(f io.ReadSeeker, context int, ret
 	for i := 0; i < 4; i++ {
 		go func() {
 			defer searchWg.Done()
-			for buf := range bufChan {
-				for _, term := range m.MultiPatternSearch(buf, context, returnImmediately) {
+			for c := range bufChan {
+				for _, term := range m.multiPatternSearch(c.buf, context, returnImmediately, c.ls) {
 					termsChan <- term
 				}
 			}
@@ -255,6 +288,7 @@ func (m *Machine) MultiPatternSearchReader(r io.Reader, context int, returnImmed
 	}
 	totalPos := 0
 
+	var ls lineState
 	state := ROOT_STATE
 	for currEnd != 0 {
 		nextEnd, err = r.Read(nextBuf)
@@ -266,6 +300,7 @@ func (m *Machine) MultiPatternSearchReader(r io.Reader, context int, returnImmed
 			}
 		}
 
+		currStart := totalPos
 		for pos, c := range currBuf[0:currEnd] {
 		start:
 			if m.g(state, c) == FAIL_STATE {
@@ -279,8 +314,15 @@ func (m *Machine) MultiPatternSearchReader(r io.Reader, context int, returnImmed
 						wordBegin := pos - len(word) + 1
 						wordEnd := wordBegin + len(word)
 						term.Pos = totalPos - len(word) + 1
+						term.End = totalPos + 1
 						term.Word = word
 
+						window := make([]byte, 0, prevEnd+currEnd+nextEnd)
+						window = append(window, prevBuf[0:prevEnd]...)
+						window = append(window, currBuf[0:currEnd]...)
+						window = append(window, nextBuf[0:nextEnd]...)
+						term.Line, term.Column, term.LineText = ls.locate(window, currStart-prevEnd, term.Pos, term.End)
+
 						var contextBefore []byte
 						contextBegin := wordBegin - context
 						if contextBegin < 0 {
@@ -319,6 +361,7 @@ func (m *Machine) MultiPatternSearchReader(r io.Reader, context int, returnImmed
 					}
 				}
 			}
+			ls.advance(c, totalPos)
 			totalPos++
 		}
 
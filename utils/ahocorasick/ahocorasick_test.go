@@ -1,6 +1,7 @@
 package ahocorasick_test
 
 import (
+	"sort"
 	"testing"
 
 	"bytes"
@@ -24,14 +25,22 @@ var (
 		[]byte("collecting terminated may son"),
 	}
 	expectedTerms = []*ahocorasick.Term{
-		&ahocorasick.Term{Pos: 0, Word: []byte("His followed carriage proposal"), Context: []byte("His followed carriage proposal entrance ")},
-		&ahocorasick.Term{Pos: 16348, Word: []byte("assistance not. Resolve pursuit regul"), Context: []byte("ffronting assistance not. Resolve pursuit regular so call")},
-		&ahocorasick.Term{Pos: 20151, Word: []byte("collecting terminated may son"), Context: []byte("specially collecting terminated may son expressio")},
-		&ahocorasick.Term{Pos: 20460, Word: []byte("love high yet. Snug"), Context: []byte("does none love high yet. Snug love will")},
-		&ahocorasick.Term{Pos: 20480, Word: []byte("love will up bore as"), Context: []byte("yet. Snug love will up bore as be. Toler")},
-		&ahocorasick.Term{Pos: 23948, Word: []byte("Offending she contained mrs led"), Context: []byte("ocked no. Offending she contained mrs led listening")},
-		&ahocorasick.Term{Pos: 24549, Word: []byte("one. As guest right of he"), Context: []byte("add shall one. As guest right of he was.")},
-		&ahocorasick.Term{Pos: 24567, Word: []byte("t of he wa"), Context: []byte("guest right of he was.")},
+		&ahocorasick.Term{Pos: 0, End: 30, Line: 1, Column: 1, Word: []byte("His followed carriage proposal"), Context: []byte("His followed carriage proposal entrance "),
+			LineText: []byte("His followed carriage proposal entrance directly had elegance. Greater for cottage gay parties natural. Remaining he furniture on he discourse suspected perpetual. Power dried her taken place day ought the. Four and our ham west miss. Education shameless w")},
+		&ahocorasick.Term{Pos: 16348, End: 16385, Line: 87, Column: 288, Word: []byte("assistance not. Resolve pursuit regul"), Context: []byte("ffronting assistance not. Resolve pursuit regular so call"),
+			LineText: []byte("n mind so upon they rent am walk. Shortly am waiting inhabit smiling he chiefly of in. Lain tore time gone him his dear sure. Fat decisively estimating affronting assistance not. Resolve pursuit regular so calling me. West he plan girl been my then up no. ")},
+		&ahocorasick.Term{Pos: 20151, End: 20180, Line: 107, Column: 16, Word: []byte("collecting terminated may son"), Context: []byte("specially collecting terminated may son expressio"),
+			LineText: []byte("Ask especially collecting terminated may son expression. Extremely eagerness principle estimable own was man. Men received far his dashwood subjects new. My sufficient surrounded an companions dispatched in on. Connection too unaffected expression led son ")},
+		&ahocorasick.Term{Pos: 20460, End: 20479, Line: 107, Column: 325, Word: []byte("love high yet. Snug"), Context: []byte("does none love high yet. Snug love will"),
+			LineText: []byte(" on. Connection too unaffected expression led son possession. New smiling friends and her another. Leaf she does none love high yet. Snug love will up bore as be. Tolerably earnestly middleton extremely distrusts she boy now not. Add and offered prepare ho")},
+		&ahocorasick.Term{Pos: 20480, End: 20500, Line: 107, Column: 345, Word: []byte("love will up bore as"), Context: []byte("yet. Snug love will up bore as be. Toler"),
+			LineText: []byte("unaffected expression led son possession. New smiling friends and her another. Leaf she does none love high yet. Snug love will up bore as be. Tolerably earnestly middleton extremely distrusts she boy now not. Add and offered prepare how cordial two promis")},
+		&ahocorasick.Term{Pos: 23948, End: 23979, Line: 123, Column: 173, Word: []byte("Offending she contained mrs led"), Context: []byte("ocked no. Offending she contained mrs led listening"),
+			LineText: []byte("nce in. The books arose but miles happy she. It building contempt or interest children mistress of unlocked no. Offending she contained mrs led listening resembled. Delicate marianne absolute men dashwood landlord and offended. Suppose cottage between and ")},
+		&ahocorasick.Term{Pos: 24549, End: 24574, Line: 125, Column: 347, Word: []byte("one. As guest right of he"), Context: []byte("add shall one. As guest right of he was."),
+			LineText: []byte(" how elinor warmly mrs basket marked. Led raising expense yet demesne weather musical. Me mr what park next busy ever. Elinor her his secure far twenty eat object. Late any far saw size want man. Which way you wrong add shall one. As guest right of he was.")},
+		&ahocorasick.Term{Pos: 24567, End: 24577, Line: 125, Column: 365, Word: []byte("t of he wa"), Context: []byte("guest right of he was."),
+			LineText: []byte(" how elinor warmly mrs basket marked. Led raising expense yet demesne weather musical. Me mr what park next busy ever. Elinor her his secure far twenty eat object. Late any far saw size want man. Which way you wrong add shall one. As guest right of he was.")},
 	}
 )
 
@@ -107,6 +116,46 @@ func TestMultiPatternSearchReadSeekerContext(t *testing.T) {
 	assert.Error(t, err)
 }
 
+func TestMultiPatternSearchReadSeekerChunks(t *testing.T) {
+	m := new(ahocorasick.Machine)
+	m.Build([][]byte{[]byte("needle")})
+
+	//
+	// Place needles in the first chunk, in the overlap between the first
+	// and second chunks, and in the second chunk only.
+	//
+	var buf bytes.Buffer
+	buf.WriteString("a needle\n")
+	for buf.Len() < 1040000 {
+		buf.WriteString("haystack\n")
+	}
+	buf.WriteString("needle\n")
+	for buf.Len() < 1100000 {
+		buf.WriteString("haystack\n")
+	}
+	buf.WriteString("  needle")
+
+	terms, err := m.MultiPatternSearchReadSeeker(bytes.NewReader(buf.Bytes()), 2, false)
+	assert.NoError(t, err)
+	assert.Len(t, terms, 3)
+
+	sort.Slice(terms, func(i, j int) bool { return terms[i].Pos < terms[j].Pos })
+	lines := bytes.Count(buf.Bytes(), []byte("\n"))
+	assert.Equal(t, 2, terms[0].Pos)
+	assert.Equal(t, 1, terms[0].Line)
+	assert.Equal(t, 3, terms[0].Column)
+	assert.Equal(t, []byte("a needle"), terms[0].LineText)
+	assert.Equal(t, 1040004, terms[1].Pos)
+	assert.Equal(t, 1040010, terms[1].End)
+	assert.Equal(t, 115557, terms[1].Line)
+	assert.Equal(t, 1, terms[1].Column)
+	assert.Equal(t, []byte("needle"), terms[1].LineText)
+	assert.Equal(t, buf.Len()-6, terms[2].Pos)
+	assert.Equal(t, lines+1, terms[2].Line)
+	assert.Equal(t, 3, terms[2].Column)
+	assert.Equal(t, []byte("  needle"), terms[2].LineText)
+}
+
 func TestMultiPatternSearchReader(t *testing.T) {
 	m := new(ahocorasick.Machine)
 	m.Build(keywords)
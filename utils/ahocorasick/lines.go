@@ -0,0 +1,73 @@
+package ahocorasick
+
+import "bytes"
+
+// MAX_LINE_TEXT is the maximum length of the line text captured for a term.
+// Longer lines are truncated to a window around the matched word.
+const MAX_LINE_TEXT = 256
+
+// lineState tracks the line number and line start offset while content is
+// scanned, so that terms can be located by line and column.
+type lineState struct {
+	offset    int // absolute offset of the content being searched
+	lines     int // number of newlines before the current position
+	lineStart int // absolute offset of the start of the current line
+}
+
+func (ls *lineState) advance(c byte, pos int) {
+	if c == '\n' {
+		ls.lines++
+		ls.lineStart = pos + 1
+	}
+}
+
+// locate returns the 1-based line and column of the word at [start, end),
+// along with the (possibly truncated) text of the line it starts on. The
+// state must not yet have advanced past the last byte of the word. window
+// holds the content around the word, beginning at absolute offset winStart.
+func (ls *lineState) locate(window []byte, winStart, start, end int) (int, int, []byte) {
+	line := ls.lines + 1
+	lineStart := ls.lineStart
+	if start < winStart {
+		return line, start - lineStart + 1, nil
+	}
+
+	//
+	// If the word itself spans lines, find the start of the line that the
+	// word begins on.
+	//
+	if lineStart > start {
+		line -= bytes.Count(window[start-winStart:lineStart-winStart], []byte("\n"))
+		lineStart = winStart
+		if i := bytes.LastIndexByte(window[:start-winStart], '\n'); i >= 0 {
+			lineStart = winStart + i + 1
+		}
+	}
+	column := start - lineStart + 1
+
+	lineEnd := winStart + len(window)
+	if i := bytes.IndexByte(window[start-winStart:], '\n'); i >= 0 {
+		lineEnd = start + i
+	}
+	if lineEnd-lineStart > MAX_LINE_TEXT {
+		textStart := start
+		if end-start < MAX_LINE_TEXT {
+			textStart -= (MAX_LINE_TEXT - (end - start)) / 2
+		}
+		if textStart < lineStart {
+			textStart = lineStart
+		}
+		textEnd := textStart + MAX_LINE_TEXT
+		if textEnd > lineEnd {
+			textEnd = lineEnd
+			textStart = textEnd - MAX_LINE_TEXT
+		}
+		lineStart, lineEnd = textStart, textEnd
+	}
+	if lineStart < winStart {
+		lineStart = winStart
+	}
+
+	text := bytes.TrimSuffix(window[lineStart-winStart:lineEnd-winStart], []byte("\r"))
+	return line, column, append([]byte(nil), text...)
+}
@@ -2,14 +2,19 @@ package archive
 
 import (
 	"context"
-	"errors"
-	"os"
+	"fmt"
+	"io"
+	"io/fs"
 	"os/exec"
-	"path/filepath"
+	"path"
 	"strings"
 	"sync"
+	"time"
 
+	"github.com/joelanford/goscan/utils/scratch"
+	"github.com/pkg/errors"
 	filetype "gopkg.in/h2non/filetype.v1"
+	"gopkg.in/h2non/filetype.v1/types"
 )
 
 func init() {
@@ -19,13 +24,121 @@ func init() {
 	})
 }
 
+// Options control how archives are unarchived.
+type Options struct {
+	// Extension is appended to the name of an archive to get the name of
+	// the directory it is unarchived into.
+	Extension string
+
+	// Timeout limits how long unarchiving a single archive may take. A
+	// timeout of zero means no limit.
+	Timeout time.Duration
+
+	// Native enables the built-in extractors for the formats they support,
+	// which work on the scratch filesystem directly rather than on disk.
+	// Other formats are still unarchived with unar.
+	Native bool
+
+	// Quota, if not nil, is checked before and during unarchiving. If it
+	// is exceeded, the partially unarchived output is removed and an
+	// UnarchiveError wrapping scratch.ErrLimit is returned.
+	Quota *scratch.Quota
+
+	// Dedup, if not nil, is used to skip unarchiving archives that are
+	// identical to ones that were already found.
+	Dedup *Dedup
+
+	// Cache, if not nil, is asked whether the results of an archive with
+	// the given SHA-256 hash are already known, in which case it is not
+	// unarchived.
+	Cache interface {
+		Contains(sum string) bool
+	}
+
+	// Progress, if not nil, is told about each file that is found and
+	// each archive that is unarchived. It is called from several
+	// goroutines at once.
+	Progress Progress
+
+	// Streamed holds the archives that were unarchived by CopyStream, by
+	// file. They are not unarchived again.
+	Streamed map[string]StreamResult
+}
+
+// StreamResult is what CopyStream returned for an archive.
+type StreamResult struct {
+	Extraction *Extraction
+	Err        error
+}
+
+// Progress receives the progress of a recursive unarchive.
+type Progress interface {
+	Found(file string, size int64)
+	Unarchiving(file string)
+	Unarchived(file string, x *Extraction)
+}
+
+// UnarchiveResult is a file found while recursively unarchiving. If Error
+// is an *UnarchiveError, File could not be (fully) unarchived but can still
+// be scanned. Any other error means File could not be read at all.
 type UnarchiveResult struct {
 	File  string
+	Size  int64
 	Error error
+
+	// Extraction describes how File was unarchived, if it was.
+	Extraction *Extraction
+
+	// SHA256 is the hash of File, if it was hashed for deduplication. If
+	// File is a duplicate, DuplicateOf is the first file with that hash,
+	// and File was not unarchived.
+	SHA256      string
+	DuplicateOf string
+
+	// Cached is true if File was not unarchived because the results of its
+	// contents are cached.
+	Cached bool
 }
 
-func CanUnarchive(file string) (bool, error) {
-	k, err := filetype.MatchFile(file)
+// Extraction describes an attempt to unarchive an archive.
+type Extraction struct {
+	OutputDir string
+
+	// Extractor is "unar", or "native/" followed by the archive type for
+	// the built-in extractors.
+	Extractor string
+
+	// ExitStatus is the exit status of unar, or -1 if it couldn't be run
+	// or was killed. The built-in extractors report 0 on success and 1 on
+	// failure.
+	ExitStatus int
+
+	BytesIn  int64
+	BytesOut int64
+	Duration time.Duration
+}
+
+// UnarchiveError is returned when unarchiving fails or times out. Output
+// holds the combined output of unar, if it was used.
+type UnarchiveError struct {
+	File     string
+	Output   string
+	TimedOut bool
+	Err      error
+}
+
+func (e *UnarchiveError) Error() string {
+	if e.TimedOut {
+		return fmt.Sprintf("unarchiving %s timed out", path.Base(e.File))
+	}
+	if e.Output == "" {
+		return fmt.Sprintf("unarchiving %s failed: %s", path.Base(e.File), e.Err)
+	}
+	return fmt.Sprintf("unarchiving %s failed: %s", path.Base(e.File), e.Output)
+}
+
+func CanUnarchive(fsys scratch.FS, file string) (bool, error) {
+	k, err := fileType(fsys, file)
 	if err != nil {
 		return false, err
 	}
@@ -54,67 +167,260 @@ func CanUnarchive(file string) (bool, error) {
 	return canUnarchive, nil
 }
 
-func Unarchive(file string, outputDir string) error {
-	output, err := exec.Command("unar", "-o", outputDir, file).CombinedOutput()
+func fileType(fsys scratch.FS, file string) (types.Type, error) {
+	f, err := fsys.Open(file)
+	if err != nil {
+		return types.Unknown, err
+	}
+	defer f.Close()
+
+	header := make([]byte, 512)
+	n, err := io.ReadFull(f, header)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return types.Unknown, err
+	}
+	return filetype.Match(header[:n])
+}
+
+// Unarchive extracts file into outputDir, using a native extractor if one
+// is enabled and supports the file, and unar otherwise.
+func Unarchive(ctx context.Context, fsys scratch.FS, file string, outputDir string, opts Options) (*Extraction, error) {
+	x := &Extraction{OutputDir: outputDir, ExitStatus: -1}
+	if info, err := fsys.Stat(file); err == nil {
+		x.BytesIn = info.Size()
+	}
+	start := time.Now()
+	err := unarchive(ctx, fsys, file, outputDir, opts, x)
+	x.Duration = time.Since(start)
+	x.BytesOut = dirSize(fsys, outputDir)
+	return x, err
+}
+
+func unarchive(ctx context.Context, fsys scratch.FS, file string, outputDir string, opts Options, x *Extraction) error {
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+
+	if opts.Native {
+		k, err := fileType(fsys, file)
+		if err != nil {
+			return &UnarchiveError{File: file, Err: err}
+		}
+		if extract, ok := nativeExtractors[k.Extension]; ok {
+			x.Extractor = "native/" + k.Extension
+			x.ExitStatus = 0
+			if err := extract(ctx, fsys, file, outputDir); err != nil {
+				x.ExitStatus = 1
+				return &UnarchiveError{
+					File:     file,
+					TimedOut: ctx.Err() == context.DeadlineExceeded,
+					Err:      err,
+				}
+			}
+			return nil
+		}
+	}
+
+	x.Extractor = "unar"
+	diskFile, err := fsys.DiskPath(file)
 	if err != nil {
-		return errors.New(strings.TrimSpace(string(output)))
+		return &UnarchiveError{File: file, Err: err}
+	}
+	diskOutputDir, err := fsys.DiskPath(outputDir)
+	if err != nil {
+		return &UnarchiveError{File: file, Err: err}
+	}
+
+	//
+	// unar writes to disk directly, so its output is checked against the
+	// quota while it runs, and accounted for once it's done. The watcher is
+	// stopped and joined before its error is read, so that it can't fire
+	// after unar has exited.
+	//
+	quotaErr := make(chan error, 1)
+	stopWatch := func() {}
+	if opts.Quota != nil {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithCancel(ctx)
+		done := make(chan struct{})
+		stopped := make(chan struct{})
+		defer func() {
+			cancel()
+			opts.Quota.Add(dirSize(fsys, outputDir))
+		}()
+		go func() {
+			defer close(stopped)
+			ticker := time.NewTicker(500 * time.Millisecond)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-done:
+					return
+				case <-ticker.C:
+					if err := opts.Quota.Check(dirSize(fsys, outputDir)); err != nil {
+						quotaErr <- err
+						cancel()
+						return
+					}
+				}
+			}
+		}()
+		stopWatch = func() {
+			close(done)
+			<-stopped
+		}
+	}
+
+	cmd := exec.CommandContext(ctx, "unar", "-o", diskOutputDir, diskFile)
+	output, err := cmd.CombinedOutput()
+	stopWatch()
+	if cmd.ProcessState != nil {
+		x.ExitStatus = cmd.ProcessState.ExitCode()
+	}
+	select {
+	case qerr := <-quotaErr:
+		return &UnarchiveError{File: file, Err: qerr}
+	default:
+	}
+	if err != nil {
+		return &UnarchiveError{
+			File:     file,
+			Output:   strings.TrimSpace(string(output)),
+			TimedOut: ctx.Err() == context.DeadlineExceeded,
+			Err:      err,
+		}
 	}
 	return nil
 }
 
-func UnarchiveRecursive(ctx context.Context, file, extension string, results chan<- UnarchiveResult) {
+// dirSize returns the total size of the regular files under dir.
+func dirSize(fsys scratch.FS, dir string) int64 {
+	var size int64
+	fs.WalkDir(fsys, dir, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && d.Type().IsRegular() {
+			if info, err := d.Info(); err == nil {
+				size += info.Size()
+			}
+		}
+		return nil
+	})
+	return size
+}
+
+func UnarchiveRecursive(ctx context.Context, fsys scratch.FS, file string, opts Options, results chan<- UnarchiveResult) {
 	var wg sync.WaitGroup
 	wg.Add(1)
 	go func() {
-		if err := filepath.Walk(file, unarchiveWalk(ctx, &wg, extension, results)); err != nil {
-			results <- UnarchiveResult{Error: err}
-		}
+		fs.WalkDir(fsys, file, unarchiveWalk(ctx, &wg, fsys, opts, results))
 		wg.Done()
 	}()
 	wg.Wait()
 }
 
-func unarchiveWalk(ctx context.Context, wg *sync.WaitGroup, extension string, results chan<- UnarchiveResult) filepath.WalkFunc {
-	return func(file string, info os.FileInfo, err error) error {
+func unarchiveWalk(ctx context.Context, wg *sync.WaitGroup, fsys scratch.FS, opts Options, results chan<- UnarchiveResult) fs.WalkDirFunc {
+	return func(file string, d fs.DirEntry, err error) error {
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
 		default:
 		}
 
+		//
+		// Report files and directories that can't be read, and keep walking
+		// the rest of the tree.
+		//
+		if err != nil {
+			results <- UnarchiveResult{File: file, Error: err}
+			if d != nil && d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
 		if err != nil {
-			return err
+			results <- UnarchiveResult{File: file, Error: err}
+			return nil
 		}
-		if !info.Mode().IsRegular() || info.Size() == 0 {
+		if info.Size() == 0 {
 			return nil
 		}
+		if opts.Progress != nil {
+			opts.Progress.Found(file, info.Size())
+		}
 
-		if ok, err := CanUnarchive(file); err != nil {
-			return err
+		if ok, err := CanUnarchive(fsys, file); err != nil {
+			results <- UnarchiveResult{File: file, Size: info.Size(), Error: err}
 		} else if ok {
-			unarchivePath := file + extension
+			var sum string
+			if opts.Dedup != nil || opts.Cache != nil {
+				if sum, err = HashFile(fsys, file); err != nil {
+					sum = ""
+				}
+			}
+			if opts.Dedup != nil && sum != "" {
+				if canonical := opts.Dedup.Claim(sum, file, true); canonical != file {
+					results <- UnarchiveResult{File: file, Size: info.Size(), SHA256: sum, DuplicateOf: canonical}
+					return nil
+				}
+			}
+			if opts.Cache != nil && sum != "" && opts.Cache.Contains(sum) {
+				results <- UnarchiveResult{File: file, Size: info.Size(), SHA256: sum, Cached: true}
+				return nil
+			}
+			unarchivePath := file + opts.Extension
 
 			//
-			// We can ignore most errors, because they're usually problems unarchiving.
-			// Since we scan the archive file itself, it isn't a huge deal if we fail
-			// to unarchive something.
+			// Unarchive failures don't stop the scan, since we still scan the
+			// archive file itself. They are reported along with the archive
+			// so that the results show the scan of its contents may be
+			// incomplete.
 			//
-			// TODO: WE should create errors for issues that jeopardize the legitimacy
-			//       of the scan. For example, a full disk where our scratch space is.
+			// If the scratch space is full, we don't scan whatever was
+			// partially unarchived, and free its space for the rest of the
+			// scan. The archive is reported with a limit error, so that the
+			// scan is explicitly incomplete rather than silently missing
+			// files.
 			//
-			_ = Unarchive(file, unarchivePath)
-			results <- UnarchiveResult{File: file}
-			if _, err := os.Stat(unarchivePath); !os.IsNotExist(err) {
+			var err error
+			var x *Extraction
+			sr, streamed := opts.Streamed[file]
+			if streamed {
+				x, err = sr.Extraction, sr.Err
+			} else if opts.Quota != nil {
+				if qerr := opts.Quota.Wait(ctx, 0); qerr != nil && ctx.Err() == nil {
+					err = &UnarchiveError{File: file, Err: qerr}
+				}
+			}
+			if err == nil && !streamed {
+				if opts.Progress != nil {
+					opts.Progress.Unarchiving(file)
+				}
+				x, err = Unarchive(ctx, fsys, file, unarchivePath, opts)
+				if opts.Progress != nil {
+					opts.Progress.Unarchived(file, x)
+				}
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if uerr, ok := err.(*UnarchiveError); ok && errors.Cause(uerr.Err) == scratch.ErrLimit {
+				fsys.RemoveAll(unarchivePath)
+			}
+			results <- UnarchiveResult{File: file, Size: info.Size(), Error: err, Extraction: x, SHA256: sum}
+			if _, err := fsys.Stat(unarchivePath); err == nil {
 				wg.Add(1)
 				go func() {
-					if err := filepath.Walk(unarchivePath, unarchiveWalk(ctx, wg, extension, results)); err != nil {
-						results <- UnarchiveResult{Error: err}
-					}
+					fs.WalkDir(fsys, unarchivePath, unarchiveWalk(ctx, wg, fsys, opts, results))
 					wg.Done()
 				}()
 			}
 		} else {
-			results <- UnarchiveResult{File: file}
+			results <- UnarchiveResult{File: file, Size: info.Size()}
 		}
 		return nil
 	}
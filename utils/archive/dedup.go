@@ -0,0 +1,60 @@
+package archive
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"sync"
+
+	"github.com/joelanford/goscan/utils/scratch"
+)
+
+// Dedup tracks the content hashes of the files found while unarchiving, so
+// that identical files are only unarchived and scanned once.
+type Dedup struct {
+	mu    sync.Mutex
+	files map[dedupKey]string
+}
+
+// dedupKey separates archives from other files, since whether a file is
+// unarchived also depends on its name. A file that wasn't unarchived can't
+// stand in for an archive that would have been.
+type dedupKey struct {
+	sum     string
+	archive bool
+}
+
+func NewDedup() *Dedup {
+	return &Dedup{
+		files: make(map[dedupKey]string),
+	}
+}
+
+// Claim records file as having the SHA-256 hash sum, and returns the first
+// file claimed with that hash. file is a duplicate if that isn't file.
+// Archives are only duplicates of other archives.
+func (d *Dedup) Claim(sum, file string, archive bool) string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	key := dedupKey{sum: sum, archive: archive}
+	if canonical, ok := d.files[key]; ok {
+		return canonical
+	}
+	d.files[key] = file
+	return file
+}
+
+// HashFile returns the hex-encoded SHA-256 hash of file.
+func HashFile(fsys scratch.FS, file string) (string, error) {
+	f, err := fsys.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,34 @@
+package archive_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	"github.com/joelanford/goscan/utils/archive"
+	"github.com/joelanford/goscan/utils/scratch"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDedup(t *testing.T) {
+	d := archive.NewDedup()
+	assert.Equal(t, "a.zip", d.Claim("1", "a.zip", true))
+	assert.Equal(t, "a.zip", d.Claim("1", "b.zip", true))
+	assert.Equal(t, "a.zip", d.Claim("1", "a.zip", true))
+
+	//
+	// Files that weren't unarchived are separate from archives with the
+	// same contents.
+	//
+	assert.Equal(t, "a.zip.txt", d.Claim("1", "a.zip.txt", false))
+	assert.Equal(t, "c.zip", d.Claim("2", "c.zip", true))
+
+	fsys := scratch.MemFS(1<<20, "")
+	writeFile(t, fsys, "x", []byte("a password"))
+	sum, err := archive.HashFile(fsys, "x")
+	assert.NoError(t, err)
+	expected := sha256.Sum256([]byte("a password"))
+	assert.Equal(t, hex.EncodeToString(expected[:]), sum)
+	_, err = archive.HashFile(fsys, "missing")
+	assert.Error(t, err)
+}
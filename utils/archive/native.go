@@ -0,0 +1,191 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/bzip2"
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/joelanford/goscan/utils/scratch"
+	"github.com/pkg/errors"
+)
+
+type extractor func(ctx context.Context, fsys scratch.FS, file, outputDir string) error
+
+// nativeExtractors are the built-in extractors, by file type extension.
+var nativeExtractors = map[string]extractor{
+	"gz":  extractGzip,
+	"bz2": extractBzip2,
+	"tar": extractTar,
+	"zip": extractZip,
+}
+
+func extractGzip(ctx context.Context, fsys scratch.FS, file, outputDir string) error {
+	f, err := fsys.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	return gunzip(ctx, fsys, f, file, outputDir, info.Mode(), info.ModTime())
+}
+
+// gunzip decompresses r, the contents of the gzip file file, into outputDir.
+func gunzip(ctx context.Context, fsys scratch.FS, r io.Reader, file, outputDir string, mode os.FileMode, modTime time.Time) error {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	name := zr.Name
+	if name == "" {
+		name = path.Base(file)
+		if strings.HasSuffix(name, ".tgz") {
+			name = strings.TrimSuffix(name, ".tgz") + ".tar"
+		} else {
+			name = strings.TrimSuffix(name, ".gz")
+		}
+	}
+	if !zr.ModTime.IsZero() {
+		modTime = zr.ModTime
+	}
+	return extractFile(ctx, fsys, path.Join(outputDir, safeName(path.Base(name))), mode, modTime, zr)
+}
+
+func extractBzip2(ctx context.Context, fsys scratch.FS, file, outputDir string) error {
+	f, err := fsys.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	return bunzip2(ctx, fsys, f, file, outputDir, info.Mode(), info.ModTime())
+}
+
+// bunzip2 decompresses r, the contents of the bzip2 file file, into
+// outputDir.
+func bunzip2(ctx context.Context, fsys scratch.FS, r io.Reader, file, outputDir string, mode os.FileMode, modTime time.Time) error {
+	name := strings.TrimSuffix(strings.TrimSuffix(path.Base(file), ".bz2"), ".bz")
+	return extractFile(ctx, fsys, path.Join(outputDir, name), mode, modTime, bzip2.NewReader(r))
+}
+
+func extractTar(ctx context.Context, fsys scratch.FS, file, outputDir string) error {
+	f, err := fsys.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := fsys.MkdirAll(outputDir); err != nil {
+		return err
+	}
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA {
+			continue
+		}
+		name := path.Join(outputDir, safeName(hdr.Name))
+		if err := extractFile(ctx, fsys, name, hdr.FileInfo().Mode(), hdr.ModTime, tr); err != nil {
+			return err
+		}
+	}
+}
+
+func extractZip(ctx context.Context, fsys scratch.FS, file, outputDir string) error {
+	f, err := fsys.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	ra, ok := f.(io.ReaderAt)
+	if !ok {
+		return errors.Errorf("%s does not support random access", file)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	zr, err := zip.NewReader(ra, info.Size())
+	if err != nil {
+		return err
+	}
+
+	if err := fsys.MkdirAll(outputDir); err != nil {
+		return err
+	}
+	for _, zf := range zr.File {
+		if !zf.Mode().IsRegular() {
+			continue
+		}
+		rc, err := zf.Open()
+		if err != nil {
+			return err
+		}
+		name := path.Join(outputDir, safeName(zf.Name))
+		err = extractFile(ctx, fsys, name, zf.Mode(), zf.Modified, rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// extractFile copies r into name, stopping early if ctx is done.
+func extractFile(ctx context.Context, fsys scratch.FS, name string, mode os.FileMode, modTime time.Time, r io.Reader) error {
+	w, err := fsys.Create(name, mode, modTime)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, &ctxReader{ctx: ctx, r: r}); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// safeName cleans an archive member name so that it can't escape the
+// directory it is extracted into.
+func safeName(name string) string {
+	name = path.Clean("/" + strings.Replace(name, "\\", "/", -1))
+	name = strings.TrimPrefix(name, "/")
+	if name == "" {
+		return "_"
+	}
+	return name
+}
+
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r *ctxReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
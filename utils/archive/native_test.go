@@ -0,0 +1,209 @@
+package archive_test
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"io/fs"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/joelanford/goscan/utils/archive"
+	"github.com/joelanford/goscan/utils/scratch"
+	"github.com/stretchr/testify/assert"
+)
+
+// evilNames are archive member names that try to escape the directory
+// they are extracted into, and the names they must be extracted as.
+var evilNames = map[string]string{
+	"a.txt":               "a.txt",
+	"../evil.txt":         "evil.txt",
+	"../../etc/passwd":    "etc/passwd",
+	"/abs/x.txt":          "abs/x.txt",
+	`..\..\win.txt`:       "win.txt",
+	`C:\windows\sys.txt`:  "C:/windows/sys.txt",
+	"dir/../../up.txt":    "up.txt",
+	"./dot/./inside.txt":  "dot/inside.txt",
+	"dir/sub/../deep.txt": "dir/deep.txt",
+}
+
+func tarFile(t *testing.T, names []string) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, name := range names {
+		assert.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(name)), Typeflag: tar.TypeReg}))
+		tw.Write([]byte(name))
+	}
+	assert.NoError(t, tw.Close())
+	return buf.Bytes()
+}
+
+func zipFile(t *testing.T, names []string) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, name := range names {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate})
+		assert.NoError(t, err)
+		w.Write([]byte(name))
+	}
+	assert.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+func gzipFile(t *testing.T, name, data string) []byte {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Name = name
+	zw.Write([]byte(data))
+	assert.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+// bzip2File is "a password\n" compressed with bzip2, which the standard
+// library can only decompress.
+var bzip2File = []byte{
+	0x42, 0x5a, 0x68, 0x39, 0x31, 0x41, 0x59, 0x26, 0x53, 0x59, 0x12, 0x06,
+	0x6c, 0x8f, 0x00, 0x00, 0x01, 0x51, 0x80, 0x00, 0x10, 0x40, 0x00, 0x24,
+	0x00, 0xd8, 0x80, 0x20, 0x00, 0x31, 0x06, 0x4c, 0x41, 0x03, 0x47, 0xa9,
+	0xa2, 0x57, 0xa3, 0x38, 0x71, 0xe2, 0xee, 0x48, 0xa7, 0x0a, 0x12, 0x02,
+	0x40, 0xcd, 0x91, 0xe0,
+}
+
+func writeFile(t *testing.T, fsys scratch.FS, name string, data []byte) {
+	w, err := fsys.Create(name, 0644, time.Now())
+	assert.NoError(t, err)
+	_, err = w.Write(data)
+	assert.NoError(t, err)
+	assert.NoError(t, w.Close())
+}
+
+// files returns the regular files in fsys, with their contents.
+func files(t *testing.T, fsys scratch.FS) map[string]string {
+	found := make(map[string]string)
+	err := fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		data, err := fs.ReadFile(fsys, p)
+		found[p] = string(data)
+		return err
+	})
+	assert.NoError(t, err)
+	return found
+}
+
+func TestUnarchiveNative(t *testing.T) {
+	var names []string
+	for name := range evilNames {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	members := make(map[string]string)
+	for name, clean := range evilNames {
+		members["out/"+clean] = name
+	}
+
+	tests := []struct {
+		name     string
+		file     string
+		data     []byte
+		expected map[string]string
+		err      bool
+	}{
+		{name: "tar", file: "in.tar", data: tarFile(t, names), expected: members},
+		{name: "zip", file: "in.zip", data: zipFile(t, names), expected: members},
+		{name: "gzip", file: "in.txt.gz", data: gzipFile(t, "", "a password"), expected: map[string]string{"out/in.txt": "a password"}},
+		{name: "gzip relative name", file: "in.gz", data: gzipFile(t, "../../etc/passwd", "root"), expected: map[string]string{"out/passwd": "root"}},
+		{name: "gzip backslash name", file: "in.gz", data: gzipFile(t, `..\..\evil`, "x"), expected: map[string]string{"out/evil": "x"}},
+		{name: "gzip absolute name", file: "in.gz", data: gzipFile(t, "/", "x"), expected: map[string]string{"out/_": "x"}},
+		{name: "bzip2", file: "in.txt.bz2", data: bzip2File, expected: map[string]string{"out/in.txt": "a password\n"}},
+		{name: "truncated tar", file: "in.tar", data: tarFile(t, names)[:512+5], err: true},
+		{name: "truncated zip", file: "in.zip", data: zipFile(t, names)[:100], err: true},
+		{name: "truncated gzip", file: "in.gz", data: gzipFile(t, "x", strings.Repeat("password ", 100))[:30], err: true},
+		{name: "truncated bzip2", file: "in.bz2", data: bzip2File[:30], err: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fsys := scratch.MemFS(16<<20, "")
+			writeFile(t, fsys, test.file, test.data)
+
+			x, err := archive.Unarchive(context.Background(), fsys, test.file, "out", archive.Options{Native: true})
+			assert.True(t, strings.HasPrefix(x.Extractor, "native/"), x.Extractor)
+			found := files(t, fsys)
+			delete(found, test.file)
+			if test.err {
+				assert.Error(t, err)
+				assert.Equal(t, 1, x.ExitStatus)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, test.expected, found)
+			}
+
+			//
+			// Whatever happened, nothing was written outside of the
+			// output directory.
+			//
+			for p := range found {
+				assert.True(t, strings.HasPrefix(p, "out/"), p)
+			}
+		})
+	}
+}
+
+func TestCopyStream(t *testing.T) {
+	data := gzipFile(t, "../../evil.txt", "a password")
+	tests := []struct {
+		name     string
+		data     []byte
+		expected map[string]string
+		extract  bool
+		err      bool
+	}{
+		{name: "plain", data: []byte("a password"), expected: map[string]string{"in": "a password"}},
+		{name: "gzip", data: data, expected: map[string]string{"in": string(data), "in.x/evil.txt": "a password"}, extract: true},
+		{name: "bzip2", data: bzip2File, expected: map[string]string{"in": string(bzip2File), "in.x/in": "a password\n"}, extract: true},
+		{name: "truncated gzip", data: data[:len(data)-10], expected: map[string]string{"in": string(data[:len(data)-10])}, extract: true, err: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fsys := scratch.MemFS(16<<20, "")
+			x, err := archive.CopyStream(context.Background(), fsys, bytes.NewReader(test.data), "in", archive.Options{Extension: ".x"})
+			if test.err {
+				_, ok := err.(*archive.UnarchiveError)
+				assert.True(t, ok, "%v", err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, test.extract, x != nil)
+
+			//
+			// The stream is always copied whole, even when it can't be
+			// decompressed. A truncated stream may leave a partial file.
+			//
+			found := files(t, fsys)
+			if test.err {
+				assert.Equal(t, test.expected["in"], found["in"])
+				return
+			}
+			assert.Equal(t, test.expected, found)
+		})
+	}
+}
+
+func TestCopyStreamReadError(t *testing.T) {
+	fsys := scratch.MemFS(16<<20, "")
+	r := io.MultiReader(bytes.NewReader(gzipFile(t, "x", "a password")[:20]), errReader{})
+	_, err := archive.CopyStream(context.Background(), fsys, r, "in", archive.Options{Extension: ".x"})
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+}
+
+type errReader struct{}
+
+func (errReader) Read(p []byte) (int, error) {
+	return 0, io.ErrUnexpectedEOF
+}
@@ -0,0 +1,128 @@
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"time"
+
+	"github.com/joelanford/goscan/utils/scratch"
+	filetype "gopkg.in/h2non/filetype.v1"
+)
+
+type decompressor func(ctx context.Context, fsys scratch.FS, r io.Reader, file, outputDir string, mode os.FileMode, modTime time.Time) error
+
+// streamDecompressors decompress streams as they are copied, by file type
+// extension.
+var streamDecompressors = map[string]decompressor{
+	"gz":  gunzip,
+	"bz2": bunzip2,
+}
+
+// StreamType returns the file type extension of the stream read by r, such
+// as "gz", or an empty string if it is unknown. The returned reader must
+// be used in place of r.
+func StreamType(r io.Reader) (string, io.Reader) {
+	br := bufio.NewReaderSize(r, 64<<10)
+	header, err := br.Peek(512)
+	if err != nil && err != io.EOF {
+		//
+		// Peek only returns a read error once, so it is kept for the
+		// reader to return after the header.
+		//
+		return "", io.MultiReader(bytes.NewReader(header), errReader{err})
+	}
+	k, err := filetype.Match(header)
+	if err != nil || k == filetype.Unknown {
+		return "", br
+	}
+	return k.Extension, br
+}
+
+type errReader struct {
+	err error
+}
+
+func (r errReader) Read(p []byte) (int, error) {
+	return 0, r.err
+}
+
+// CopyStream copies r, which can only be read once, into file in fsys. If
+// it is gzip or bzip2 compressed, it is also decompressed into the
+// directory file would be unarchived into while it is copied, and the
+// extraction is returned so that it is not unarchived again (see
+// Options.Streamed). Otherwise the extraction is nil.
+//
+// Decompression errors are returned as an *UnarchiveError along with the
+// extraction, once all of r has been copied. Other errors mean file could
+// not be copied.
+func CopyStream(ctx context.Context, fsys scratch.FS, r io.Reader, file string, opts Options) (*Extraction, error) {
+	ext, r := StreamType(r)
+	w, err := fsys.Create(file, 0644, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	cw := &countWriter{w: w}
+	r = &ctxReader{ctx: ctx, r: r}
+
+	decompress, ok := streamDecompressors[ext]
+	if !ok {
+		if _, err := io.Copy(cw, r); err != nil {
+			w.Close()
+			return nil, err
+		}
+		return nil, w.Close()
+	}
+
+	//
+	// The stream is written to file as it is decompressed. Whatever the
+	// decompressor didn't read, such as trailing data or the rest of a
+	// corrupt stream, is copied after it.
+	//
+	x := &Extraction{
+		OutputDir:  file + opts.Extension,
+		Extractor:  "native/" + ext,
+		ExitStatus: 0,
+	}
+	start := time.Now()
+	xerr := decompress(ctx, fsys, io.TeeReader(r, cw), file, x.OutputDir, 0644, time.Now())
+	if cw.err == nil {
+		_, err = io.Copy(cw, r)
+	}
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	if cw.err != nil {
+		err = cw.err
+	}
+	if err != nil {
+		return nil, err
+	}
+	x.Duration = time.Since(start)
+	x.BytesIn = cw.n
+	x.BytesOut = dirSize(fsys, x.OutputDir)
+	if xerr != nil {
+		x.ExitStatus = 1
+		return x, &UnarchiveError{File: file, Err: xerr}
+	}
+	return x, nil
+}
+
+// countWriter counts the bytes written to w, and remembers the first error.
+type countWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	c.err = err
+	return n, err
+}
@@ -0,0 +1,197 @@
+// Package baseline records the hits of a scan as fingerprints, so that
+// later scans can tell the hits that are new from those that were already
+// accepted, and those that were fixed.
+package baseline
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/joelanford/goscan/utils/output"
+	"github.com/pkg/errors"
+)
+
+// Version is the version of the baseline file format.
+const Version = 1
+
+// Baseline is the hits of a scan, by fingerprint.
+type Baseline struct {
+	Version             int       `json:"version"`
+	Created             time.Time `json:"created"`
+	InputFile           string    `json:"inputFile"`
+	KeywordsFingerprint string    `json:"keywordsFingerprint,omitempty"`
+	Entries             []Entry   `json:"entries"`
+}
+
+// Entry is a fingerprint, and the number of hits that have it. The word,
+// path and context it was made from are kept for people reading the file.
+type Entry struct {
+	Fingerprint string `json:"fingerprint"`
+	Word        string `json:"word"`
+	Path        string `json:"path"`
+	Context     string `json:"context"`
+	Count       int    `json:"count"`
+}
+
+// Fingerprint returns the fingerprint of hit in the file with the result
+// path file. It is made from the word, the normalized line of the hit, and
+// the path of the file without its first element, which is the name of the
+// scanned file or directory. That keeps it the same when the hit moves
+// within its file, and when the next build of an artifact has a different
+// name.
+func Fingerprint(hit output.Hit, file string) string {
+	sum := sha256.Sum256([]byte(hit.Word + "\x00" + hit.NormalizedLine() + "\x00" + relPath(file)))
+	return hex.EncodeToString(sum[:])
+}
+
+func relPath(file string) string {
+	file = strings.TrimPrefix(file, "/")
+	if i := strings.IndexByte(file, '/'); i >= 0 {
+		return file[i+1:]
+	}
+	return ""
+}
+
+// New returns the baseline of the hits in sum.
+func New(sum output.ScanSummary) *Baseline {
+	b := &Baseline{
+		Version:             Version,
+		Created:             time.Now().UTC(),
+		InputFile:           sum.InputFile,
+		KeywordsFingerprint: sum.KeywordsFingerprint,
+		Entries:             make([]Entry, 0),
+	}
+	index := make(map[string]int)
+	for _, sr := range sum.Results {
+		for _, hit := range sr.Hits {
+			fp := Fingerprint(hit, sr.File)
+			if i, ok := index[fp]; ok {
+				b.Entries[i].Count++
+				continue
+			}
+			index[fp] = len(b.Entries)
+			b.Entries = append(b.Entries, Entry{
+				Fingerprint: fp,
+				Word:        hit.Word,
+				Path:        relPath(sr.File),
+				Context:     hit.NormalizedLine(),
+				Count:       1,
+			})
+		}
+	}
+	sort.Slice(b.Entries, func(i, j int) bool {
+		ei, ej := b.Entries[i], b.Entries[j]
+		if ei.Path != ej.Path {
+			return ei.Path < ej.Path
+		}
+		return ei.Fingerprint < ej.Fingerprint
+	})
+	return b
+}
+
+// Load reads the baseline in file.
+func Load(file string) (*Baseline, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, errors.Wrap(err, "error opening baseline")
+	}
+	defer f.Close()
+	var b Baseline
+	if err := json.NewDecoder(f).Decode(&b); err != nil {
+		return nil, errors.Wrapf(err, "error parsing baseline %s", file)
+	}
+	if b.Version != Version {
+		return nil, errors.Errorf("baseline %s has unsupported version %d", file, b.Version)
+	}
+	return &b, nil
+}
+
+// Write writes the baseline to w as indented JSON.
+func (b *Baseline) Write(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(b)
+}
+
+// Matcher marks the hits of a scan against a baseline. Each entry of the
+// baseline accounts for as many hits as its count, and any more hits with
+// its fingerprint are new. It is not safe for concurrent use.
+type Matcher struct {
+	entries   map[string]*Entry
+	remaining map[string]int
+	stats     output.BaselineStats
+}
+
+// Matcher returns a Matcher for b.
+func (b *Baseline) Matcher() *Matcher {
+	m := &Matcher{
+		entries:   make(map[string]*Entry, len(b.Entries)),
+		remaining: make(map[string]int, len(b.Entries)),
+	}
+	for i := range b.Entries {
+		e := &b.Entries[i]
+		m.entries[e.Fingerprint] = e
+		m.remaining[e.Fingerprint] += e.Count
+	}
+	return m
+}
+
+// Mark sets the baseline status of each hit of sr. The hits are copied,
+// since they may be shared with the scan cache.
+func (m *Matcher) Mark(sr *output.ScanResult) {
+	if len(sr.Hits) == 0 {
+		return
+	}
+	hits := make([]output.Hit, len(sr.Hits))
+	copy(hits, sr.Hits)
+	for i := range hits {
+		fp := Fingerprint(hits[i], sr.File)
+		if m.remaining[fp] > 0 {
+			m.remaining[fp]--
+			hits[i].Baseline = output.BaselineExisting
+			m.stats.Existing++
+		} else {
+			hits[i].Baseline = output.BaselineNew
+			m.stats.New++
+		}
+	}
+	sr.Hits = hits
+}
+
+// Fixed returns the hits of the baseline that weren't found, by entry,
+// with the number of hits that are gone as their count.
+func (m *Matcher) Fixed() []output.FixedHit {
+	var fixed []output.FixedHit
+	for fp, n := range m.remaining {
+		if n > 0 {
+			e := m.entries[fp]
+			fixed = append(fixed, output.FixedHit{Word: e.Word, Path: e.Path, Context: e.Context, Count: n})
+		}
+	}
+	sort.Slice(fixed, func(i, j int) bool {
+		fi, fj := fixed[i], fixed[j]
+		if fi.Path != fj.Path {
+			return fi.Path < fj.Path
+		}
+		if fi.Word != fj.Word {
+			return fi.Word < fj.Word
+		}
+		return fi.Context < fj.Context
+	})
+	return fixed
+}
+
+// Stats returns the numbers of new, existing and fixed hits.
+func (m *Matcher) Stats() output.BaselineStats {
+	stats := m.stats
+	for _, n := range m.remaining {
+		stats.Fixed += n
+	}
+	return stats
+}
@@ -0,0 +1,76 @@
+package baseline_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/joelanford/goscan/utils/baseline"
+	"github.com/joelanford/goscan/utils/keywords"
+	"github.com/joelanford/goscan/utils/output"
+	"github.com/stretchr/testify/assert"
+)
+
+func hit(word, line string, index int) output.Hit {
+	return output.Hit{Hit: keywords.Hit{Word: word, Index: index, Line: index / 10, LineText: line}}
+}
+
+func TestMatcher(t *testing.T) {
+	old := output.ScanSummary{
+		InputFile: "build-1.tar",
+		Results: []output.ScanResult{
+			{File: "/build-1.tar/a.txt", Hits: []output.Hit{
+				hit("password", "the password is", 10),
+				hit("password", "the password is", 50),
+				hit("secret", "top secret", 90),
+			}},
+			{File: "/build-1.tar/b.txt", Hits: []output.Hit{
+				hit("secret", "a secret", 0),
+			}},
+		},
+	}
+	b := baseline.New(old)
+	assert.Len(t, b.Entries, 3)
+
+	//
+	// The baseline survives being written and read back.
+	//
+	dir, err := ioutil.TempDir("", "goscan-baseline")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	var buf bytes.Buffer
+	assert.NoError(t, b.Write(&buf))
+	file := filepath.Join(dir, "baseline.json")
+	assert.NoError(t, ioutil.WriteFile(file, buf.Bytes(), 0644))
+	b, err = baseline.Load(file)
+	assert.NoError(t, err)
+
+	//
+	// In the next build, hits that moved, or whose lines were reindented,
+	// are existing. One of the two identical hits and the hit in b.txt are
+	// fixed, and a hit in a new file is new.
+	//
+	m := b.Matcher()
+	a := output.ScanResult{File: "/build-2.tar/a.txt", Hits: []output.Hit{
+		hit("password", "  the   password is", 300),
+		hit("secret", "top secret", 400),
+	}}
+	c := output.ScanResult{File: "/build-2.tar/c.txt", Hits: []output.Hit{
+		hit("secret", "top secret", 0),
+	}}
+	shared := a.Hits
+	m.Mark(&a)
+	m.Mark(&c)
+	assert.Equal(t, output.BaselineExisting, a.Hits[0].Baseline)
+	assert.Equal(t, output.BaselineExisting, a.Hits[1].Baseline)
+	assert.Equal(t, output.BaselineNew, c.Hits[0].Baseline)
+	assert.Empty(t, shared[0].Baseline)
+
+	assert.Equal(t, output.BaselineStats{New: 1, Existing: 2, Fixed: 2}, m.Stats())
+	assert.Equal(t, []output.FixedHit{
+		{Word: "password", Path: "a.txt", Context: "the password is", Count: 1},
+		{Word: "secret", Path: "b.txt", Context: "a secret", Count: 1},
+	}, m.Fixed())
+}
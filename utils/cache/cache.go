@@ -0,0 +1,293 @@
+package cache
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/joelanford/goscan/utils/keywords"
+	"github.com/joelanford/goscan/utils/metadata"
+	"github.com/pkg/errors"
+)
+
+// Key identifies the results of scanning a file: the SHA-256 hash of its
+// contents, the fingerprint of the keyword set it was scanned with, a hash
+// of the scan options, and whether it was unarchived.
+type Key struct {
+	SHA256   string `json:"sha256"`
+	Keywords string `json:"keywords"`
+	Options  string `json:"options"`
+	Archive  bool   `json:"archive,omitempty"`
+}
+
+// Entry holds the results of scanning a file. For an archive, it also holds
+// the results of everything unarchived from it, with paths relative to it.
+// The file's own result has an empty path and comes first.
+type Entry struct {
+	Key
+	Results []Result  `json:"results"`
+	Created time.Time `json:"created"`
+}
+
+type Result struct {
+	Path     string             `json:"path,omitempty"`
+	Hits     []keywords.Hit     `json:"hits"`
+	Metadata *metadata.Metadata `json:"metadata,omitempty"`
+	Archive  bool               `json:"archive,omitempty"`
+}
+
+// Cache is a persistent store of scan results. It is a file of JSON
+// entries, one per line, that new entries are appended to. When the same
+// key appears more than once, the last entry wins. The file is only
+// rewritten when it is pruned.
+//
+// Entries for other keyword sets are kept, since the same cache file may be
+// used with several of them, and Open loads every entry into memory. They
+// are only removed, and the file only stops growing, when it is pruned.
+type Cache struct {
+	file string
+
+	mu      sync.Mutex
+	entries map[Key]*Entry
+	pending []*Entry
+}
+
+// Open loads the cache in file, creating it if it doesn't exist.
+func Open(file string) (*Cache, error) {
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return nil, errors.Wrap(err, "error creating cache directory")
+	}
+	c := &Cache{
+		file:    file,
+		entries: make(map[Key]*Entry),
+	}
+	err := c.withLock(func() error {
+		entries, err := readEntries(file)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			c.entries[e.Key] = e
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// File returns the path of the cache file.
+func (c *Cache) File() string {
+	return c.file
+}
+
+func (c *Cache) Get(key Key) (*Entry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	return e, ok
+}
+
+// Put adds an entry to the cache. It is written to the cache file by the
+// next Flush.
+func (c *Cache) Put(e *Entry) {
+	if e.Created.IsZero() {
+		e.Created = time.Now()
+	}
+	c.mu.Lock()
+	c.entries[e.Key] = e
+	c.pending = append(c.pending, e)
+	c.mu.Unlock()
+}
+
+// Flush appends the entries added since the last Flush to the cache file.
+func (c *Cache) Flush() error {
+	c.mu.Lock()
+	pending := c.pending
+	c.pending = nil
+	c.mu.Unlock()
+	if len(pending) == 0 {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, e := range pending {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+	return c.withLock(func() error {
+		f, err := os.OpenFile(c.file, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			return err
+		}
+
+		//
+		// A partial last line, left by a process that was killed while
+		// writing it, is ended first, so that it doesn't swallow the first
+		// entry written after it.
+		//
+		data := buf.Bytes()
+		if info, err := f.Stat(); err == nil && info.Size() > 0 {
+			last := make([]byte, 1)
+			if _, err := f.ReadAt(last, info.Size()-1); err == nil && last[0] != '\n' {
+				data = append([]byte{'\n'}, data...)
+			}
+		}
+		if _, err := f.Write(data); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	})
+}
+
+// Prune removes the entries for which keep returns false, and compacts the
+// cache file. It returns the number of entries removed.
+func (c *Cache) Prune(keep func(*Entry) bool) (int, error) {
+	removed := 0
+	err := c.withLock(func() error {
+		//
+		// Other processes may have added entries since we loaded the file.
+		//
+		entries, err := readEntries(c.file)
+		if err != nil {
+			return err
+		}
+		latest := make(map[Key]*Entry, len(entries))
+		for _, e := range entries {
+			latest[e.Key] = e
+		}
+
+		tmp, err := ioutil.TempFile(filepath.Dir(c.file), filepath.Base(c.file)+".tmp")
+		if err != nil {
+			return err
+		}
+		w := bufio.NewWriter(tmp)
+		enc := json.NewEncoder(w)
+		kept := make(map[Key]*Entry)
+		for _, e := range entries {
+			if latest[e.Key] != e {
+				continue
+			}
+			if !keep(e) {
+				removed++
+				continue
+			}
+			kept[e.Key] = e
+			if err = enc.Encode(e); err != nil {
+				break
+			}
+		}
+		if err == nil {
+			err = w.Flush()
+		}
+		if cerr := tmp.Close(); err == nil {
+			err = cerr
+		}
+		if err == nil {
+			err = os.Rename(tmp.Name(), c.file)
+		}
+		if err != nil {
+			os.Remove(tmp.Name())
+			return err
+		}
+
+		c.mu.Lock()
+		c.entries = kept
+		c.mu.Unlock()
+		return nil
+	})
+	return removed, err
+}
+
+// Stats describes the contents of a cache.
+type Stats struct {
+	File     string         `json:"file"`
+	Size     int64          `json:"size"`
+	Entries  int            `json:"entries"`
+	Results  int            `json:"results"`
+	Keywords map[string]int `json:"keywords"`
+	Oldest   time.Time      `json:"oldest"`
+	Newest   time.Time      `json:"newest"`
+}
+
+// Stats returns the number of entries and results in the cache, and the
+// number of entries for each keyword set fingerprint.
+func (c *Cache) Stats() (Stats, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s := Stats{
+		File:     c.file,
+		Keywords: make(map[string]int),
+	}
+	if info, err := os.Stat(c.file); err == nil {
+		s.Size = info.Size()
+	} else if !os.IsNotExist(err) {
+		return s, err
+	}
+	for _, e := range c.entries {
+		s.Entries++
+		s.Results += len(e.Results)
+		s.Keywords[e.Keywords]++
+		if s.Oldest.IsZero() || e.Created.Before(s.Oldest) {
+			s.Oldest = e.Created
+		}
+		if e.Created.After(s.Newest) {
+			s.Newest = e.Created
+		}
+	}
+	return s, nil
+}
+
+// withLock runs f while holding the lock on the cache file, which is a
+// separate file so that it survives the cache file being replaced.
+func (c *Cache) withLock(f func() error) error {
+	lock, err := os.OpenFile(c.file+".lock", os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+	if err := lockFile(lock); err != nil {
+		return errors.Wrap(err, "error locking cache")
+	}
+	return f()
+}
+
+// readEntries reads the entries in file. A partial last line, left by a
+// process that was killed while writing it, is ignored.
+func readEntries(file string) ([]*Entry, error) {
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []*Entry
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		var e Entry
+		if json.Unmarshal(line, &e) == nil {
+			entries = append(entries, &e)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package cache_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/joelanford/goscan/utils/cache"
+	"github.com/joelanford/goscan/utils/keywords"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goscan-cache")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "cache.jsonl")
+
+	c, err := cache.Open(file)
+	assert.NoError(t, err)
+	old := cache.Key{SHA256: "a", Keywords: "k1"}
+	cur := cache.Key{SHA256: "b", Keywords: "k2", Archive: true}
+	c.Put(&cache.Entry{Key: old, Created: time.Now().Add(-48 * time.Hour)})
+	c.Put(&cache.Entry{Key: cur, Results: []cache.Result{
+		{Hits: []keywords.Hit{{Word: "password"}}},
+		{Path: "/inner.txt"},
+	}})
+	assert.NoError(t, c.Flush())
+
+	//
+	// Entries are read back by other processes, and a partial line left
+	// by a killed one is ignored.
+	//
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_APPEND, 0)
+	assert.NoError(t, err)
+	f.WriteString(`{"sha256":"c"`)
+	f.Close()
+
+	c, err = cache.Open(file)
+	assert.NoError(t, err)
+	e, ok := c.Get(cur)
+	assert.True(t, ok)
+	assert.Len(t, e.Results, 2)
+	assert.Equal(t, "/inner.txt", e.Results[1].Path)
+	_, ok = c.Get(cache.Key{SHA256: "b", Keywords: "k1", Archive: true})
+	assert.False(t, ok)
+
+	//
+	// Entries appended after the partial line aren't lost to it.
+	//
+	next := cache.Key{SHA256: "d", Keywords: "k2"}
+	c.Put(&cache.Entry{Key: next})
+	assert.NoError(t, c.Flush())
+	c, err = cache.Open(file)
+	assert.NoError(t, err)
+	_, ok = c.Get(next)
+	assert.True(t, ok)
+
+	n, err := c.Prune(func(e *cache.Entry) bool {
+		return e.Created.After(time.Now().Add(-24 * time.Hour))
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	_, ok = c.Get(old)
+	assert.False(t, ok)
+
+	s, err := c.Stats()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, s.Entries)
+	assert.Equal(t, 2, s.Results)
+	assert.Equal(t, map[string]int{"k2": 2}, s.Keywords)
+}
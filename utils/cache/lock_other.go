@@ -0,0 +1,10 @@
+//go:build !linux && !darwin && !freebsd
+// +build !linux,!darwin,!freebsd
+
+package cache
+
+import "os"
+
+func lockFile(f *os.File) error {
+	return nil
+}
@@ -0,0 +1,15 @@
+//go:build linux || darwin || freebsd
+// +build linux darwin freebsd
+
+package cache
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile locks f until it is closed, waiting for other processes to
+// release it.
+func lockFile(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_EX)
+}
@@ -0,0 +1,271 @@
+// Package compare compares the summaries of two scans, such as those of
+// two releases of the same software, and reports the hits that appeared,
+// disappeared or changed between them.
+package compare
+
+import (
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/joelanford/goscan/utils/output"
+)
+
+// Compare returns how the hits of new differ from those of old, as a
+// summary of the files whose hits differ. Each hit has a diff status,
+// changed hits have the hit they were before, and each file says whether
+// it was added, removed, renamed or modified. Its stats count the results
+// and hits it holds, and all the changes it found.
+//
+// Files are matched by their lineage: their path through the archives
+// they were extracted from, without the name of the scanned file when the
+// summary is of a single one, so that releases with different names still
+// match. Files without a match are then matched by their SHA-256, if the
+// summaries have metadata, and files extracted from renamed archives
+// follow them.
+func Compare(old, new output.ScanSummary) output.ScanSummary {
+	d := output.ScanSummary{
+		InputFile:           new.InputFile,
+		PreviousInputFile:   old.InputFile,
+		Results:             make([]output.ScanResult, 0),
+		KeywordsFingerprint: new.KeywordsFingerprint,
+		Incomplete:          old.Incomplete || new.Incomplete,
+	}
+	stats := &output.DiffStats{}
+
+	oldFiles := lineages(old.Results)
+	newFiles := lineages(new.Results)
+	pairs := match(oldFiles, newFiles)
+
+	for _, f := range newFiles {
+		o, ok := pairs[f.lineage]
+		var sr output.ScanResult
+		switch {
+		case !ok:
+			sr = fileResult(f.result, output.FileAdded, "", output.HitAdded)
+			stats.FilesAdded++
+			stats.HitsAdded += len(f.result.Hits)
+		default:
+			hits, unchanged := compareHits(o.result.Hits, f.result.Hits, stats)
+			stats.HitsUnchanged += unchanged
+			sr = f.result
+			sr.Hits = hits
+			if o.lineage != f.lineage {
+				sr.Diff = &output.FileDiff{Status: output.FileRenamed, Previous: o.result.File}
+				stats.FilesRenamed++
+			} else if len(hits) > 0 {
+				sr.Diff = &output.FileDiff{Status: output.FileModified}
+			}
+		}
+		if len(sr.Hits) > 0 {
+			d.Add(sr, false)
+		}
+	}
+
+	matched := make(map[string]bool, len(pairs))
+	for _, o := range pairs {
+		matched[o.lineage] = true
+	}
+	for _, f := range oldFiles {
+		if matched[f.lineage] {
+			continue
+		}
+		stats.FilesRemoved++
+		if len(f.result.Hits) > 0 {
+			stats.HitsRemoved += len(f.result.Hits)
+			d.Add(fileResult(f.result, output.FileRemoved, f.result.File, output.HitRemoved), false)
+		}
+	}
+	d.Stats.Diff = stats
+	return d
+}
+
+type file struct {
+	lineage string
+	result  output.ScanResult
+}
+
+// lineages returns the results with their lineages. The first element of
+// each path is the name of the scanned file, and it is left out if all the
+// results have the same one.
+func lineages(results []output.ScanResult) []file {
+	single := true
+	for _, sr := range results {
+		if topLevel(sr.File) != topLevel(results[0].File) {
+			single = false
+			break
+		}
+	}
+	files := make([]file, 0, len(results))
+	for _, sr := range results {
+		lineage := strings.TrimPrefix(sr.File, "/")
+		if single {
+			lineage = strings.TrimPrefix(lineage, topLevel(sr.File))
+			lineage = strings.TrimPrefix(lineage, "/")
+		}
+		files = append(files, file{lineage: lineage, result: sr})
+	}
+	return files
+}
+
+func topLevel(p string) string {
+	p = strings.TrimPrefix(p, "/")
+	if i := strings.IndexByte(p, '/'); i >= 0 {
+		return p[:i]
+	}
+	return p
+}
+
+// match returns the old file that each new file matches, by the lineage
+// of the new file.
+func match(oldFiles, newFiles []file) map[string]file {
+	byLineage := make(map[string]file, len(oldFiles))
+	for _, f := range oldFiles {
+		byLineage[f.lineage] = f
+	}
+	pairs := make(map[string]file)
+	taken := make(map[string]bool)
+	var unmatched []file
+	for _, f := range newFiles {
+		if o, ok := byLineage[f.lineage]; ok {
+			pairs[f.lineage] = o
+			taken[o.lineage] = true
+			continue
+		}
+		unmatched = append(unmatched, f)
+	}
+
+	//
+	// Renamed files are matched by their contents. Of several old files
+	// with the same contents, each is only matched once.
+	//
+	byHash := make(map[string][]file)
+	for _, f := range oldFiles {
+		if !taken[f.lineage] && f.result.Metadata != nil && f.result.Metadata.SHA256 != "" {
+			byHash[f.result.Metadata.SHA256] = append(byHash[f.result.Metadata.SHA256], f)
+		}
+	}
+	var rest []file
+	for _, f := range unmatched {
+		if f.result.Metadata != nil {
+			if candidates := byHash[f.result.Metadata.SHA256]; len(candidates) > 0 {
+				pairs[f.lineage] = candidates[0]
+				taken[candidates[0].lineage] = true
+				byHash[f.result.Metadata.SHA256] = candidates[1:]
+				continue
+			}
+		}
+		rest = append(rest, f)
+	}
+
+	//
+	// Files extracted from a renamed archive are matched to the same
+	// path in the archive it was renamed from.
+	//
+	for _, f := range rest {
+		for dir := path.Dir(f.lineage); dir != "." && dir != "/"; dir = path.Dir(dir) {
+			o, ok := pairs[dir]
+			if !ok || o.lineage == dir {
+				continue
+			}
+			prev := o.lineage + strings.TrimPrefix(f.lineage, dir)
+			if c, ok := byLineage[prev]; ok && !taken[prev] {
+				pairs[f.lineage] = c
+				taken[prev] = true
+			}
+			break
+		}
+	}
+	return pairs
+}
+
+// fileResult returns sr with all its hits given the status hitStatus, as a
+// file with the status fileStatus.
+func fileResult(sr output.ScanResult, fileStatus, previous, hitStatus string) output.ScanResult {
+	hits := make([]output.Hit, len(sr.Hits))
+	for i, h := range sr.Hits {
+		h.Diff = hitStatus
+		hits[i] = h
+	}
+	sr.Hits = hits
+	sr.Diff = &output.FileDiff{Status: fileStatus, Previous: previous}
+	return sr
+}
+
+// compareHits returns the hits of a file that were added, removed or
+// changed, and the number that didn't change. Hits with the same word and
+// normalized line are the same hit, wherever they are in the file, and
+// changed if their policies did. Of the rest, hits with the same word are
+// paired up in the order they are in the file, as hits whose lines
+// changed.
+func compareHits(oldHits, newHits []output.Hit, stats *output.DiffStats) ([]output.Hit, int) {
+	key := func(h output.Hit) string { return h.Word + "\x00" + h.NormalizedLine() }
+	byKey := make(map[string][]int)
+	for i, h := range oldHits {
+		byKey[key(h)] = append(byKey[key(h)], i)
+	}
+
+	var hits, added []output.Hit
+	used := make([]bool, len(oldHits))
+	unchanged := 0
+	for _, h := range newHits {
+		k := key(h)
+		if len(byKey[k]) == 0 {
+			added = append(added, h)
+			continue
+		}
+		prev := oldHits[byKey[k][0]]
+		used[byKey[k][0]] = true
+		byKey[k] = byKey[k][1:]
+		if samePolicies(prev.Policies, h.Policies) {
+			unchanged++
+			continue
+		}
+		h.Diff = output.HitChanged
+		h.Previous = &prev
+		hits = append(hits, h)
+		stats.HitsChanged++
+	}
+
+	byWord := make(map[string][]int)
+	for i, h := range oldHits {
+		if !used[i] {
+			byWord[h.Word] = append(byWord[h.Word], i)
+		}
+	}
+	for _, h := range added {
+		if prevs := byWord[h.Word]; len(prevs) > 0 {
+			prev := oldHits[prevs[0]]
+			used[prevs[0]] = true
+			byWord[h.Word] = prevs[1:]
+			h.Diff = output.HitChanged
+			h.Previous = &prev
+			stats.HitsChanged++
+		} else {
+			h.Diff = output.HitAdded
+			stats.HitsAdded++
+		}
+		hits = append(hits, h)
+	}
+	for i, h := range oldHits {
+		if !used[i] {
+			h.Diff = output.HitRemoved
+			hits = append(hits, h)
+			stats.HitsRemoved++
+		}
+	}
+	sort.SliceStable(hits, func(i, j int) bool { return hits[i].Index < hits[j].Index })
+	return hits, unchanged
+}
+
+func samePolicies(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if b[k] != v {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,108 @@
+package compare_test
+
+import (
+	"testing"
+
+	"github.com/joelanford/goscan/utils/compare"
+	"github.com/joelanford/goscan/utils/keywords"
+	"github.com/joelanford/goscan/utils/metadata"
+	"github.com/joelanford/goscan/utils/output"
+	"github.com/stretchr/testify/assert"
+)
+
+func hit(word, line string, index int, policy string) output.Hit {
+	return output.Hit{Hit: keywords.Hit{Word: word, Index: index, LineText: line, Policies: map[string]string{policy: "x"}}}
+}
+
+func TestCompare(t *testing.T) {
+	old := output.ScanSummary{
+		InputFile: "release-1.tar",
+		Results: []output.ScanResult{
+			{File: "/release-1.tar/a.txt", Hits: []output.Hit{
+				hit("password", "the password is", 10, "p1"),
+				hit("secret", "top secret", 50, "p1"),
+				hit("token", "token = abc", 90, "p1"),
+			}},
+			{File: "/release-1.tar/b.txt", Hits: []output.Hit{
+				hit("secret", "a secret", 0, "p1"),
+			}},
+			{File: "/release-1.tar/app-1.tar", Metadata: &metadata.Metadata{SHA256: "aaaa"}},
+			{File: "/release-1.tar/app-1.tar/conf", Hits: []output.Hit{
+				hit("password", "password: x", 0, "p1"),
+			}},
+			{File: "/release-1.tar/gone.txt", Hits: []output.Hit{
+				hit("password", "password", 0, "p1"),
+			}},
+		},
+	}
+	new := output.ScanSummary{
+		InputFile: "release-2.tar",
+		Results: []output.ScanResult{
+			{File: "/release-2.tar/a.txt", Hits: []output.Hit{
+				hit("password", "  the   password is", 300, "p1"),
+				hit("secret", "top secret", 400, "p2"),
+				hit("token", "token = def", 500, "p1"),
+			}},
+			{File: "/release-2.tar/b.txt", Hits: []output.Hit{
+				hit("secret", "a secret", 0, "p1"),
+			}},
+			{File: "/release-2.tar/app-2.tar", Metadata: &metadata.Metadata{SHA256: "aaaa"}},
+			{File: "/release-2.tar/app-2.tar/conf", Hits: []output.Hit{
+				hit("password", "password: x", 0, "p1"),
+				hit("secret", "secret: y", 20, "p1"),
+			}},
+		},
+	}
+	d := compare.Compare(old, new)
+	assert.Equal(t, "release-2.tar", d.InputFile)
+	assert.Equal(t, "release-1.tar", d.PreviousInputFile)
+	assert.Equal(t, &output.DiffStats{
+		HitsAdded: 1, HitsRemoved: 1, HitsChanged: 2, HitsUnchanged: 3,
+		FilesRemoved: 1, FilesRenamed: 2,
+	}, d.Stats.Diff)
+	assert.Equal(t, 3, d.Stats.FilesHit)
+	assert.Equal(t, 4, d.Stats.TotalHits)
+	if !assert.Len(t, d.Results, 3) {
+		return
+	}
+
+	//
+	// The hit whose policies changed and the hit whose line changed are
+	// changed, and the hit that only moved is not reported.
+	//
+	a := d.Results[0]
+	assert.Equal(t, "/release-2.tar/a.txt", a.File)
+	assert.Equal(t, output.FileModified, a.Diff.Status)
+	if assert.Len(t, a.Hits, 2) {
+		assert.Equal(t, output.HitChanged, a.Hits[0].Diff)
+		assert.Equal(t, "p1", firstPolicy(a.Hits[0].Previous))
+		assert.Equal(t, output.HitChanged, a.Hits[1].Diff)
+		assert.Equal(t, "token = abc", a.Hits[1].Previous.LineText)
+	}
+
+	//
+	// The files of the renamed archive are matched to those of the
+	// archive it was renamed from.
+	//
+	conf := d.Results[1]
+	assert.Equal(t, "/release-2.tar/app-2.tar/conf", conf.File)
+	assert.Equal(t, &output.FileDiff{Status: output.FileRenamed, Previous: "/release-1.tar/app-1.tar/conf"}, conf.Diff)
+	if assert.Len(t, conf.Hits, 1) {
+		assert.Equal(t, output.HitAdded, conf.Hits[0].Diff)
+		assert.Equal(t, "secret", conf.Hits[0].Word)
+	}
+
+	gone := d.Results[2]
+	assert.Equal(t, "/release-1.tar/gone.txt", gone.File)
+	assert.Equal(t, output.FileRemoved, gone.Diff.Status)
+	if assert.Len(t, gone.Hits, 1) {
+		assert.Equal(t, output.HitRemoved, gone.Hits[0].Diff)
+	}
+}
+
+func firstPolicy(h *output.Hit) string {
+	for p := range h.Policies {
+		return p
+	}
+	return ""
+}
@@ -0,0 +1,211 @@
+package git
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Signature is the author or committer of a commit.
+type Signature struct {
+	Name  string
+	Email string
+	When  time.Time
+}
+
+func (s Signature) String() string {
+	return s.Name + " <" + s.Email + ">"
+}
+
+// Commit is a parsed commit object.
+type Commit struct {
+	ID        ID
+	Tree      ID
+	Parents   []ID
+	Author    Signature
+	Committer Signature
+	Message   string
+}
+
+// TreeEntry is an entry of a tree object.
+type TreeEntry struct {
+	Mode uint32
+	Name string
+	ID   ID
+}
+
+// Modes of tree entries.
+const (
+	ModeTree       = 0040000
+	ModeFile       = 0100644
+	ModeExecutable = 0100755
+	ModeSymlink    = 0120000
+	ModeSubmodule  = 0160000
+)
+
+// ReadObject returns the type and contents of the object id. The contents
+// may be shared, and must not be modified.
+func (r *Repository) ReadObject(id ID) (ObjectType, []byte, error) {
+	return r.objects.read(id)
+}
+
+// ReadBlob returns the contents of the blob id. They may be shared, and
+// must not be modified.
+func (r *Repository) ReadBlob(id ID) ([]byte, error) {
+	t, data, err := r.objects.read(id)
+	if err != nil {
+		return nil, err
+	}
+	if t != TypeBlob {
+		return nil, errors.Errorf("object %s is a %s, not a blob", id, t)
+	}
+	return data, nil
+}
+
+// peel follows tags from id, and returns the object they point to.
+func (r *Repository) peel(id ID) (ObjectType, []byte, ID, error) {
+	for depth := 0; ; depth++ {
+		t, data, err := r.objects.read(id)
+		if err != nil {
+			return 0, nil, id, err
+		}
+		if t != TypeTag {
+			return t, data, id, nil
+		}
+		if depth > 10 {
+			return 0, nil, id, errors.Errorf("too many levels of tags at %s", id)
+		}
+		target, _ := headerValue(data, "object")
+		if id, err = ParseID(target); err != nil {
+			return 0, nil, id, errors.Wrapf(err, "malformed tag %s", id)
+		}
+	}
+}
+
+// Commit returns the commit id, or the commit that the tag id points to.
+func (r *Repository) Commit(id ID) (*Commit, error) {
+	t, data, id, err := r.peel(id)
+	if err != nil {
+		return nil, err
+	}
+	if t != TypeCommit {
+		return nil, errors.Errorf("object %s is a %s, not a commit", id, t)
+	}
+	return parseCommit(id, data)
+}
+
+func parseCommit(id ID, data []byte) (*Commit, error) {
+	c := &Commit{ID: id}
+	header := data
+	if i := bytes.Index(data, []byte("\n\n")); i >= 0 {
+		header = data[:i]
+		c.Message = string(data[i+2:])
+	}
+	for _, line := range strings.Split(string(header), "\n") {
+		kv := strings.SplitN(line, " ", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		var err error
+		switch kv[0] {
+		case "tree":
+			c.Tree, err = ParseID(kv[1])
+		case "parent":
+			var p ID
+			p, err = ParseID(kv[1])
+			c.Parents = append(c.Parents, p)
+		case "author":
+			c.Author = parseSignature(kv[1])
+		case "committer":
+			c.Committer = parseSignature(kv[1])
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "malformed commit %s", id)
+		}
+	}
+	return c, nil
+}
+
+// parseSignature parses "Name <email> 1234567890 +0100".
+func parseSignature(s string) Signature {
+	var sig Signature
+	lt := strings.IndexByte(s, '<')
+	gt := strings.LastIndexByte(s, '>')
+	if lt < 0 || gt < lt {
+		sig.Name = s
+		return sig
+	}
+	sig.Name = strings.TrimSpace(s[:lt])
+	sig.Email = s[lt+1 : gt]
+	fields := strings.Fields(s[gt+1:])
+	if len(fields) == 0 {
+		return sig
+	}
+	sec, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return sig
+	}
+	loc := time.UTC
+	if len(fields) > 1 && len(fields[1]) == 5 {
+		if tz, err := strconv.Atoi(fields[1][1:]); err == nil {
+			offset := (tz/100)*3600 + (tz%100)*60
+			if fields[1][0] == '-' {
+				offset = -offset
+			}
+			loc = time.FixedZone(fields[1], offset)
+		}
+	}
+	sig.When = time.Unix(sec, 0).In(loc)
+	return sig
+}
+
+// headerValue returns the value of the first header line of an object
+// with key.
+func headerValue(data []byte, key string) (string, bool) {
+	for _, line := range strings.Split(string(data), "\n") {
+		if line == "" {
+			break
+		}
+		if strings.HasPrefix(line, key+" ") {
+			return line[len(key)+1:], true
+		}
+	}
+	return "", false
+}
+
+// Tree returns the entries of the tree id.
+func (r *Repository) Tree(id ID) ([]TreeEntry, error) {
+	t, data, err := r.objects.read(id)
+	if err != nil {
+		return nil, err
+	}
+	if t != TypeTree {
+		return nil, errors.Errorf("object %s is a %s, not a tree", id, t)
+	}
+	return parseTree(id, data)
+}
+
+// parseTree parses tree entries, each of which is "mode name\0" followed
+// by the 20 byte ID.
+func parseTree(id ID, data []byte) ([]TreeEntry, error) {
+	var entries []TreeEntry
+	for len(data) > 0 {
+		sp := bytes.IndexByte(data, ' ')
+		nul := bytes.IndexByte(data, 0)
+		if sp < 0 || nul < sp || len(data) < nul+21 {
+			return nil, errors.Errorf("malformed tree %s", id)
+		}
+		mode, err := strconv.ParseUint(string(data[:sp]), 8, 32)
+		if err != nil {
+			return nil, errors.Errorf("malformed tree %s", id)
+		}
+		e := TreeEntry{Mode: uint32(mode), Name: string(data[sp+1 : nul])}
+		copy(e.ID[:], data[nul+1:nul+21])
+		entries = append(entries, e)
+		data = data[nul+21:]
+	}
+	return entries, nil
+}
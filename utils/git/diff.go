@@ -0,0 +1,368 @@
+package git
+
+import (
+	"bytes"
+	"path"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// Change is a regular file that was added or modified between two trees,
+// or between a tree and the index. Old is the zero TreeEntry for files
+// that were added. A file that was moved without changing its contents
+// has the entry it was moved from as Old. Deleted files are left out,
+// since nothing of them is left to scan.
+type Change struct {
+	Path string
+	Old  TreeEntry
+	New  TreeEntry
+}
+
+// Added is true if the file didn't exist before.
+func (c Change) Added() bool {
+	return c.Old.ID == ID{}
+}
+
+func isRegular(mode uint32) bool {
+	return mode&^0777 == ModeFile&^0777
+}
+
+// DiffTrees returns the files that were added or modified between the
+// trees old and new, sorted by path. old may be the zero ID, for an empty
+// tree.
+func (r *Repository) DiffTrees(old, new ID) ([]Change, error) {
+	var changes []Change
+	deleted := make(map[ID]TreeEntry)
+	if err := r.diffTrees(old, new, "", &changes, deleted); err != nil {
+		return nil, err
+	}
+	return pairMoves(changes, deleted), nil
+}
+
+func (r *Repository) diffTrees(old, new ID, dir string, changes *[]Change, deleted map[ID]TreeEntry) error {
+	if old == new {
+		return nil
+	}
+	oldEntries, err := r.treeEntries(old)
+	if err != nil {
+		return err
+	}
+	newEntries, err := r.treeEntries(new)
+	if err != nil {
+		return err
+	}
+
+	//
+	// Entries are compared by name, and a name that changed from a file
+	// to a directory, or back, is a deletion and an addition.
+	//
+	byName := make(map[string]TreeEntry, len(oldEntries))
+	for _, e := range oldEntries {
+		byName[e.Name] = e
+	}
+	for _, e := range newEntries {
+		name := path.Join(dir, e.Name)
+		o, ok := byName[e.Name]
+		delete(byName, e.Name)
+		if ok && (o.Mode == ModeTree) != (e.Mode == ModeTree) {
+			if err := r.addDeleted(o, name, deleted); err != nil {
+				return err
+			}
+			ok = false
+		}
+		switch {
+		case e.Mode == ModeTree:
+			var oldTree ID
+			if ok {
+				oldTree = o.ID
+			}
+			if err := r.diffTrees(oldTree, e.ID, name, changes, deleted); err != nil {
+				return err
+			}
+		case !isRegular(e.Mode):
+		case !ok || !isRegular(o.Mode):
+			*changes = append(*changes, Change{Path: name, New: e})
+		case o.ID != e.ID:
+			*changes = append(*changes, Change{Path: name, Old: o, New: e})
+		}
+	}
+	for name, o := range byName {
+		if err := r.addDeleted(o, path.Join(dir, name), deleted); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addDeleted records the regular files under the deleted entry e.
+func (r *Repository) addDeleted(e TreeEntry, name string, deleted map[ID]TreeEntry) error {
+	if isRegular(e.Mode) {
+		deleted[e.ID] = TreeEntry{Mode: e.Mode, Name: name, ID: e.ID}
+		return nil
+	}
+	if e.Mode != ModeTree {
+		return nil
+	}
+	entries, err := r.Tree(e.ID)
+	if err != nil {
+		return err
+	}
+	for _, c := range entries {
+		if err := r.addDeleted(c, path.Join(name, c.Name), deleted); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// treeEntries returns the entries of the tree id, or none for the zero ID.
+func (r *Repository) treeEntries(id ID) ([]TreeEntry, error) {
+	if id == (ID{}) {
+		return nil, nil
+	}
+	return r.Tree(id)
+}
+
+// pairMoves gives the files that were added with the contents of a file
+// that was deleted the deleted file as their old version, and sorts
+// changes by path.
+func pairMoves(changes []Change, deleted map[ID]TreeEntry) []Change {
+	for i, c := range changes {
+		if d, ok := deleted[c.New.ID]; ok && c.Added() {
+			changes[i].Old = d
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes
+}
+
+// DiffIndex returns the files that were added or modified in the index,
+// compared to the tree id, sorted by path. These are the changes that are
+// staged to be committed. id may be the zero ID, for an empty tree.
+func (r *Repository) DiffIndex(id ID) ([]Change, error) {
+	files := make(map[string]TreeEntry)
+	if err := r.treeFiles(id, "", files); err != nil {
+		return nil, err
+	}
+	index, err := r.Index()
+	if err != nil {
+		return nil, err
+	}
+	var changes []Change
+	for _, e := range index {
+		o, ok := files[e.Name]
+		delete(files, e.Name)
+		switch {
+		case !isRegular(e.Mode):
+		case !ok || !isRegular(o.Mode):
+			changes = append(changes, Change{Path: e.Name, New: e})
+		case o.ID != e.ID:
+			changes = append(changes, Change{Path: e.Name, Old: o, New: e})
+		}
+	}
+	deleted := make(map[ID]TreeEntry)
+	for _, o := range files {
+		if isRegular(o.Mode) {
+			deleted[o.ID] = o
+		}
+	}
+	return pairMoves(changes, deleted), nil
+}
+
+// treeFiles adds the entries of the tree id, and of its subtrees, to files
+// by their path.
+func (r *Repository) treeFiles(id ID, dir string, files map[string]TreeEntry) error {
+	entries, err := r.treeEntries(id)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		e.Name = path.Join(dir, e.Name)
+		if e.Mode == ModeTree {
+			if err := r.treeFiles(e.ID, e.Name, files); err != nil {
+				return err
+			}
+			continue
+		}
+		files[e.Name] = e
+	}
+	return nil
+}
+
+// MergeBase returns a best common ancestor of the commits a and b, as
+// used by "a...b" ranges.
+func (r *Repository) MergeBase(a, b ID) (ID, error) {
+	ancestors := make(map[ID]bool)
+	if err := r.walkCommits([]ID{a}, ancestors, func(*Commit) error { return nil }); err != nil {
+		return ID{}, err
+	}
+
+	//
+	// The common ancestors that are closest to b are found breadth
+	// first, and any of them that is an ancestor of another isn't best.
+	//
+	var candidates []ID
+	seen := map[ID]bool{b: true}
+	queue := []ID{b}
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		if ancestors[id] {
+			candidates = append(candidates, id)
+			continue
+		}
+		c, err := r.Commit(id)
+		if err != nil {
+			return ID{}, err
+		}
+		if r.shallow[c.ID] {
+			continue
+		}
+		for _, p := range c.Parents {
+			if !seen[p] {
+				seen[p] = true
+				queue = append(queue, p)
+			}
+		}
+	}
+	for _, c := range candidates {
+		best := true
+		for _, other := range candidates {
+			if other == c {
+				continue
+			}
+			below := make(map[ID]bool)
+			if err := r.walkCommits([]ID{other}, below, func(*Commit) error { return nil }); err != nil {
+				return ID{}, err
+			}
+			if below[c] {
+				best = false
+				break
+			}
+		}
+		if best {
+			return c, nil
+		}
+	}
+	return ID{}, errors.Wrapf(ErrNotFound, "merge base of %s and %s", a, b)
+}
+
+// maxDiffEdits is the most edits AddedLines looks for before it gives up,
+// and reports all the lines that differ between the first and last
+// changes as added.
+const maxDiffEdits = 1000
+
+// AddedLines returns the 1-based numbers of the lines of new that aren't
+// in old, by a longest common subsequence of their lines.
+func AddedLines(old, new []byte) []int {
+	a, b := splitLines(old), splitLines(new)
+
+	//
+	// Lines that are the same at the start and end are trimmed first,
+	// which leaves little to compare for most changes.
+	//
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && bytes.Equal(a[prefix], b[prefix]) {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && bytes.Equal(a[len(a)-1-suffix], b[len(b)-1-suffix]) {
+		suffix++
+	}
+	a, b = a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+
+	var added []int
+	kept := myers(a, b)
+	for i := range b {
+		if kept == nil || !kept[i] {
+			added = append(added, prefix+i+1)
+		}
+	}
+	return added
+}
+
+// splitLines splits data into lines, without their line endings, so that
+// a last line that gains one isn't changed.
+func splitLines(data []byte) [][]byte {
+	if len(data) == 0 {
+		return nil
+	}
+	lines := bytes.Split(data, []byte("\n"))
+	for i, line := range lines {
+		lines[i] = bytes.TrimSuffix(line, []byte("\r"))
+	}
+	if len(lines[len(lines)-1]) == 0 {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// myers returns which lines of b are in the shortest edit script from a to
+// b, by Myers' O(ND) algorithm, or nil if it needs more than maxDiffEdits
+// edits.
+func myers(a, b [][]byte) []bool {
+	n, m := len(a), len(b)
+	max := n + m
+	if max > maxDiffEdits {
+		max = maxDiffEdits
+	}
+	offset := max + 1
+	v := make([]int, 2*max+3)
+	//
+	// Only the diagonals that can have been reached, -d to d, are kept
+	// for each d.
+	//
+	var trace [][]int
+	for d := 0; d <= max; d++ {
+		trace = append(trace, append([]int(nil), v[offset-d:offset+d+1]...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && bytes.Equal(a[x], b[y]) {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				return backtrack(trace, n, m, d)
+			}
+		}
+	}
+	return nil
+}
+
+// backtrack follows the furthest reaching paths of trace back from the end
+// of an edit script of d edits, and marks the lines of b that it keeps.
+func backtrack(trace [][]int, x, y, d int) []bool {
+	kept := make([]bool, y)
+	for ; d > 0; d-- {
+		v := trace[d]
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && v[d+k-1] < v[d+k+1]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := v[d+prevK]
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			x--
+			y--
+			kept[y] = true
+		}
+		x, y = prevX, prevY
+	}
+	for x > 0 && y > 0 {
+		x--
+		y--
+		kept[y] = true
+	}
+	return kept
+}
@@ -0,0 +1,408 @@
+// Package git reads the objects and refs of a local git repository
+// directly from its loose objects and packfiles, without the git command.
+// Only repositories with SHA-1 object IDs are supported.
+package git
+
+import (
+	"bufio"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ID is an object ID.
+type ID [20]byte
+
+func (id ID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// ParseID parses a full hex object ID.
+func ParseID(s string) (ID, error) {
+	var id ID
+	if len(s) != 40 {
+		return id, errors.Errorf("invalid object ID %q", s)
+	}
+	if _, err := hex.Decode(id[:], []byte(s)); err != nil {
+		return id, errors.Errorf("invalid object ID %q", s)
+	}
+	return id, nil
+}
+
+// ErrNotFound is returned for objects and refs that don't exist.
+var ErrNotFound = errors.New("not found")
+
+// Repository is a local git repository.
+type Repository struct {
+	// gitDir holds the HEAD of the worktree, and commonDir everything
+	// else. They are the same except in linked worktrees.
+	gitDir    string
+	commonDir string
+
+	objects *objectStore
+	shallow map[ID]bool
+}
+
+// Open opens the repository at path: a working tree, its .git directory,
+// or a bare repository.
+func Open(path string) (*Repository, error) {
+	gitDir, err := findGitDir(path)
+	if err != nil {
+		return nil, err
+	}
+	commonDir := gitDir
+	if b, err := ioutil.ReadFile(filepath.Join(gitDir, "commondir")); err == nil {
+		commonDir = strings.TrimSpace(string(b))
+		if !filepath.IsAbs(commonDir) {
+			commonDir = filepath.Join(gitDir, commonDir)
+		}
+	}
+	if err := checkObjectFormat(commonDir); err != nil {
+		return nil, err
+	}
+
+	objects, err := openObjectStore(filepath.Join(commonDir, "objects"))
+	if err != nil {
+		return nil, err
+	}
+	r := &Repository{
+		gitDir:    gitDir,
+		commonDir: commonDir,
+		objects:   objects,
+		shallow:   make(map[ID]bool),
+	}
+
+	//
+	// The parents of the commits of a shallow clone are missing.
+	//
+	if b, err := ioutil.ReadFile(filepath.Join(commonDir, "shallow")); err == nil {
+		for _, line := range strings.Fields(string(b)) {
+			if id, err := ParseID(line); err == nil {
+				r.shallow[id] = true
+			}
+		}
+	}
+	return r, nil
+}
+
+// Close closes the packfiles of the repository.
+func (r *Repository) Close() error {
+	return r.objects.close()
+}
+
+// GitDir returns the git directory of the repository.
+func (r *Repository) GitDir() string {
+	return r.gitDir
+}
+
+// HooksDir returns the directory of the repository's hooks: core.hooksPath
+// if it is set, and otherwise the hooks directory of the git directory.
+func (r *Repository) HooksDir() string {
+	dir, ok := configValue(r.commonDir, "core", "hookspath")
+	if !ok || dir == "" {
+		return filepath.Join(r.commonDir, "hooks")
+	}
+	if strings.HasPrefix(dir, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			dir = filepath.Join(home, dir[2:])
+		}
+	}
+	if !filepath.IsAbs(dir) {
+		//
+		// Relative hooks paths are relative to the top of the working
+		// tree: the parent of the git directory, or of the .git file
+		// of a linked worktree.
+		//
+		top := filepath.Dir(r.gitDir)
+		if b, err := ioutil.ReadFile(filepath.Join(r.gitDir, "gitdir")); err == nil {
+			top = filepath.Dir(strings.TrimSpace(string(b)))
+		}
+		dir = filepath.Join(top, dir)
+	}
+	return dir
+}
+
+// findGitDir returns the git directory of the repository at path.
+func findGitDir(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	dotGit := filepath.Join(abs, ".git")
+	info, err := os.Stat(dotGit)
+	switch {
+	case err == nil && info.IsDir():
+		return dotGit, nil
+	case err == nil:
+		//
+		// Worktrees and submodules have a .git file that points to their
+		// git directory.
+		//
+		b, err := ioutil.ReadFile(dotGit)
+		if err != nil {
+			return "", err
+		}
+		line := strings.TrimSpace(string(b))
+		if !strings.HasPrefix(line, "gitdir:") {
+			return "", errors.Errorf("%s is not a valid .git file", dotGit)
+		}
+		dir := strings.TrimSpace(strings.TrimPrefix(line, "gitdir:"))
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(abs, dir)
+		}
+		return dir, nil
+	}
+	if isGitDir(abs) {
+		return abs, nil
+	}
+	return "", errors.Errorf("%s is not a git repository", path)
+}
+
+func isGitDir(dir string) bool {
+	if _, err := os.Stat(filepath.Join(dir, "HEAD")); err != nil {
+		return false
+	}
+	_, err := os.Stat(filepath.Join(dir, "objects"))
+	if err != nil {
+		_, err = os.Stat(filepath.Join(dir, "commondir"))
+	}
+	return err == nil
+}
+
+// checkObjectFormat returns an error for repositories whose object IDs
+// aren't SHA-1.
+func checkObjectFormat(commonDir string) error {
+	if format, ok := configValue(commonDir, "extensions", "objectformat"); ok && !strings.EqualFold(format, "sha1") {
+		return errors.Errorf("unsupported object format %s", format)
+	}
+	return nil
+}
+
+// configValue returns the value of key in section of the repository's
+// config file. Includes and subsections aren't supported.
+func configValue(commonDir, section, key string) (string, bool) {
+	f, err := os.Open(filepath.Join(commonDir, "config"))
+	if err != nil {
+		return "", false
+	}
+	defer f.Close()
+	var value string
+	found := false
+	current := ""
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			current = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) == 2 && strings.EqualFold(current, section) && strings.EqualFold(strings.TrimSpace(kv[0]), key) {
+			value, found = strings.Trim(strings.TrimSpace(kv[1]), `"`), true
+		}
+	}
+	return value, found
+}
+
+// Ref is a named reference to an object.
+type Ref struct {
+	Name string
+	ID   ID
+}
+
+// Refs returns the refs of the repository, sorted by name, with symbolic
+// refs resolved. HEAD is not included.
+func (r *Repository) Refs() ([]Ref, error) {
+	refs := make(map[string]ID)
+	if err := r.readPackedRefs(func(name string, id ID) {
+		refs[name] = id
+	}); err != nil {
+		return nil, err
+	}
+	root := filepath.Join(r.commonDir, "refs")
+	err := filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			if p == root && os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(r.commonDir, p)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		id, err := r.resolveRef(name, 0)
+		if err != nil {
+			if errors.Cause(err) == ErrNotFound {
+				return nil
+			}
+			return err
+		}
+		refs[name] = id
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]Ref, 0, len(refs))
+	for name, id := range refs {
+		list = append(list, Ref{Name: name, ID: id})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list, nil
+}
+
+func (r *Repository) readPackedRefs(fn func(name string, id ID)) error {
+	f, err := os.Open(filepath.Join(r.commonDir, "packed-refs"))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := s.Text()
+		if line == "" || line[0] == '#' || line[0] == '^' {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		if id, err := ParseID(fields[0]); err == nil {
+			fn(fields[1], id)
+		}
+	}
+	return s.Err()
+}
+
+// resolveRef returns the object that the ref name points to, following
+// symbolic refs.
+func (r *Repository) resolveRef(name string, depth int) (ID, error) {
+	if depth > 10 {
+		return ID{}, errors.Errorf("too many levels of symbolic refs at %s", name)
+	}
+	dir := r.commonDir
+	if name == "HEAD" {
+		dir = r.gitDir
+	}
+	b, err := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+	if err == nil {
+		line := strings.TrimSpace(string(b))
+		if strings.HasPrefix(line, "ref:") {
+			return r.resolveRef(strings.TrimSpace(strings.TrimPrefix(line, "ref:")), depth+1)
+		}
+		return ParseID(line)
+	}
+	if os.IsPermission(err) {
+		return ID{}, err
+	}
+
+	var id ID
+	found := false
+	if err := r.readPackedRefs(func(n string, i ID) {
+		if n == name {
+			id, found = i, true
+		}
+	}); err != nil {
+		return ID{}, err
+	}
+	if !found {
+		return ID{}, errors.Wrapf(ErrNotFound, "ref %s", name)
+	}
+	return id, nil
+}
+
+// Resolve returns the object named by rev: a ref name such as HEAD, main
+// or refs/tags/v1, or a full or abbreviated object ID, optionally followed
+// by ^, ^n or ~n to select ancestors.
+func (r *Repository) Resolve(rev string) (ID, error) {
+	base := rev
+	suffix := ""
+	if i := strings.IndexAny(rev, "^~"); i >= 0 {
+		base, suffix = rev[:i], rev[i:]
+	}
+	id, err := r.resolveBase(base)
+	if err != nil {
+		return ID{}, err
+	}
+
+	for suffix != "" {
+		op := suffix[0]
+		suffix = suffix[1:]
+		n := 1
+		digits := len(suffix) - len(strings.TrimLeft(suffix, "0123456789"))
+		if digits > 0 {
+			n, _ = strconv.Atoi(suffix[:digits])
+			suffix = suffix[digits:]
+		}
+		c, err := r.Commit(id)
+		if err != nil {
+			return ID{}, errors.Wrapf(err, "error resolving %s", rev)
+		}
+		switch {
+		case op == '^' && n == 0:
+			id = c.ID
+		case op == '^':
+			if n > len(c.Parents) {
+				return ID{}, errors.Wrapf(ErrNotFound, "revision %s", rev)
+			}
+			id = c.Parents[n-1]
+		default:
+			id = c.ID
+			for i := 0; i < n; i++ {
+				c, err = r.Commit(id)
+				if err != nil {
+					return ID{}, errors.Wrapf(err, "error resolving %s", rev)
+				}
+				if len(c.Parents) == 0 {
+					return ID{}, errors.Wrapf(ErrNotFound, "revision %s", rev)
+				}
+				id = c.Parents[0]
+			}
+		}
+	}
+	return id, nil
+}
+
+func (r *Repository) resolveBase(name string) (ID, error) {
+	if name == "" {
+		return ID{}, errors.New("empty revision")
+	}
+	candidates := []string{name}
+	if name != "HEAD" {
+		candidates = append(candidates,
+			"refs/"+name,
+			"refs/tags/"+name,
+			"refs/heads/"+name,
+			"refs/remotes/"+name,
+			"refs/remotes/"+name+"/HEAD",
+		)
+	}
+	for _, c := range candidates {
+		id, err := r.resolveRef(c, 0)
+		if err == nil {
+			return id, nil
+		}
+		if errors.Cause(err) != ErrNotFound {
+			return ID{}, err
+		}
+	}
+	if len(name) >= 4 && len(name) <= 40 && strings.Trim(strings.ToLower(name), "0123456789abcdef") == "" {
+		return r.objects.findPrefix(strings.ToLower(name))
+	}
+	return ID{}, errors.Wrapf(ErrNotFound, "revision %s", name)
+}
@@ -0,0 +1,221 @@
+package git_test
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/joelanford/goscan/utils/git"
+	"github.com/stretchr/testify/assert"
+)
+
+func run(t *testing.T, dir string, args ...string) string {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME=Alice", "GIT_AUTHOR_EMAIL=alice@example.com",
+		"GIT_COMMITTER_NAME=Alice", "GIT_COMMITTER_EMAIL=alice@example.com",
+		"GIT_AUTHOR_DATE=1500000000 +0200", "GIT_COMMITTER_DATE=1500000000 +0200",
+		"GIT_CONFIG_NOSYSTEM=1", "HOME="+dir,
+	)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s: %s: %s", strings.Join(args, " "), err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+func write(t *testing.T, dir, name, data string) {
+	p := filepath.Join(dir, name)
+	assert.NoError(t, os.MkdirAll(filepath.Dir(p), 0755))
+	assert.NoError(t, ioutil.WriteFile(p, []byte(data), 0644))
+}
+
+func blobs(t *testing.T, r *git.Repository, revs ...string) map[string]string {
+	found := make(map[string]string)
+	assert.NoError(t, r.Blobs(revs, func(b git.Blob) error {
+		data, err := r.ReadBlob(b.ID)
+		if err != nil {
+			return err
+		}
+		found[b.Commit.ID.String()[:7]+":"+b.Path] = string(data)
+		return nil
+	}))
+	return found
+}
+
+func TestBlobs(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir, err := ioutil.TempDir("", "goscan-git")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	//
+	// The second commit changes one file and keeps another, and a branch
+	// adds a file that is deleted again.
+	//
+	run(t, dir, "init", "-q", "-b", "main")
+	long := strings.Repeat("filler line\n", 200)
+	write(t, dir, "a.txt", long+"secret password\n")
+	write(t, dir, "sub/b.txt", "plain")
+	run(t, dir, "add", "-A")
+	run(t, dir, "commit", "-q", "-m", "first")
+	first := run(t, dir, "rev-parse", "--short=7", "HEAD")
+	write(t, dir, "a.txt", long+"no more\n")
+	run(t, dir, "commit", "-q", "-am", "second")
+	second := run(t, dir, "rev-parse", "--short=7", "HEAD")
+	run(t, dir, "checkout", "-q", "-b", "topic")
+	write(t, dir, "c.txt", "leaked password")
+	run(t, dir, "add", "-A")
+	run(t, dir, "commit", "-q", "-m", "third")
+	third := run(t, dir, "rev-parse", "--short=7", "HEAD")
+	run(t, dir, "rm", "-q", "c.txt")
+	run(t, dir, "commit", "-q", "-m", "fourth")
+	run(t, dir, "checkout", "-q", "main")
+	run(t, dir, "tag", "-a", "-m", "v1", "v1", first)
+
+	check := func() {
+		r, err := git.Open(dir)
+		assert.NoError(t, err)
+		defer r.Close()
+
+		all := blobs(t, r)
+		assert.Equal(t, map[string]string{
+			first + ":a.txt":     long + "secret password\n",
+			first + ":sub/b.txt": "plain",
+			second + ":a.txt":    long + "no more\n",
+			third + ":c.txt":     "leaked password",
+		}, all)
+
+		assert.Equal(t, map[string]string{
+			first + ":a.txt":     long + "secret password\n",
+			first + ":sub/b.txt": "plain",
+		}, blobs(t, r, "v1"))
+
+		keys := func(m map[string]string) []string {
+			var list []string
+			for k := range m {
+				list = append(list, k)
+			}
+			sort.Strings(list)
+			return list
+		}
+		assert.Equal(t, []string{third + ":c.txt"}, keys(blobs(t, r, "main..topic")))
+		assert.Equal(t, []string{second + ":a.txt"}, keys(blobs(t, r, "topic~2", "^"+first)))
+
+		c, err := r.Commit(mustResolve(t, r, "topic^"))
+		assert.NoError(t, err)
+		assert.Equal(t, third, c.ID.String()[:7])
+		assert.Equal(t, "Alice", c.Author.Name)
+		assert.Equal(t, "alice@example.com", c.Author.Email)
+		assert.Equal(t, int64(1500000000), c.Author.When.Unix())
+
+		assert.Error(t, r.Blobs([]string{"main...topic"}, func(git.Blob) error { return nil }))
+		assert.Error(t, r.Blobs([]string{"nosuchref"}, func(git.Blob) error { return nil }))
+	}
+
+	//
+	// The same history is read from loose objects, and then from a
+	// packfile with deltas and packed refs.
+	//
+	check()
+	run(t, dir, "gc", "-q", "--aggressive")
+	packs, _ := filepath.Glob(filepath.Join(dir, ".git", "objects", "pack", "*.pack"))
+	assert.NotEmpty(t, packs)
+	check()
+}
+
+func mustResolve(t *testing.T, r *git.Repository, rev string) git.ID {
+	id, err := r.Resolve(rev)
+	assert.NoError(t, err)
+	return id
+}
+
+func TestAddedLines(t *testing.T) {
+	assert.Equal(t, []int{1, 2}, git.AddedLines(nil, []byte("a\nb\n")))
+	assert.Empty(t, git.AddedLines([]byte("a\nb\n"), []byte("a\nb\n")))
+	assert.Equal(t, []int{2, 4}, git.AddedLines([]byte("a\nb\nc\n"), []byte("a\nx\nb\ny\n")))
+	assert.Equal(t, []int{3}, git.AddedLines([]byte("a\nb"), []byte("a\nb\nc")))
+	assert.Equal(t, []int{1}, git.AddedLines([]byte("a\nb\nc\n"), []byte("c\nb\nc\n")))
+}
+
+func TestDiff(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir, err := ioutil.TempDir("", "goscan-git")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	run(t, dir, "init", "-q", "-b", "main")
+	write(t, dir, "keep.txt", "same")
+	write(t, dir, "edit.txt", "one\ntwo\n")
+	write(t, dir, "old/move.txt", "moved")
+	write(t, dir, "gone.txt", "gone")
+	run(t, dir, "add", "-A")
+	run(t, dir, "commit", "-q", "-m", "base")
+	base := run(t, dir, "rev-parse", "HEAD")
+	run(t, dir, "checkout", "-q", "-b", "topic")
+	write(t, dir, "edit.txt", "one\nnew\ntwo\n")
+	write(t, dir, "dir/new.txt", "new")
+	run(t, dir, "mv", "old/move.txt", "moved.txt")
+	run(t, dir, "rm", "-q", "gone.txt")
+	run(t, dir, "add", "-A")
+	run(t, dir, "commit", "-q", "-m", "topic")
+	run(t, dir, "checkout", "-q", "main")
+	write(t, dir, "main.txt", "main")
+	run(t, dir, "add", "-A")
+	run(t, dir, "commit", "-q", "-m", "main")
+
+	r, err := git.Open(dir)
+	assert.NoError(t, err)
+	defer r.Close()
+
+	type change struct {
+		path  string
+		added bool
+	}
+	summarize := func(changes []git.Change) []change {
+		var list []change
+		for _, c := range changes {
+			list = append(list, change{c.Path, c.Added()})
+		}
+		return list
+	}
+	tree := func(rev string) git.ID {
+		c, err := r.Commit(mustResolve(t, r, rev))
+		assert.NoError(t, err)
+		return c.Tree
+	}
+
+	changes, err := r.DiffTrees(tree(base), tree("topic"))
+	assert.NoError(t, err)
+	assert.Equal(t, []change{{"dir/new.txt", true}, {"edit.txt", false}, {"moved.txt", false}}, summarize(changes))
+	assert.Equal(t, "old/move.txt", changes[2].Old.Name)
+
+	mb, err := r.MergeBase(mustResolve(t, r, "main"), mustResolve(t, r, "topic"))
+	assert.NoError(t, err)
+	assert.Equal(t, base, mb.String())
+
+	//
+	// Staged changes are read from each version of the index, and files
+	// that are only intended to be added are left out.
+	//
+	write(t, dir, "edit.txt", "one\ntwo\nstaged\n")
+	write(t, dir, "staged.txt", "staged")
+	write(t, dir, "intent.txt", "intent")
+	run(t, dir, "add", "edit.txt", "staged.txt")
+	run(t, dir, "add", "-N", "intent.txt")
+	for _, version := range []string{"2", "3", "4"} {
+		run(t, dir, "update-index", "--index-version", version)
+		changes, err = r.DiffIndex(tree("HEAD"))
+		assert.NoError(t, err)
+		assert.Equal(t, []change{{"edit.txt", false}, {"staged.txt", true}}, summarize(changes), "version %s", version)
+	}
+}
@@ -0,0 +1,116 @@
+package git
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// Index returns the entries of the index, the files staged to be
+// committed, with their full paths as names. Entries of unmerged files,
+// of files that are only intended to be added, and of the directories of
+// a sparse index are left out. Versions 2, 3 and 4 of the index are
+// supported.
+func (r *Repository) Index() ([]TreeEntry, error) {
+	b, err := ioutil.ReadFile(filepath.Join(r.gitDir, "index"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(b) < 12 || !bytes.Equal(b[:4], []byte("DIRC")) {
+		return nil, errors.New("index: not an index file")
+	}
+	version := binary.BigEndian.Uint32(b[4:8])
+	if version < 2 || version > 4 {
+		return nil, errors.Errorf("index: unsupported version %d", version)
+	}
+	count := int(binary.BigEndian.Uint32(b[8:12]))
+
+	//
+	// Each entry has 40 bytes of stat data, the mode among them, then the
+	// ID, flags, and the path.
+	//
+	const fixed = 40 + 20 + 2
+	var entries []TreeEntry
+	var name []byte
+	data := b[12:]
+	for i := 0; i < count; i++ {
+		if len(data) < fixed {
+			return nil, errors.New("index: truncated entry")
+		}
+		mode := binary.BigEndian.Uint32(data[24:28])
+		var id ID
+		copy(id[:], data[40:60])
+		flags := binary.BigEndian.Uint16(data[60:62])
+		n := fixed
+		intentToAdd := false
+		if flags&0x4000 != 0 && version >= 3 {
+			if len(data) < n+2 {
+				return nil, errors.New("index: truncated entry")
+			}
+			intentToAdd = binary.BigEndian.Uint16(data[n:n+2])&0x2000 != 0
+			n += 2
+		}
+
+		//
+		// Version 4 paths are compressed: they drop some bytes from the
+		// end of the previous path, and add a suffix. Earlier versions
+		// pad entries with NULs to a multiple of 8 bytes.
+		//
+		if version == 4 {
+			strip, used := indexVarint(data[n:])
+			if used == 0 || strip > len(name) {
+				return nil, errors.New("index: malformed path")
+			}
+			n += used
+			nul := bytes.IndexByte(data[n:], 0)
+			if nul < 0 {
+				return nil, errors.New("index: malformed path")
+			}
+			name = append(name[:len(name)-strip], data[n:n+nul]...)
+			n += nul + 1
+		} else {
+			nul := bytes.IndexByte(data[n:], 0)
+			if nul < 0 {
+				return nil, errors.New("index: malformed path")
+			}
+			name = append(name[:0], data[n:n+nul]...)
+			n = (n + nul + 8) &^ 7
+			if n > len(data) {
+				return nil, errors.New("index: truncated entry")
+			}
+		}
+		data = data[n:]
+
+		stage := (flags >> 12) & 3
+		if stage != 0 || intentToAdd || mode == ModeTree {
+			continue
+		}
+		entries = append(entries, TreeEntry{Mode: mode, Name: string(name), ID: id})
+	}
+	return entries, nil
+}
+
+// indexVarint decodes the variable length integers of version 4 indexes,
+// which are encoded the same way as the offsets of packfile deltas.
+func indexVarint(b []byte) (int, int) {
+	if len(b) == 0 {
+		return 0, 0
+	}
+	v := int(b[0] & 0x7f)
+	i := 1
+	for b[i-1]&0x80 != 0 {
+		if i >= len(b) || i > 8 {
+			return 0, 0
+		}
+		v = (v+1)<<7 | int(b[i]&0x7f)
+		i++
+	}
+	return v, i
+}
@@ -0,0 +1,211 @@
+package git
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ObjectType is the type of an object.
+type ObjectType int
+
+const (
+	TypeCommit ObjectType = 1
+	TypeTree   ObjectType = 2
+	TypeBlob   ObjectType = 3
+	TypeTag    ObjectType = 4
+)
+
+func (t ObjectType) String() string {
+	switch t {
+	case TypeCommit:
+		return "commit"
+	case TypeTree:
+		return "tree"
+	case TypeBlob:
+		return "blob"
+	case TypeTag:
+		return "tag"
+	}
+	return "unknown"
+}
+
+func parseObjectType(s string) (ObjectType, error) {
+	switch s {
+	case "commit":
+		return TypeCommit, nil
+	case "tree":
+		return TypeTree, nil
+	case "blob":
+		return TypeBlob, nil
+	case "tag":
+		return TypeTag, nil
+	}
+	return 0, errors.Errorf("unknown object type %q", s)
+}
+
+// objectStore reads objects from an objects directory: its loose objects,
+// its packfiles, and the object stores it borrows from.
+type objectStore struct {
+	dir        string
+	packs      []*pack
+	alternates []*objectStore
+}
+
+func openObjectStore(dir string) (*objectStore, error) {
+	return openObjectStoreDepth(dir, 0)
+}
+
+func openObjectStoreDepth(dir string, depth int) (*objectStore, error) {
+	s := &objectStore{dir: dir}
+	idxs, err := filepath.Glob(filepath.Join(dir, "pack", "*.idx"))
+	if err != nil {
+		return nil, err
+	}
+	for _, idx := range idxs {
+		p, err := openPack(s, strings.TrimSuffix(idx, ".idx"))
+		if err != nil {
+			s.close()
+			return nil, err
+		}
+		s.packs = append(s.packs, p)
+	}
+
+	//
+	// Alternates are other objects directories, such as those of the
+	// repository a clone was made from with --shared.
+	//
+	if b, err := ioutil.ReadFile(filepath.Join(dir, "info", "alternates")); err == nil && depth < 5 {
+		for _, line := range strings.Split(string(b), "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" || line[0] == '#' {
+				continue
+			}
+			if !filepath.IsAbs(line) {
+				line = filepath.Join(dir, line)
+			}
+			alt, err := openObjectStoreDepth(line, depth+1)
+			if err != nil {
+				s.close()
+				return nil, errors.Wrapf(err, "error opening alternate %s", line)
+			}
+			s.alternates = append(s.alternates, alt)
+		}
+	}
+	return s, nil
+}
+
+func (s *objectStore) close() error {
+	var err error
+	for _, p := range s.packs {
+		if cerr := p.close(); err == nil {
+			err = cerr
+		}
+	}
+	for _, alt := range s.alternates {
+		if cerr := alt.close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// read returns the type and contents of the object id.
+func (s *objectStore) read(id ID) (ObjectType, []byte, error) {
+	for _, p := range s.packs {
+		if off, ok := p.find(id); ok {
+			return p.readAt(off)
+		}
+	}
+	t, data, err := s.readLoose(id)
+	if err == nil || errors.Cause(err) != ErrNotFound {
+		return t, data, err
+	}
+	for _, alt := range s.alternates {
+		t, data, err := alt.read(id)
+		if err == nil || errors.Cause(err) != ErrNotFound {
+			return t, data, err
+		}
+	}
+	return 0, nil, errors.Wrapf(ErrNotFound, "object %s", id)
+}
+
+func (s *objectStore) readLoose(id ID) (ObjectType, []byte, error) {
+	hex := id.String()
+	f, err := os.Open(filepath.Join(s.dir, hex[:2], hex[2:]))
+	if os.IsNotExist(err) {
+		return 0, nil, errors.Wrapf(ErrNotFound, "object %s", id)
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+	defer f.Close()
+	zr, err := zlib.NewReader(f)
+	if err != nil {
+		return 0, nil, errors.Wrapf(err, "error reading object %s", id)
+	}
+	defer zr.Close()
+	b, err := ioutil.ReadAll(zr)
+	if err != nil {
+		return 0, nil, errors.Wrapf(err, "error reading object %s", id)
+	}
+
+	//
+	// Loose objects start with a header of their type and size.
+	//
+	nul := bytes.IndexByte(b, 0)
+	if nul < 0 {
+		return 0, nil, errors.Errorf("malformed object %s", id)
+	}
+	header := strings.SplitN(string(b[:nul]), " ", 2)
+	if len(header) != 2 {
+		return 0, nil, errors.Errorf("malformed object %s", id)
+	}
+	t, err := parseObjectType(header[0])
+	if err != nil {
+		return 0, nil, errors.Wrapf(err, "malformed object %s", id)
+	}
+	size, err := strconv.Atoi(header[1])
+	if err != nil || size != len(b)-nul-1 {
+		return 0, nil, errors.Errorf("malformed object %s", id)
+	}
+	return t, b[nul+1:], nil
+}
+
+// findPrefix returns the object whose hex ID starts with prefix.
+func (s *objectStore) findPrefix(prefix string) (ID, error) {
+	matches := make(map[ID]bool)
+	s.matchPrefix(prefix, matches)
+	switch len(matches) {
+	case 0:
+		return ID{}, errors.Wrapf(ErrNotFound, "object %s", prefix)
+	case 1:
+		for id := range matches {
+			return id, nil
+		}
+	}
+	return ID{}, errors.Errorf("object ID %s is ambiguous", prefix)
+}
+
+func (s *objectStore) matchPrefix(prefix string, matches map[ID]bool) {
+	for _, p := range s.packs {
+		p.matchPrefix(prefix, matches)
+	}
+	names, _ := ioutil.ReadDir(filepath.Join(s.dir, prefix[:2]))
+	for _, fi := range names {
+		if strings.HasPrefix(prefix[:2]+fi.Name(), prefix) {
+			if id, err := ParseID(prefix[:2] + fi.Name()); err == nil {
+				matches[id] = true
+			}
+		}
+	}
+	for _, alt := range s.alternates {
+		alt.matchPrefix(prefix, matches)
+	}
+}
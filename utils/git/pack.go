@@ -0,0 +1,332 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	packOfsDelta = 6
+	packRefDelta = 7
+)
+
+// maxPackCache is the most bytes of objects that a pack keeps around to
+// resolve the deltas of other objects.
+const maxPackCache = 64 << 20
+
+// pack is a packfile and its version 2 index.
+type pack struct {
+	store *objectStore
+	name  string
+	f     *os.File
+	size  int64
+
+	fanout  [256]uint32
+	ids     []byte
+	offsets []byte
+	large   []byte
+
+	mu        sync.Mutex
+	cache     map[int64]cachedObject
+	cacheSize int
+}
+
+type cachedObject struct {
+	t    ObjectType
+	data []byte
+}
+
+func openPack(store *objectStore, name string) (*pack, error) {
+	idx, err := ioutil.ReadFile(name + ".idx")
+	if err != nil {
+		return nil, err
+	}
+	if len(idx) < 8+256*4 || !bytes.Equal(idx[:4], []byte("\377tOc")) || binary.BigEndian.Uint32(idx[4:8]) != 2 {
+		return nil, errors.Errorf("%s.idx: unsupported pack index version", name)
+	}
+	p := &pack{store: store, name: name, cache: make(map[int64]cachedObject)}
+	for i := range p.fanout {
+		p.fanout[i] = binary.BigEndian.Uint32(idx[8+4*i:])
+	}
+	n := int(p.fanout[255])
+	off := 8 + 256*4
+	if len(idx) < off+n*(20+4+4) {
+		return nil, errors.Errorf("%s.idx: truncated pack index", name)
+	}
+	p.ids = idx[off : off+20*n]
+	off += 20 * n
+	off += 4 * n // CRCs
+	p.offsets = idx[off : off+4*n]
+	off += 4 * n
+	p.large = idx[off:]
+
+	p.f, err = os.Open(name + ".pack")
+	if err != nil {
+		return nil, err
+	}
+	info, err := p.f.Stat()
+	if err != nil {
+		p.f.Close()
+		return nil, err
+	}
+	p.size = info.Size()
+	var header [12]byte
+	if _, err := p.f.ReadAt(header[:], 0); err != nil || !bytes.Equal(header[:4], []byte("PACK")) {
+		p.f.Close()
+		return nil, errors.Errorf("%s.pack: not a packfile", name)
+	}
+	return p, nil
+}
+
+func (p *pack) close() error {
+	return p.f.Close()
+}
+
+func (p *pack) id(i int) []byte {
+	return p.ids[20*i : 20*i+20]
+}
+
+// find returns the offset in the pack of the object id.
+func (p *pack) find(id ID) (int64, bool) {
+	lo := 0
+	if id[0] > 0 {
+		lo = int(p.fanout[id[0]-1])
+	}
+	hi := int(p.fanout[id[0]])
+	i := lo + sort.Search(hi-lo, func(i int) bool {
+		return bytes.Compare(p.id(lo+i), id[:]) >= 0
+	})
+	if i >= hi || !bytes.Equal(p.id(i), id[:]) {
+		return 0, false
+	}
+
+	//
+	// Offsets of 2GB and over are in the table of large offsets.
+	//
+	off := binary.BigEndian.Uint32(p.offsets[4*i:])
+	if off&0x80000000 == 0 {
+		return int64(off), true
+	}
+	j := int(off &^ 0x80000000)
+	if len(p.large) < 8*j+8 {
+		return 0, false
+	}
+	return int64(binary.BigEndian.Uint64(p.large[8*j:])), true
+}
+
+func (p *pack) matchPrefix(prefix string, matches map[ID]bool) {
+	n := int(p.fanout[255])
+	for i := 0; i < n; i++ {
+		if s := hex.EncodeToString(p.id(i)); len(s) >= len(prefix) && s[:len(prefix)] == prefix {
+			var id ID
+			copy(id[:], p.id(i))
+			matches[id] = true
+		}
+	}
+}
+
+// readAt returns the type and contents of the object at offset, applying
+// its deltas.
+func (p *pack) readAt(offset int64) (ObjectType, []byte, error) {
+	p.mu.Lock()
+	c, ok := p.cache[offset]
+	p.mu.Unlock()
+	if ok {
+		return c.t, c.data, nil
+	}
+
+	if offset < 12 || offset >= p.size {
+		return 0, nil, errors.Errorf("%s.pack: invalid offset %d", p.name, offset)
+	}
+	r := bufio.NewReader(io.NewSectionReader(p.f, offset, p.size-offset))
+
+	//
+	// Each entry starts with its type and inflated size, in a variable
+	// length encoding.
+	//
+	b, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, p.corrupt(offset, err)
+	}
+	t := int(b>>4) & 7
+	size := int64(b & 15)
+	for shift := uint(4); b&0x80 != 0; shift += 7 {
+		if b, err = r.ReadByte(); err != nil {
+			return 0, nil, p.corrupt(offset, err)
+		}
+		size |= int64(b&0x7f) << shift
+	}
+
+	var baseType ObjectType
+	var base []byte
+	switch t {
+	case int(TypeCommit), int(TypeTree), int(TypeBlob), int(TypeTag):
+		data, err := inflate(r, size)
+		if err != nil {
+			return 0, nil, p.corrupt(offset, err)
+		}
+		return ObjectType(t), data, nil
+	case packOfsDelta:
+		if b, err = r.ReadByte(); err != nil {
+			return 0, nil, p.corrupt(offset, err)
+		}
+		rel := int64(b & 0x7f)
+		for b&0x80 != 0 {
+			if b, err = r.ReadByte(); err != nil {
+				return 0, nil, p.corrupt(offset, err)
+			}
+			rel = (rel+1)<<7 | int64(b&0x7f)
+		}
+		if rel <= 0 || rel >= offset {
+			return 0, nil, p.corrupt(offset, errors.New("invalid delta base offset"))
+		}
+		baseType, base, err = p.readAt(offset - rel)
+		if err != nil {
+			return 0, nil, err
+		}
+		p.remember(offset-rel, baseType, base)
+	case packRefDelta:
+		var id ID
+		if _, err := io.ReadFull(r, id[:]); err != nil {
+			return 0, nil, p.corrupt(offset, err)
+		}
+		baseType, base, err = p.store.read(id)
+		if err != nil {
+			return 0, nil, err
+		}
+	default:
+		return 0, nil, p.corrupt(offset, errors.Errorf("unknown object type %d", t))
+	}
+
+	delta, err := inflate(r, size)
+	if err != nil {
+		return 0, nil, p.corrupt(offset, err)
+	}
+	data, err := applyDelta(base, delta)
+	if err != nil {
+		return 0, nil, p.corrupt(offset, err)
+	}
+	p.remember(offset, baseType, data)
+	return baseType, data, nil
+}
+
+// remember caches an object that was rebuilt from deltas, since it is
+// likely to be the base of other deltas. The cache is emptied once it
+// gets too big.
+func (p *pack) remember(offset int64, t ObjectType, data []byte) {
+	if len(data) > maxPackCache/16 {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.cacheSize+len(data) > maxPackCache {
+		p.cache = make(map[int64]cachedObject)
+		p.cacheSize = 0
+	}
+	p.cache[offset] = cachedObject{t: t, data: data}
+	p.cacheSize += len(data)
+}
+
+func (p *pack) corrupt(offset int64, err error) error {
+	return errors.Wrapf(err, "%s.pack: corrupt object at offset %d", p.name, offset)
+}
+
+func inflate(r io.Reader, size int64) ([]byte, error) {
+	zr, err := zlib.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	data := make([]byte, size)
+	if _, err := io.ReadFull(zr, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// applyDelta rebuilds an object from its base and a delta, which is a
+// list of instructions to copy ranges of the base or insert new data.
+func applyDelta(base, delta []byte) ([]byte, error) {
+	baseSize, delta, err := deltaSize(delta)
+	if err != nil {
+		return nil, err
+	}
+	if baseSize != len(base) {
+		return nil, errors.New("delta base size mismatch")
+	}
+	size, delta, err := deltaSize(delta)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 0, size)
+	for len(delta) > 0 {
+		op := delta[0]
+		delta = delta[1:]
+		if op&0x80 == 0 {
+			n := int(op)
+			if n == 0 || n > len(delta) {
+				return nil, errors.New("invalid delta insert")
+			}
+			out = append(out, delta[:n]...)
+			delta = delta[n:]
+			continue
+		}
+
+		//
+		// The bits of a copy op say which bytes of its offset and size
+		// follow it.
+		//
+		var off, n int
+		for i := uint(0); i < 7; i++ {
+			if op&(1<<i) == 0 {
+				continue
+			}
+			if len(delta) == 0 {
+				return nil, errors.New("truncated delta")
+			}
+			if i < 4 {
+				off |= int(delta[0]) << (8 * i)
+			} else {
+				n |= int(delta[0]) << (8 * (i - 4))
+			}
+			delta = delta[1:]
+		}
+		if n == 0 {
+			n = 0x10000
+		}
+		if off < 0 || off+n > len(base) {
+			return nil, errors.New("invalid delta copy")
+		}
+		out = append(out, base[off:off+n]...)
+	}
+	if len(out) != size {
+		return nil, errors.New("delta result size mismatch")
+	}
+	return out, nil
+}
+
+func deltaSize(delta []byte) (int, []byte, error) {
+	size := 0
+	for shift := uint(0); ; shift += 7 {
+		if len(delta) == 0 || shift > 56 {
+			return 0, nil, errors.New("truncated delta")
+		}
+		b := delta[0]
+		delta = delta[1:]
+		size |= int(b&0x7f) << shift
+		if b&0x80 == 0 {
+			return size, delta, nil
+		}
+	}
+}
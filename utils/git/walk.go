@@ -0,0 +1,207 @@
+package git
+
+import (
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Blob is a blob in the history of a repository, and where it was first
+// seen: the commit, and the path in that commit's tree.
+type Blob struct {
+	ID     ID
+	Commit *Commit
+	Path   string
+}
+
+// Blobs calls fn for each blob that is reachable from revs, once per blob
+// ID. Each rev is a revision that Resolve accepts, a range "a..b", or an
+// exclusion "^a". Without revs, all refs and HEAD are walked. Commits are
+// walked parents first, so each blob is attributed to the earliest commit
+// that introduced it. Symlinks and submodules are skipped.
+func (r *Repository) Blobs(revs []string, fn func(Blob) error) error {
+	include, exclude, err := r.parseRevs(revs)
+	if err != nil {
+		return err
+	}
+
+	//
+	// Excluded commits, and all of their ancestors, are marked seen before
+	// the walk starts.
+	//
+	seen := make(map[ID]bool)
+	var hidden []*Commit
+	if err := r.walkCommits(exclude, seen, func(c *Commit) error {
+		hidden = append(hidden, c)
+		return nil
+	}); err != nil {
+		return err
+	}
+	w := &treeWalker{r: r, trees: make(map[ID]bool), blobs: make(map[ID]bool)}
+	for _, c := range hidden {
+		if err := w.walk(c, c.Tree, "", nil); err != nil {
+			return err
+		}
+	}
+
+	return r.walkCommits(include, seen, func(c *Commit) error {
+		return w.walk(c, c.Tree, "", fn)
+	})
+}
+
+// parseRevs resolves revs into the commits to include and exclude.
+func (r *Repository) parseRevs(revs []string) (include, exclude []ID, err error) {
+	if len(revs) == 0 {
+		refs, err := r.Refs()
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, ref := range refs {
+			include = append(include, ref.ID)
+		}
+		if id, err := r.resolveRef("HEAD", 0); err == nil {
+			include = append(include, id)
+		}
+		return include, nil, nil
+	}
+
+	resolve := func(rev string) (ID, error) {
+		id, err := r.Resolve(rev)
+		if err != nil {
+			return ID{}, errors.Wrapf(err, "error resolving %s", rev)
+		}
+		return id, nil
+	}
+	for _, rev := range revs {
+		switch {
+		case strings.Contains(rev, "..."):
+			return nil, nil, errors.Errorf("symmetric difference %s is not supported", rev)
+		case strings.Contains(rev, ".."):
+			parts := strings.SplitN(rev, "..", 2)
+			for i := range parts {
+				if parts[i] == "" {
+					parts[i] = "HEAD"
+				}
+			}
+			from, err := resolve(parts[0])
+			if err != nil {
+				return nil, nil, err
+			}
+			to, err := resolve(parts[1])
+			if err != nil {
+				return nil, nil, err
+			}
+			exclude = append(exclude, from)
+			include = append(include, to)
+		case strings.HasPrefix(rev, "^"):
+			id, err := resolve(rev[1:])
+			if err != nil {
+				return nil, nil, err
+			}
+			exclude = append(exclude, id)
+		default:
+			id, err := resolve(rev)
+			if err != nil {
+				return nil, nil, err
+			}
+			include = append(include, id)
+		}
+	}
+	return include, exclude, nil
+}
+
+// walkCommits calls fn for each commit reachable from starts that isn't in
+// seen, parents before children, and adds them to seen. Refs to objects
+// other than commits, such as tags of trees, are skipped.
+func (r *Repository) walkCommits(starts []ID, seen map[ID]bool, fn func(*Commit) error) error {
+	type frame struct {
+		c    *Commit
+		next int
+	}
+	for _, start := range starts {
+		t, _, id, err := r.peel(start)
+		if err != nil {
+			return err
+		}
+		if t != TypeCommit || seen[id] {
+			continue
+		}
+		c, err := r.Commit(id)
+		if err != nil {
+			return err
+		}
+		seen[id] = true
+
+		//
+		// An explicit stack keeps long histories from growing the
+		// goroutine stack without bound.
+		//
+		stack := []*frame{{c: c}}
+		for len(stack) > 0 {
+			top := stack[len(stack)-1]
+			parents := top.c.Parents
+			if r.shallow[top.c.ID] {
+				parents = nil
+			}
+			if top.next < len(parents) {
+				p := parents[top.next]
+				top.next++
+				if seen[p] {
+					continue
+				}
+				seen[p] = true
+				pc, err := r.Commit(p)
+				if err != nil {
+					return errors.Wrapf(err, "error reading parent of %s", top.c.ID)
+				}
+				stack = append(stack, &frame{c: pc})
+				continue
+			}
+			stack = stack[:len(stack)-1]
+			if err := fn(top.c); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// treeWalker walks trees, skipping the trees and blobs it has already
+// seen.
+type treeWalker struct {
+	r     *Repository
+	trees map[ID]bool
+	blobs map[ID]bool
+}
+
+func (w *treeWalker) walk(c *Commit, tree ID, dir string, fn func(Blob) error) error {
+	if w.trees[tree] {
+		return nil
+	}
+	w.trees[tree] = true
+	entries, err := w.r.Tree(tree)
+	if err != nil {
+		return errors.Wrapf(err, "error reading tree of %s", c.ID)
+	}
+	for _, e := range entries {
+		name := path.Join(dir, e.Name)
+		switch e.Mode &^ 0777 {
+		case ModeTree:
+			if err := w.walk(c, e.ID, name, fn); err != nil {
+				return err
+			}
+		case ModeFile &^ 0777:
+			if w.blobs[e.ID] {
+				continue
+			}
+			w.blobs[e.ID] = true
+			if fn != nil {
+				if err := fn(Blob{ID: e.ID, Commit: c, Path: name}); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -1,6 +1,9 @@
 package keywords
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
@@ -13,8 +16,9 @@ import (
 )
 
 type Keywords struct {
-	keywords   map[string]*Keyword
-	dictionary *ahocorasick.Machine
+	keywords    map[string]*Keyword
+	dictionary  *ahocorasick.Machine
+	fingerprint string
 }
 
 type Keyword struct {
@@ -22,10 +26,18 @@ type Keyword struct {
 	Policies map[string]string `yaml:"policies"`
 }
 
+// Hit is a keyword match. Index and End are the byte offsets of the start
+// and end (exclusive) of the match, and Line and Column are its 1-based
+// position. LineText is the line the match starts on, truncated to
+// ahocorasick.MAX_LINE_TEXT bytes around the match.
 type Hit struct {
 	Word     string            `json:"word"`
 	Index    int               `json:"index"`
+	End      int               `json:"end"`
+	Line     int               `json:"line"`
+	Column   int               `json:"column"`
 	Context  string            `json:"context"`
+	LineText string            `json:"lineText"`
 	Policies map[string]string `json:"policies,omitempty"`
 }
 
@@ -82,10 +94,12 @@ func LoadReader(r io.Reader, policies []string) (*Keywords, error) {
 	dictionary := &ahocorasick.Machine{}
 	dictionary.Build(keywordsBytes)
 
-	return &Keywords{
+	k := &Keywords{
 		keywords:   keywords,
 		dictionary: dictionary,
-	}, nil
+	}
+	k.fingerprint = k.hash()
+	return k, nil
 }
 
 func LoadFile(wordsFile string, policies []string) (*Keywords, error) {
@@ -108,20 +122,71 @@ func (k *Keywords) Keywords() []Keyword {
 	return kwSlice
 }
 
+// Fingerprint returns a hash of the keywords and their policies, which
+// changes whenever the results of a scan with them might.
+func (k *Keywords) Fingerprint() string {
+	return k.fingerprint
+}
+
+func (k *Keywords) hash() string {
+	h := sha256.New()
+	for _, kw := range k.Keywords() {
+		fmt.Fprintf(h, "%q\n", kw.Word)
+		policies := make([]string, 0, len(kw.Policies))
+		for p := range kw.Policies {
+			policies = append(policies, p)
+		}
+		sort.Strings(policies)
+		for _, p := range policies {
+			fmt.Fprintf(h, "\t%q=%q\n", p, kw.Policies[p])
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Validate checks that the dictionary finds every keyword, so that a
+// keywords file can be checked before it replaces one that works.
+func (k *Keywords) Validate() error {
+	for word := range k.keywords {
+		hits, err := k.MatchReadSeeker(strings.NewReader(word), 0)
+		if err != nil {
+			return errors.Wrapf(err, "error matching keyword %q", word)
+		}
+		found := false
+		for _, hit := range hits {
+			if hit.Word == word {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return errors.Errorf("keyword %q does not match itself", word)
+		}
+	}
+	return nil
+}
+
 func (k *Keywords) MatchFile(file string, hitContext int) ([]Hit, error) {
 	f, err := os.Open(file)
 	if err != nil {
 		return nil, err
 	}
 	defer f.Close()
+	return k.MatchReadSeeker(f, hitContext)
+}
 
+func (k *Keywords) MatchReadSeeker(r io.ReadSeeker, hitContext int) ([]Hit, error) {
 	hits := make([]Hit, 0)
-	terms, err := k.dictionary.MultiPatternSearchReadSeeker(f, hitContext, false)
+	terms, err := k.dictionary.MultiPatternSearchReadSeeker(r, hitContext, false)
 	for _, t := range terms {
 		hits = append(hits, Hit{
 			Word:     string(t.Word),
 			Index:    t.Pos,
+			End:      t.End,
+			Line:     t.Line,
+			Column:   t.Column,
 			Context:  string(t.Context),
+			LineText: string(t.LineText),
 			Policies: k.keywords[string(t.Word)].Policies,
 		})
 	}
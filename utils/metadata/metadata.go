@@ -0,0 +1,133 @@
+package metadata
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+
+	filetype "gopkg.in/h2non/filetype.v1"
+)
+
+// headerSize is the number of leading bytes used for file type detection.
+const headerSize = 512
+
+type Metadata struct {
+	Size      int64     `json:"size" yaml:"size"`
+	SHA256    string    `json:"sha256" yaml:"sha256"`
+	SHA1      string    `json:"sha1,omitempty" yaml:"sha1,omitempty"`
+	MD5       string    `json:"md5,omitempty" yaml:"md5,omitempty"`
+	MIMEType  string    `json:"mimeType,omitempty" yaml:"mimeType,omitempty"`
+	Extension string    `json:"extension,omitempty" yaml:"extension,omitempty"`
+	ModTime   time.Time `json:"modTime" yaml:"modTime"`
+	Mode      string    `json:"mode" yaml:"mode"`
+}
+
+// File is a file that metadata can be read from.
+type File interface {
+	io.ReadSeeker
+	Stat() (os.FileInfo, error)
+}
+
+// Reader wraps a file to compute its metadata while it is being read. Reads
+// may seek backwards over data that was already read; each byte of the file
+// is hashed once, in order.
+type Reader struct {
+	file   File
+	pos    int64
+	hashed int64
+	header []byte
+
+	hashes []hash.Hash
+	sha256 hash.Hash
+	sha1   hash.Hash
+	md5    hash.Hash
+}
+
+func NewReader(file File, legacyHashes bool) *Reader {
+	r := &Reader{
+		file:   file,
+		sha256: sha256.New(),
+	}
+	r.hashes = append(r.hashes, r.sha256)
+	if legacyHashes {
+		r.sha1 = sha1.New()
+		r.md5 = md5.New()
+		r.hashes = append(r.hashes, r.sha1, r.md5)
+	}
+	return r
+}
+
+func (r *Reader) Read(p []byte) (int, error) {
+	n, err := r.file.Read(p)
+	if n > 0 {
+		r.update(p[:n])
+	}
+	r.pos += int64(n)
+	return n, err
+}
+
+func (r *Reader) Seek(offset int64, whence int) (int64, error) {
+	pos, err := r.file.Seek(offset, whence)
+	if err != nil {
+		return pos, err
+	}
+	r.pos = pos
+	return pos, nil
+}
+
+func (r *Reader) update(p []byte) {
+	end := r.pos + int64(len(p))
+	if r.pos > r.hashed || end <= r.hashed {
+		return
+	}
+	p = p[r.hashed-r.pos:]
+	for _, h := range r.hashes {
+		h.Write(p)
+	}
+	if len(r.header) < headerSize {
+		n := headerSize - len(r.header)
+		if n > len(p) {
+			n = len(p)
+		}
+		r.header = append(r.header, p[:n]...)
+	}
+	r.hashed = end
+}
+
+// Metadata returns the metadata of the file. Any part of the file that was
+// not read by the caller is read to complete the hashes.
+func (r *Reader) Metadata() (*Metadata, error) {
+	if _, err := r.Seek(r.hashed, io.SeekStart); err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(ioutil.Discard, r); err != nil {
+		return nil, err
+	}
+
+	info, err := r.file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	md := &Metadata{
+		Size:    r.hashed,
+		SHA256:  hex.EncodeToString(r.sha256.Sum(nil)),
+		ModTime: info.ModTime(),
+		Mode:    info.Mode().String(),
+	}
+	if r.sha1 != nil {
+		md.SHA1 = hex.EncodeToString(r.sha1.Sum(nil))
+		md.MD5 = hex.EncodeToString(r.md5.Sum(nil))
+	}
+	if kind, err := filetype.Match(r.header); err == nil && kind != filetype.Unknown {
+		md.MIMEType = kind.MIME.Value
+		md.Extension = kind.Extension
+	}
+	return md, nil
+}
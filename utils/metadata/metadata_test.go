@@ -0,0 +1,50 @@
+package metadata_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"testing"
+
+	"github.com/joelanford/goscan/utils/metadata"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReaderMetadata(t *testing.T) {
+	data := make([]byte, 3000000)
+	rand.New(rand.NewSource(1)).Read(data)
+	copy(data, []byte("\x1f\x8b\x08"))
+	sum := sha256.Sum256(data)
+
+	f, err := ioutil.TempFile("", "goscan-metadata")
+	assert.NoError(t, err)
+	defer os.Remove(f.Name())
+	defer f.Close()
+	_, err = f.Write(data)
+	assert.NoError(t, err)
+
+	//
+	// Read overlapping chunks, stopping before the end of the file, the way
+	// the keyword matcher does.
+	//
+	r := metadata.NewReader(f, true)
+	buf := make([]byte, 1000)
+	for i := int64(0); i < 2000000; i += 900 {
+		_, err := r.Seek(i, io.SeekStart)
+		assert.NoError(t, err)
+		_, err = io.ReadFull(r, buf)
+		assert.NoError(t, err)
+	}
+
+	md, err := r.Metadata()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(data)), md.Size)
+	assert.Equal(t, hex.EncodeToString(sum[:]), md.SHA256)
+	assert.Len(t, md.SHA1, 40)
+	assert.Len(t, md.MD5, 32)
+	assert.Equal(t, "application/gzip", md.MIMEType)
+	assert.Equal(t, "gz", md.Extension)
+}
@@ -0,0 +1,101 @@
+package output
+
+import (
+	"encoding/csv"
+	"io"
+	"sort"
+	"strconv"
+)
+
+var csvHeader = []string{"file", "word", "offset", "policy", "reason", "context"}
+
+type CSVSummaryWriter struct {
+	writer *csv.Writer
+}
+
+func NewCSVSummaryWriter(writer io.Writer) *CSVSummaryWriter {
+	return &CSVSummaryWriter{
+		writer: csv.NewWriter(writer),
+	}
+}
+
+// WriteSummary writes one row per hit and policy. Hits without a policy
+// are written as a single row with empty policy and reason columns. The
+// summaries of comparisons have a diff column with the status of each hit.
+// Scans compared to a baseline have a baseline column, and a row for each
+// hit of the baseline that wasn't found, without an offset.
+func (w *CSVSummaryWriter) WriteSummary(sum ScanSummary) error {
+	header := csvHeader
+	if sum.Stats.Diff != nil {
+		header = append(header[:len(header):len(header)], "diff")
+	}
+	if sum.Stats.Baseline != nil {
+		header = append(header[:len(header):len(header)], "baseline")
+	}
+	if err := w.writer.Write(header); err != nil {
+		return err
+	}
+	for _, r := range sum.Results {
+		for _, h := range r.Hits {
+			row := []string{
+				csvSafe(r.File),
+				csvSafe(h.Word),
+				strconv.Itoa(h.Index),
+				"",
+				"",
+				csvSafe(escapeBinary(h.Context)),
+			}
+			if sum.Stats.Diff != nil {
+				row = append(row, h.Diff)
+			}
+			if sum.Stats.Baseline != nil {
+				row = append(row, h.Baseline)
+			}
+			if len(h.Policies) == 0 {
+				if err := w.writer.Write(row); err != nil {
+					return err
+				}
+				continue
+			}
+
+			policies := make([]string, 0, len(h.Policies))
+			for p := range h.Policies {
+				policies = append(policies, p)
+			}
+			sort.Strings(policies)
+			for _, p := range policies {
+				row[3] = csvSafe(p)
+				row[4] = csvSafe(h.Policies[p])
+				if err := w.writer.Write(row); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	for _, f := range sum.Fixed {
+		row := []string{csvSafe(f.Path), csvSafe(f.Word), "", "", "", csvSafe(escapeBinary(f.Context))}
+		if sum.Stats.Diff != nil {
+			row = append(row, "")
+		}
+		row = append(row, BaselineFixed)
+		for i := 0; i < f.Count; i++ {
+			if err := w.writer.Write(row); err != nil {
+				return err
+			}
+		}
+	}
+	w.writer.Flush()
+	return w.writer.Error()
+}
+
+// csvSafe prefixes s with a quote if it begins with a character that
+// spreadsheet applications would evaluate as a formula.
+func csvSafe(s string) string {
+	if len(s) > 0 {
+		switch s[0] {
+		case '=', '+', '-', '@', '\t', '\r':
+			return "'" + s
+		}
+	}
+	return s
+}
@@ -0,0 +1,84 @@
+package output
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/joelanford/goscan/utils/keywords"
+)
+
+// Hit is a keyword match in a result, along with how it compares to a
+// baseline or to an earlier scan when the summary was checked against one.
+type Hit struct {
+	keywords.Hit `yaml:",inline"`
+
+	// Baseline is BaselineNew or BaselineExisting when the scan was
+	// compared to a baseline.
+	Baseline string `json:"baseline,omitempty" yaml:"baseline,omitempty"`
+
+	// Diff is HitAdded, HitRemoved or HitChanged when the hit is from a
+	// comparison of two summaries. Previous is the hit that a changed hit
+	// was before.
+	Diff     string `json:"diff,omitempty" yaml:"diff,omitempty"`
+	Previous *Hit   `json:"previous,omitempty" yaml:"previous,omitempty"`
+}
+
+// Baseline statuses of hits. BaselineFixed is only used by writers, for
+// the hits of the baseline in ScanSummary.Fixed.
+const (
+	BaselineNew      = "new"
+	BaselineExisting = "existing"
+	BaselineFixed    = "fixed"
+)
+
+// Statuses of hits in comparisons.
+const (
+	HitAdded   = "added"
+	HitRemoved = "removed"
+	HitChanged = "changed"
+)
+
+// NewHits returns the hits of a result for the keyword matches of a file.
+func NewHits(matches []keywords.Hit) []Hit {
+	hits := make([]Hit, len(matches))
+	for i, m := range matches {
+		hits[i] = Hit{Hit: m}
+	}
+	return hits
+}
+
+// Matches returns the keyword matches of hits.
+func Matches(hits []Hit) []keywords.Hit {
+	matches := make([]keywords.Hit, len(hits))
+	for i, h := range hits {
+		matches[i] = h.Hit
+	}
+	return matches
+}
+
+// NormalizedLine returns the line of the hit with runs of whitespace
+// collapsed. Lines in binary files are cut down to the runs of text between
+// control characters that contain the word, so that header fields of
+// archives aren't part of them. It stays the same when the hit moves.
+func (h Hit) NormalizedLine() string {
+	text := h.LineText
+	if text == "" {
+		text = h.Context
+	}
+	runs := strings.FieldsFunc(text, func(r rune) bool {
+		return unicode.IsControl(r) && !unicode.IsSpace(r)
+	})
+	if len(runs) > 1 {
+		word := strings.ToLower(h.Word)
+		var kept []string
+		for _, run := range runs {
+			if strings.Contains(strings.ToLower(run), word) {
+				kept = append(kept, run)
+			}
+		}
+		if len(kept) > 0 {
+			runs = kept
+		}
+	}
+	return strings.Join(strings.Fields(strings.Join(runs, " ")), " ")
+}
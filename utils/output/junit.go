@@ -0,0 +1,232 @@
+package output
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+)
+
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Name     string           `xml:"name,attr"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Errors   int              `xml:"errors,attr"`
+	Time     float64          `xml:"time,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Errors    int             `xml:"errors,attr"`
+	TestCases []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Error     *junitFailure `xml:"error,omitempty"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+type JUnitSummaryWriter struct {
+	writer io.Writer
+}
+
+func NewJUnitSummaryWriter(writer io.Writer) *JUnitSummaryWriter {
+	return &JUnitSummaryWriter{
+		writer: writer,
+	}
+}
+
+// junitFixedSuite is the name of the suite of the hits of a baseline that
+// weren't found.
+const junitFixedSuite = "fixed baseline hits"
+
+// WriteSummary writes one test case per scanned file, failing each file
+// that has hits and marking files with scan errors as errored. Test cases
+// are grouped into suites by top-level archive, the first archive below the
+// scanned file, and files that aren't in one are grouped under the scanned
+// file.
+//
+// When the scan was compared to a baseline, only new hits fail a file.
+// Hits already in the baseline are listed in its output, and the baseline
+// hits that weren't found are passing test cases in a suite of their own.
+// In the summaries of comparisons, removed hits are listed in the output
+// of their file rather than failing it.
+func (w *JUnitSummaryWriter) WriteSummary(sum ScanSummary) error {
+	suites := junitTestSuites{
+		Name: sum.InputFile,
+		Time: sum.Stats.Duration,
+	}
+	archives := resultArchives(sum)
+	suiteIndex := make(map[string]int)
+	for _, r := range sum.Results {
+		name := topLevelArchive(r.File, archives)
+		i, ok := suiteIndex[name]
+		if !ok {
+			i = len(suites.Suites)
+			suiteIndex[name] = i
+			suites.Suites = append(suites.Suites, junitTestSuite{Name: name})
+		}
+
+		tc := junitTestCase{
+			Name:      r.File,
+			ClassName: name,
+		}
+		var failed, passed []Hit
+		for _, h := range r.Hits {
+			if junitFails(sum, h) {
+				failed = append(failed, h)
+			} else {
+				passed = append(passed, h)
+			}
+		}
+		if len(failed) > 0 {
+			tc.Failure = &junitFailure{
+				Message: fmt.Sprintf("%d keyword hit(s) found", len(failed)),
+				Type:    "KeywordHit",
+				Text:    junitHits(failed),
+			}
+			suites.Suites[i].Failures++
+			suites.Failures++
+		}
+		tc.SystemOut = junitHits(passed)
+		if len(r.Errors) > 0 {
+			tc.Error = junitErrorsFailure(r)
+			suites.Suites[i].Errors++
+			suites.Errors++
+		}
+		suites.Suites[i].Tests++
+		suites.Suites[i].TestCases = append(suites.Suites[i].TestCases, tc)
+		suites.Tests++
+	}
+	sort.SliceStable(suites.Suites, func(i, j int) bool {
+		return suites.Suites[i].Name < suites.Suites[j].Name
+	})
+	if len(sum.Fixed) > 0 {
+		fixed := junitTestSuite{Name: junitFixedSuite}
+		for _, f := range sum.Fixed {
+			fixed.TestCases = append(fixed.TestCases, junitTestCase{
+				Name:      f.Path,
+				ClassName: junitFixedSuite,
+				SystemOut: fmt.Sprintf("[%s] %s (%d): %s\n", BaselineFixed, f.Word, f.Count, escapeBinary(f.Context)),
+			})
+			fixed.Tests++
+			suites.Tests++
+		}
+		suites.Suites = append(suites.Suites, fixed)
+	}
+
+	if _, err := io.WriteString(w.writer, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w.writer)
+	enc.Indent("", "  ")
+	if err := enc.Encode(&suites); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w.writer, "\n")
+	return err
+}
+
+// junitFails returns true if h fails the test case of its file. With a
+// baseline, only new hits do, and in a comparison, removed hits don't.
+func junitFails(sum ScanSummary, h Hit) bool {
+	if sum.Stats.Baseline != nil {
+		return h.Baseline == BaselineNew
+	}
+	if sum.Stats.Diff != nil {
+		return h.Diff != HitRemoved
+	}
+	return true
+}
+
+// junitHits lists hits, with their baseline or diff status if they have
+// one.
+func junitHits(hits []Hit) string {
+	var text strings.Builder
+	for _, h := range hits {
+		if h.Diff != "" {
+			fmt.Fprintf(&text, "[%s] ", h.Diff)
+		}
+		if h.Baseline != "" {
+			fmt.Fprintf(&text, "[%s] ", h.Baseline)
+		}
+		fmt.Fprintf(&text, "%s at offset %d: %s\n", h.Word, h.Index, escapeBinary(h.Context))
+		policies := make([]string, 0, len(h.Policies))
+		for p := range h.Policies {
+			policies = append(policies, p)
+		}
+		sort.Strings(policies)
+		for _, p := range policies {
+			fmt.Fprintf(&text, "  %s: %s\n", p, h.Policies[p])
+		}
+	}
+	return text.String()
+}
+
+func junitErrorsFailure(r ScanResult) *junitFailure {
+	var text strings.Builder
+	for _, e := range r.Errors {
+		fmt.Fprintf(&text, "%s\n", e.Error())
+	}
+	return &junitFailure{
+		Message: fmt.Sprintf("%d scan error(s)", len(r.Errors)),
+		Type:    r.Errors[0].Kind,
+		Text:    text.String(),
+	}
+}
+
+// resultArchives returns the files of sum that are archives, including
+// those left out of its results with hits only. Summaries written before
+// results were marked as archives have them found as the results that
+// other results were extracted from.
+func resultArchives(sum ScanSummary) map[string]bool {
+	files := make(map[string]bool, len(sum.Results))
+	archives := make(map[string]bool, len(sum.archives))
+	for file := range sum.archives {
+		archives[file] = true
+	}
+	for _, r := range sum.Results {
+		files[r.File] = true
+		if r.Archive {
+			archives[r.File] = true
+		}
+	}
+	for _, r := range sum.Results {
+		for dir := path.Dir(r.File); dir != "/" && dir != "."; dir = path.Dir(dir) {
+			if files[dir] {
+				archives[dir] = true
+			}
+		}
+	}
+	return archives
+}
+
+// topLevelArchive returns the first archive below the scanned file that
+// file is, or was extracted from, without its leading slash. The first
+// element of a result path is the scanned file, which is returned for
+// files that aren't in such an archive.
+func topLevelArchive(file string, archives map[string]bool) string {
+	file = strings.TrimLeft(file, "/")
+	elems := strings.Split(file, "/")
+	for i := 2; i <= len(elems); i++ {
+		if p := strings.Join(elems[:i], "/"); archives["/"+p] {
+			return p
+		}
+	}
+	return elems[0]
+}
@@ -0,0 +1,273 @@
+package output_test
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/joelanford/goscan/utils/keywords"
+	"github.com/joelanford/goscan/utils/output"
+	"github.com/stretchr/testify/assert"
+)
+
+var summary = output.ScanSummary{
+	InputFile: "bundle.tar.gz",
+	Results: []output.ScanResult{
+		{
+			File: "/bundle.tar.gz/bundle.tar/docs/readme.txt",
+			Hits: []output.Hit{
+				{Hit: keywords.Hit{
+					Word:     "espn",
+					Index:    42,
+					Context:  "watch espn\x00\xff=",
+					Policies: map[string]string{"work": "not work-related", "sports": "sports network"},
+				}},
+			},
+		},
+		{
+			File: "/bundle.tar.gz/bundle.tar/docs/clean.txt",
+			Hits: []output.Hit{},
+		},
+		{
+			File: "/bundle.tar.gz",
+			Hits: []output.Hit{
+				{Hit: keywords.Hit{Word: "=cmd", Index: 7, Context: "=cmd"}},
+			},
+			Errors: []output.ScanError{
+				{Kind: output.ErrorUnarchive, Message: "unar failed"},
+			},
+		},
+	},
+}
+
+func TestCSVSummaryWriter(t *testing.T) {
+	var buf bytes.Buffer
+	err := output.NewCSVSummaryWriter(&buf).WriteSummary(summary)
+	assert.NoError(t, err)
+
+	expected := strings.Join([]string{
+		"file,word,offset,policy,reason,context",
+		`/bundle.tar.gz/bundle.tar/docs/readme.txt,espn,42,sports,sports network,watch espn\x00\xff=`,
+		`/bundle.tar.gz/bundle.tar/docs/readme.txt,espn,42,work,not work-related,watch espn\x00\xff=`,
+		`/bundle.tar.gz,'=cmd,7,,,'=cmd`,
+		"",
+	}, "\n")
+	assert.Equal(t, expected, buf.String())
+}
+
+func TestJUnitSummaryWriter(t *testing.T) {
+	var buf bytes.Buffer
+	err := output.NewJUnitSummaryWriter(&buf).WriteSummary(summary)
+	assert.NoError(t, err)
+
+	out := buf.String()
+	assert.Contains(t, out, `<testsuites name="bundle.tar.gz" tests="3" failures="2" errors="1" time="0">`)
+	assert.Contains(t, out, `<testsuite name="bundle.tar.gz" tests="3" failures="2" errors="1">`)
+	assert.Contains(t, out, `<testcase name="/bundle.tar.gz/bundle.tar/docs/clean.txt" classname="bundle.tar.gz"></testcase>`)
+	assert.Contains(t, out, `<error message="1 scan error(s)" type="unarchive">unarchive: unar failed&#xA;</error>`)
+	assert.Contains(t, out, `<failure message="1 keyword hit(s) found" type="KeywordHit">espn at offset 42: watch espn\x00\xff=`)
+}
+
+func TestJUnitSummaryWriterSuites(t *testing.T) {
+	hit := []output.Hit{{Hit: keywords.Hit{Word: "espn"}}}
+	sum := output.ScanSummary{
+		InputFile: "release.tar",
+		Results: []output.ScanResult{
+			{File: "/release.tar"},
+			{File: "/release.tar/readme.txt", Hits: hit},
+			{File: "/release.tar/lib/a.zip"},
+			{File: "/release.tar/lib/a.zip/a.txt", Hits: hit},
+			{File: "/release.tar/b.tar.gz"},
+			{File: "/release.tar/b.tar.gz/b.tar"},
+			{File: "/release.tar/b.tar.gz/b.tar/docs/b.txt", Hits: hit},
+		},
+	}
+	var buf bytes.Buffer
+	assert.NoError(t, output.NewJUnitSummaryWriter(&buf).WriteSummary(sum))
+
+	//
+	// Files in nested archives are in the suite of the outermost one.
+	//
+	out := buf.String()
+	assert.Equal(t, 3, strings.Count(out, "<testsuite "))
+	assert.Contains(t, out, `<testsuite name="release.tar" tests="2" failures="1" errors="0">`)
+	assert.Contains(t, out, `<testsuite name="release.tar/b.tar.gz" tests="3" failures="1" errors="0">`)
+	assert.Contains(t, out, `<testsuite name="release.tar/lib/a.zip" tests="2" failures="1" errors="0">`)
+	assert.Contains(t, out, `<testcase name="/release.tar/b.tar.gz/b.tar/docs/b.txt" classname="release.tar/b.tar.gz">`)
+}
+
+var baselineSummary = output.ScanSummary{
+	InputFile: "app.zip",
+	Results: []output.ScanResult{
+		{File: "/app.zip/new.txt", Hits: []output.Hit{
+			{Hit: keywords.Hit{Word: "espn", Index: 1, Context: "new espn"}, Baseline: output.BaselineNew},
+			{Hit: keywords.Hit{Word: "espn", Index: 9, Context: "old espn"}, Baseline: output.BaselineExisting},
+		}},
+		{File: "/app.zip/old.txt", Hits: []output.Hit{
+			{Hit: keywords.Hit{Word: "espn", Index: 3, Context: "old espn"}, Baseline: output.BaselineExisting},
+		}},
+	},
+	Stats: output.ScanStats{Baseline: &output.BaselineStats{New: 1, Existing: 2, Fixed: 2}},
+	Fixed: []output.FixedHit{{Word: "espn", Path: "gone.txt", Context: "gone espn", Count: 2}},
+}
+
+func TestCSVSummaryWriterBaseline(t *testing.T) {
+	var buf bytes.Buffer
+	assert.NoError(t, output.NewCSVSummaryWriter(&buf).WriteSummary(baselineSummary))
+
+	expected := strings.Join([]string{
+		"file,word,offset,policy,reason,context,baseline",
+		"/app.zip/new.txt,espn,1,,,new espn,new",
+		"/app.zip/new.txt,espn,9,,,old espn,existing",
+		"/app.zip/old.txt,espn,3,,,old espn,existing",
+		"gone.txt,espn,,,,gone espn,fixed",
+		"gone.txt,espn,,,,gone espn,fixed",
+		"",
+	}, "\n")
+	assert.Equal(t, expected, buf.String())
+}
+
+func TestJUnitSummaryWriterBaseline(t *testing.T) {
+	var buf bytes.Buffer
+	assert.NoError(t, output.NewJUnitSummaryWriter(&buf).WriteSummary(baselineSummary))
+
+	//
+	// Only new hits fail. The others, and the fixed ones, are in the
+	// output of their test cases.
+	//
+	out := buf.String()
+	assert.Contains(t, out, `<testsuites name="app.zip" tests="3" failures="1" errors="0" time="0">`)
+	assert.Contains(t, out, `<failure message="1 keyword hit(s) found" type="KeywordHit">[new] espn at offset 1: new espn&#xA;</failure>`)
+	assert.Contains(t, out, `<testcase name="/app.zip/old.txt" classname="app.zip">
+      <system-out>[existing] espn at offset 3: old espn&#xA;</system-out>`)
+	assert.Contains(t, out, `<testsuite name="fixed baseline hits" tests="1" failures="0" errors="0">`)
+	assert.Contains(t, out, `<system-out>[fixed] espn (2): gone espn&#xA;</system-out>`)
+}
+
+func TestJUnitSummaryWriterDiff(t *testing.T) {
+	sum := output.ScanSummary{
+		InputFile: "release-2.tar",
+		Results: []output.ScanResult{
+			{File: "/release-2.tar/a.txt", Hits: []output.Hit{
+				{Hit: keywords.Hit{Word: "espn", Index: 1, Context: "gone espn"}, Diff: output.HitRemoved},
+			}},
+			{File: "/release-2.tar/b.txt", Hits: []output.Hit{
+				{Hit: keywords.Hit{Word: "espn", Index: 2, Context: "new espn"}, Diff: output.HitAdded},
+				{Hit: keywords.Hit{Word: "espn", Index: 5, Context: "old espn"}, Diff: output.HitRemoved},
+			}},
+		},
+		Stats: output.ScanStats{Diff: &output.DiffStats{HitsAdded: 1, HitsRemoved: 2}},
+	}
+	var buf bytes.Buffer
+	assert.NoError(t, output.NewJUnitSummaryWriter(&buf).WriteSummary(sum))
+
+	//
+	// A file whose hits were all removed passes.
+	//
+	out := buf.String()
+	assert.Contains(t, out, `<testsuites name="release-2.tar" tests="2" failures="1" errors="0" time="0">`)
+	assert.Contains(t, out, `<testcase name="/release-2.tar/a.txt" classname="release-2.tar">
+      <system-out>[removed] espn at offset 1: gone espn&#xA;</system-out>`)
+	assert.Contains(t, out, `<failure message="1 keyword hit(s) found" type="KeywordHit">[added] espn at offset 2: new espn&#xA;</failure>
+      <system-out>[removed] espn at offset 5: old espn&#xA;</system-out>`)
+}
+
+func TestJUnitSummaryWriterHitsOnly(t *testing.T) {
+	hit := []output.Hit{{Hit: keywords.Hit{Word: "espn"}}}
+	sum := output.ScanSummary{InputFile: "release.tar"}
+	for _, sr := range []output.ScanResult{
+		{File: "/release.tar", Archive: true},
+		{File: "/release.tar/lib/a.zip/a.txt", Hits: hit},
+		{File: "/release.tar/lib/a.zip", Archive: true},
+		{File: "/release.tar/lib/b.txt", Hits: hit},
+	} {
+		sum.Add(sr, true)
+	}
+	assert.Len(t, sum.Results, 2)
+
+	//
+	// Archives without hits are left out, but still group their contents.
+	//
+	var buf bytes.Buffer
+	assert.NoError(t, output.NewJUnitSummaryWriter(&buf).WriteSummary(sum))
+	out := buf.String()
+	assert.Contains(t, out, `<testcase name="/release.tar/lib/a.zip/a.txt" classname="release.tar/lib/a.zip">`)
+	assert.Contains(t, out, `<testcase name="/release.tar/lib/b.txt" classname="release.tar">`)
+}
+
+func TestTemplateSummaryWriter(t *testing.T) {
+	tmpl := `{{range groupByPolicy .Results}}{{.Policy}}:{{range .Hits}} {{.File}}@{{.Hit.Index}}={{.Hit.Context | escape | truncate 12}}{{end}}
+{{end}}{{range bySeverity .Results}}{{.File}} {{len .Hits}}
+{{end}}`
+	w, err := output.NewTemplateSummaryWriter(&bytes.Buffer{}, "{{")
+	assert.Error(t, err)
+
+	var buf bytes.Buffer
+	w, err = output.NewTemplateSummaryWriter(&buf, tmpl)
+	assert.NoError(t, err)
+	assert.NoError(t, w.WriteSummary(summary))
+
+	expected := strings.Join([]string{
+		`: /bundle.tar.gz@7==cmd`,
+		`sports: /bundle.tar.gz/bundle.tar/docs/readme.txt@42=watch esp...`,
+		`work: /bundle.tar.gz/bundle.tar/docs/readme.txt@42=watch esp...`,
+		`/bundle.tar.gz/bundle.tar/docs/readme.txt 1`,
+		`/bundle.tar.gz 1`,
+		`/bundle.tar.gz/bundle.tar/docs/clean.txt 0`,
+		"",
+	}, "\n")
+	assert.Equal(t, expected, buf.String())
+}
+
+func TestTemplateTruncate(t *testing.T) {
+	//
+	// "héllo wörld" is 13 bytes. Cuts that would split a character back
+	// off to the start of it.
+	//
+	tests := map[int]string{
+		-1: "héllo wörld",
+		13: "héllo wörld",
+		12: "héllo w...",
+		6:  "hé...",
+		5:  "h...",
+		3:  "hé",
+		2:  "h",
+		0:  "",
+	}
+	for n, expected := range tests {
+		var buf bytes.Buffer
+		w, err := output.NewTemplateSummaryWriter(&buf, fmt.Sprintf("{{.InputFile | truncate %d}}", n))
+		assert.NoError(t, err)
+		assert.NoError(t, w.WriteSummary(output.ScanSummary{InputFile: "héllo wörld"}))
+		assert.Equal(t, expected, buf.String(), "truncate %d", n)
+		assert.True(t, utf8.Valid(buf.Bytes()), "truncate %d", n)
+	}
+}
+
+func TestReadSummary(t *testing.T) {
+	var buf bytes.Buffer
+	assert.NoError(t, output.NewJSONSummaryWriter(&buf, "", "  ").WriteSummary(summary))
+	sum, err := output.ReadSummary(&buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "bundle.tar.gz", sum.InputFile)
+	assert.Len(t, sum.Results, 3)
+
+	//
+	// NDJSON streams have a line per result, then a summary line without
+	// results.
+	//
+	ndjson := `{"file":"/a.tar/x","hits":[{"word":"espn","index":1}]}
+{"file":"/a.tar/y"}
+{"inputFile":"a.tar","stats":{"filesScanned":2,"filesHit":1,"totalHits":1}}
+`
+	sum, err = output.ReadSummary(strings.NewReader(ndjson))
+	assert.NoError(t, err)
+	assert.Equal(t, "a.tar", sum.InputFile)
+	assert.Len(t, sum.Results, 2)
+	assert.Equal(t, 2, sum.Stats.FilesScanned)
+
+	_, err = output.ReadSummary(strings.NewReader(`{"foo":1}`))
+	assert.Error(t, err)
+}
@@ -0,0 +1,83 @@
+package output
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ReadSummary reads what the JSON summary writers and the scanning service
+// write: a JSON summary, JSON lines of summaries, such as those of a watch
+// sink, or an NDJSON stream of results followed by a summary line. The
+// results of several summaries are merged into one, and their stats are
+// added up.
+func ReadSummary(r io.Reader) (ScanSummary, error) {
+	var sum ScanSummary
+	var names []string
+	sum.Results = make([]ScanResult, 0)
+	dec := json.NewDecoder(r)
+	for {
+		var raw json.RawMessage
+		err := dec.Decode(&raw)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return sum, errors.Wrap(err, "error parsing summary")
+		}
+
+		//
+		// Results have a file, and summaries and the summary lines of
+		// NDJSON streams have stats.
+		//
+		var probe struct {
+			File  *string          `json:"file"`
+			Stats *json.RawMessage `json:"stats"`
+		}
+		if err := json.Unmarshal(raw, &probe); err != nil {
+			return sum, errors.Wrap(err, "error parsing summary")
+		}
+		switch {
+		case probe.File != nil:
+			var sr ScanResult
+			if err := json.Unmarshal(raw, &sr); err != nil {
+				return sum, errors.Wrap(err, "error parsing result")
+			}
+			sum.Results = append(sum.Results, sr)
+		case probe.Stats != nil:
+			var s ScanSummary
+			if err := json.Unmarshal(raw, &s); err != nil {
+				return sum, errors.Wrap(err, "error parsing summary")
+			}
+			if s.InputFile != "" {
+				names = append(names, s.InputFile)
+			}
+			sum.Results = append(sum.Results, s.Results...)
+			sum.Stats.add(s.Stats)
+			sum.Incomplete = sum.Incomplete || s.Incomplete
+			if sum.KeywordsFingerprint == "" {
+				sum.KeywordsFingerprint = s.KeywordsFingerprint
+			}
+		default:
+			return sum, errors.New("error parsing summary: neither a summary nor a result")
+		}
+	}
+	sum.InputFile = strings.Join(names, ", ")
+	return sum, nil
+}
+
+// add adds the counts of o to s.
+func (s *ScanStats) add(o ScanStats) {
+	s.FilesScanned += o.FilesScanned
+	s.FilesHit += o.FilesHit
+	s.TotalHits += o.TotalHits
+	s.FilesErrored += o.FilesErrored
+	s.TotalErrors += o.TotalErrors
+	s.FilesIncomplete += o.FilesIncomplete
+	s.FilesDuplicate += o.FilesDuplicate
+	s.BytesSaved += o.BytesSaved
+	s.FilesCached += o.FilesCached
+	s.Duration += o.Duration
+}
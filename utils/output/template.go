@@ -0,0 +1,160 @@
+package output
+
+import (
+	"encoding/hex"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// TemplateFuncs are the helper functions available to user-supplied
+// output templates, in addition to the text/template builtins.
+var TemplateFuncs = template.FuncMap{
+	"hex":           hexString,
+	"escape":        escapeBinary,
+	"truncate":      truncate,
+	"join":          strings.Join,
+	"upper":         strings.ToUpper,
+	"lower":         strings.ToLower,
+	"groupByPolicy": groupByPolicy,
+	"bySeverity":    bySeverity,
+}
+
+// PolicyGroup is a set of hits that violate the same policy.
+type PolicyGroup struct {
+	Policy string
+	Hits   []PolicyHit
+}
+
+// PolicyHit is a hit within a PolicyGroup, along with the file it was
+// found in and the reason given for the policy.
+type PolicyHit struct {
+	File   string
+	Reason string
+	Hit    Hit
+}
+
+type TemplateSummaryWriter struct {
+	writer   io.Writer
+	template *template.Template
+}
+
+func NewTemplateSummaryWriter(writer io.Writer, text string) (*TemplateSummaryWriter, error) {
+	t, err := template.New("summary").Funcs(TemplateFuncs).Parse(text)
+	if err != nil {
+		return nil, errors.Wrap(err, "error parsing output template")
+	}
+	return &TemplateSummaryWriter{
+		writer:   writer,
+		template: t,
+	}, nil
+}
+
+func NewTemplateFileSummaryWriter(writer io.Writer, templateFile string) (*TemplateSummaryWriter, error) {
+	t, err := template.New(filepath.Base(templateFile)).Funcs(TemplateFuncs).ParseFiles(templateFile)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error parsing output template %s", templateFile)
+	}
+	return &TemplateSummaryWriter{
+		writer:   writer,
+		template: t,
+	}, nil
+}
+
+func (w *TemplateSummaryWriter) WriteSummary(sum ScanSummary) error {
+	return w.template.Execute(w.writer, sum)
+}
+
+func hexString(s string) string {
+	return hex.EncodeToString([]byte(s))
+}
+
+// truncate shortens s to at most n bytes, appending "..." if anything was
+// removed. It cuts on a rune boundary, so that it never splits a
+// multi-byte character.
+func truncate(n int, s string) string {
+	if n < 0 || len(s) <= n {
+		return s
+	}
+	suffix := "..."
+	if n <= 3 {
+		suffix = ""
+	} else {
+		n -= 3
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n] + suffix
+}
+
+// groupByPolicy regroups the hits in results by the policies they violate,
+// sorted by policy name. Hits without policies are grouped under "".
+func groupByPolicy(results []ScanResult) []PolicyGroup {
+	groups := make(map[string]*PolicyGroup)
+	for _, r := range results {
+		for _, h := range r.Hits {
+			if len(h.Policies) == 0 {
+				addPolicyHit(groups, "", PolicyHit{File: r.File, Hit: h})
+			}
+			for p, reason := range h.Policies {
+				addPolicyHit(groups, p, PolicyHit{File: r.File, Reason: reason, Hit: h})
+			}
+		}
+	}
+
+	sorted := make([]PolicyGroup, 0, len(groups))
+	for _, g := range groups {
+		sort.SliceStable(g.Hits, func(i, j int) bool {
+			if g.Hits[i].File != g.Hits[j].File {
+				return g.Hits[i].File < g.Hits[j].File
+			}
+			return g.Hits[i].Hit.Index < g.Hits[j].Hit.Index
+		})
+		sorted = append(sorted, *g)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Policy < sorted[j].Policy
+	})
+	return sorted
+}
+
+func addPolicyHit(groups map[string]*PolicyGroup, policy string, hit PolicyHit) {
+	g, ok := groups[policy]
+	if !ok {
+		g = &PolicyGroup{Policy: policy}
+		groups[policy] = g
+	}
+	g.Hits = append(g.Hits, hit)
+}
+
+// bySeverity returns a copy of results ordered from most to least severe.
+// A result is more severe if it violates more distinct policies, and then
+// if it has more hits.
+func bySeverity(results []ScanResult) []ScanResult {
+	sorted := make([]ScanResult, len(results))
+	copy(sorted, results)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		pi, pj := countPolicies(sorted[i]), countPolicies(sorted[j])
+		if pi != pj {
+			return pi > pj
+		}
+		return len(sorted[i].Hits) > len(sorted[j].Hits)
+	})
+	return sorted
+}
+
+func countPolicies(r ScanResult) int {
+	policies := make(map[string]bool)
+	for _, h := range r.Hits {
+		for p := range h.Policies {
+			policies[p] = true
+		}
+	}
+	return len(policies)
+}
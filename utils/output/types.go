@@ -1,23 +1,194 @@
 package output
 
-import "github.com/joelanford/goscan/utils/keywords"
+import (
+	"time"
+
+	"github.com/joelanford/goscan/utils/metadata"
+)
 
 type ScanSummary struct {
 	InputFile string       `json:"inputFile" yaml:"inputFile"`
 	Results   []ScanResult `json:"results" yaml:"results"`
 	Stats     ScanStats    `json:"stats" yaml:"stats"`
+
+	// Incomplete is true if any file could not be fully scanned because
+	// the scratch space ran out or unarchiving timed out.
+	Incomplete bool `json:"incomplete,omitempty" yaml:"incomplete,omitempty"`
+
+	// KeywordsFingerprint is the fingerprint of the keywords that the
+	// files were scanned for.
+	KeywordsFingerprint string `json:"keywordsFingerprint,omitempty" yaml:"keywordsFingerprint,omitempty"`
+
+	// Fixed is the hits of the baseline the scan was compared to that
+	// weren't found.
+	Fixed []FixedHit `json:"fixed,omitempty" yaml:"fixed,omitempty"`
+
+	// PreviousInputFile is the input file of the summary that a
+	// comparison compared InputFile's to.
+	PreviousInputFile string `json:"previousInputFile,omitempty" yaml:"previousInputFile,omitempty"`
+
+	//
+	// archives is the archives that Add left out of the results with
+	// hitsOnly, so that their contents can still be grouped by them.
+	//
+	archives map[string]bool
+}
+
+// FixedHit is a hit of a baseline that wasn't found again. Count is the
+// number of such hits with the same word, path and context.
+type FixedHit struct {
+	Word    string `json:"word" yaml:"word"`
+	Path    string `json:"path" yaml:"path"`
+	Context string `json:"context" yaml:"context"`
+	Count   int    `json:"count" yaml:"count"`
+}
+
+// Add counts sr in the stats of the summary, and adds it to its results.
+// With hitsOnly, results without hits or errors are only counted.
+func (s *ScanSummary) Add(sr ScanResult, hitsOnly bool) {
+	s.Stats.FilesScanned++
+	if len(sr.Hits) > 0 {
+		s.Stats.FilesHit++
+		s.Stats.TotalHits += len(sr.Hits)
+	}
+	if len(sr.Errors) > 0 {
+		s.Stats.FilesErrored++
+		s.Stats.TotalErrors += len(sr.Errors)
+	}
+	if sr.Duplicate != nil {
+		s.Stats.FilesDuplicate++
+		s.Stats.BytesSaved += sr.Duplicate.Size
+	}
+	if sr.Cached {
+		s.Stats.FilesCached++
+	}
+	if sr.Incomplete {
+		s.Stats.FilesIncomplete++
+		s.Incomplete = true
+	}
+	if !hitsOnly || len(sr.Hits) > 0 || len(sr.Errors) > 0 {
+		s.Results = append(s.Results, sr)
+	} else if sr.Archive {
+		if s.archives == nil {
+			s.archives = make(map[string]bool)
+		}
+		s.archives[sr.File] = true
+	}
 }
 
 type ScanResult struct {
-	File string         `json:"file" yaml:"file"`
-	Hits []keywords.Hit `json:"hits" yaml:"hits`
+	File     string             `json:"file" yaml:"file"`
+	Hits     []Hit              `json:"hits" yaml:"hits"`
+	Errors   []ScanError        `json:"errors,omitempty" yaml:"errors,omitempty"`
+	Metadata *metadata.Metadata `json:"metadata,omitempty" yaml:"metadata,omitempty"`
+
+	// Incomplete is true if the contents of this file were not all scanned.
+	Incomplete bool `json:"incomplete,omitempty" yaml:"incomplete,omitempty"`
+
+	// Duplicate is set if the file is identical to one that was already
+	// scanned. Its hits and errors are those of that file.
+	Duplicate *Duplicate `json:"duplicate,omitempty" yaml:"duplicate,omitempty"`
+
+	// Cached is true if the result was taken from the scan cache.
+	Cached bool `json:"cached,omitempty" yaml:"cached,omitempty"`
+
+	// Archive is true if the file was unarchived. The results of its
+	// contents have its path as a prefix.
+	Archive bool `json:"archive,omitempty" yaml:"archive,omitempty"`
+
+	// Git is where the file was found in a git repository's history.
+	Git *GitOrigin `json:"git,omitempty" yaml:"git,omitempty"`
+
+	// Diff is how the file changed, in a comparison of two summaries.
+	Diff *FileDiff `json:"diff,omitempty" yaml:"diff,omitempty"`
+}
+
+// FileDiff is how a file changed between two summaries. Previous is the
+// path it had in the first one, if it was renamed or removed.
+type FileDiff struct {
+	Status   string `json:"status" yaml:"status"`
+	Previous string `json:"previous,omitempty" yaml:"previous,omitempty"`
+}
+
+// Statuses of files in comparisons.
+const (
+	FileAdded    = "added"
+	FileRemoved  = "removed"
+	FileRenamed  = "renamed"
+	FileModified = "modified"
+)
+
+// GitOrigin is the blob a file was read from, and the first commit and
+// path it was seen at, with the author and date of that commit.
+type GitOrigin struct {
+	Blob   string    `json:"blob" yaml:"blob"`
+	Commit string    `json:"commit" yaml:"commit"`
+	Path   string    `json:"path" yaml:"path"`
+	Author string    `json:"author" yaml:"author"`
+	Date   time.Time `json:"date" yaml:"date"`
+}
+
+// Duplicate refers to the file a duplicate is identical to. Size is the
+// number of bytes that weren't scanned or unarchived again.
+type Duplicate struct {
+	Of   string `json:"of" yaml:"of"`
+	Size int64  `json:"size" yaml:"size"`
+}
+
+// Kinds of errors that can be recorded for a file.
+const (
+	ErrorOpen      = "open"
+	ErrorRead      = "read"
+	ErrorUnarchive = "unarchive"
+	ErrorTimeout   = "timeout"
+	ErrorLimit     = "limit"
+)
+
+// ScanError is an error that affected the scan of a single file. A file
+// with errors may have been scanned partially or not at all.
+type ScanError struct {
+	Kind    string `json:"kind" yaml:"kind"`
+	Message string `json:"message" yaml:"message"`
+}
+
+func (e ScanError) Error() string {
+	return e.Kind + ": " + e.Message
 }
 
 type ScanStats struct {
-	FilesScanned int     `json:"filesScanned" yaml:"filesScanned"`
-	FilesHit     int     `json:"filesHit" yaml:"filesHit"`
-	TotalHits    int     `json:"totalHits" yaml:"totalHits"`
-	Duration     float64 `json:"duration" yaml:"duration"`
+	FilesScanned    int     `json:"filesScanned" yaml:"filesScanned"`
+	FilesHit        int     `json:"filesHit" yaml:"filesHit"`
+	TotalHits       int     `json:"totalHits" yaml:"totalHits"`
+	FilesErrored    int     `json:"filesErrored" yaml:"filesErrored"`
+	TotalErrors     int     `json:"totalErrors" yaml:"totalErrors"`
+	FilesIncomplete int     `json:"filesIncomplete" yaml:"filesIncomplete"`
+	FilesDuplicate  int     `json:"filesDuplicate" yaml:"filesDuplicate"`
+	BytesSaved      int64   `json:"bytesSaved" yaml:"bytesSaved"`
+	FilesCached     int     `json:"filesCached" yaml:"filesCached"`
+	Duration        float64 `json:"duration" yaml:"duration"`
+
+	// Baseline counts the hits that are new, already in the baseline, and
+	// in the baseline but not found, when the scan was compared to one.
+	Baseline *BaselineStats `json:"baseline,omitempty" yaml:"baseline,omitempty"`
+
+	// Diff counts the changes found by a comparison of two summaries.
+	Diff *DiffStats `json:"diff,omitempty" yaml:"diff,omitempty"`
+}
+
+type BaselineStats struct {
+	New      int `json:"new" yaml:"new"`
+	Existing int `json:"existing" yaml:"existing"`
+	Fixed    int `json:"fixed" yaml:"fixed"`
+}
+
+type DiffStats struct {
+	HitsAdded     int `json:"hitsAdded" yaml:"hitsAdded"`
+	HitsRemoved   int `json:"hitsRemoved" yaml:"hitsRemoved"`
+	HitsChanged   int `json:"hitsChanged" yaml:"hitsChanged"`
+	HitsUnchanged int `json:"hitsUnchanged" yaml:"hitsUnchanged"`
+	FilesAdded    int `json:"filesAdded" yaml:"filesAdded"`
+	FilesRemoved  int `json:"filesRemoved" yaml:"filesRemoved"`
+	FilesRenamed  int `json:"filesRenamed" yaml:"filesRenamed"`
 }
 
 type SummaryWriter interface {
@@ -0,0 +1,220 @@
+package scanner
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/joelanford/goscan/utils/archive"
+	"github.com/joelanford/goscan/utils/cache"
+	"github.com/joelanford/goscan/utils/keywords"
+	"github.com/joelanford/goscan/utils/metadata"
+	"github.com/joelanford/goscan/utils/output"
+	"github.com/joelanford/goscan/utils/scratch"
+	"github.com/pkg/errors"
+)
+
+// cacheKeys builds the cache keys of a scan from the hashes of files.
+type cacheKeys struct {
+	keywords string
+	options  string
+}
+
+func (s *Scanner) cacheKeys(kw *keywords.Keywords) cacheKeys {
+	h := sha256.New()
+	fmt.Fprintf(h, "context=%d metadata=%t legacyhashes=%t native=%t", s.hitContext, s.metadata, s.legacyHashes, s.nativeUnarchive)
+	return cacheKeys{
+		keywords: kw.Fingerprint(),
+		options:  hex.EncodeToString(h.Sum(nil)),
+	}
+}
+
+func (k cacheKeys) key(sum string, archive bool) cache.Key {
+	return cache.Key{
+		SHA256:   sum,
+		Keywords: k.keywords,
+		Options:  k.options,
+		Archive:  archive,
+	}
+}
+
+// archiveCache tells the unarchiver which archives are cached.
+type archiveCache struct {
+	cache *cache.Cache
+	keys  cacheKeys
+}
+
+func (a archiveCache) Contains(sum string) bool {
+	_, ok := a.cache.Get(a.keys.key(sum, true))
+	return ok
+}
+
+// cacheEntry returns the cached results of the file of ur, which has the
+// hash sum, or nil if there are none. Archives are only looked up by the
+// unarchiver, which doesn't unarchive them if they are cached.
+func (s *Scanner) cacheEntry(keys cacheKeys, ur archive.UnarchiveResult, sum string) *cache.Entry {
+	if s.cache == nil || sum == "" {
+		return nil
+	}
+	if ur.SHA256 != "" && !ur.Cached {
+		return nil
+	}
+	e, ok := s.cache.Get(keys.key(sum, ur.Cached))
+	if !ok || len(e.Results) == 0 {
+		return nil
+	}
+	return e
+}
+
+// cachedResults returns the result of the file of ur from its cache entry,
+// along with the results of its contents if it is an archive.
+func (s *Scanner) cachedResults(fsys scratch.FS, ifile string, ur archive.UnarchiveResult, e *cache.Entry) (output.ScanResult, []output.ScanResult) {
+	sr := output.ScanResult{
+		File:    resultPath(ifile, ur.File),
+		Hits:    output.NewHits(e.Results[0].Hits),
+		Cached:  true,
+		Archive: ur.Cached,
+	}
+
+	//
+	// The file's own metadata includes its mtime and mode, which aren't
+	// part of its contents, so it is read again.
+	//
+	if s.metadata {
+		if md, err := s.readMetadata(fsys, ur.File); err == nil {
+			sr.Metadata = md
+		}
+	}
+
+	var contents []output.ScanResult
+	for _, r := range e.Results[1:] {
+		contents = append(contents, output.ScanResult{
+			File:     sr.File + r.Path,
+			Hits:     output.NewHits(r.Hits),
+			Metadata: r.Metadata,
+			Cached:   true,
+			Archive:  r.Archive,
+		})
+	}
+	return sr, contents
+}
+
+func (s *Scanner) readMetadata(fsys scratch.FS, file string) (*metadata.Metadata, error) {
+	f, err := fsys.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	mf, ok := f.(metadata.File)
+	if !ok {
+		return nil, errors.Errorf("%s is not seekable", file)
+	}
+	r := metadata.NewReader(mf, s.legacyHashes)
+	if _, err := io.Copy(ioutil.Discard, r); err != nil {
+		return nil, err
+	}
+	return r.Metadata()
+}
+
+// cacheRecorder collects the results of a scan, so that they can be added
+// to the cache once it is done. Its methods do nothing on a nil recorder.
+type cacheRecorder struct {
+	mu      sync.Mutex
+	results []output.ScanResult
+	keys    map[string]cache.Key
+}
+
+// add records a result. key is set if the result was scanned rather than
+// taken from the cache or from a duplicate, so that it should be cached.
+func (r *cacheRecorder) add(sr output.ScanResult, key *cache.Key) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.results = append(r.results, sr)
+	if key != nil {
+		if r.keys == nil {
+			r.keys = make(map[string]cache.Key)
+		}
+		r.keys[sr.File] = *key
+	}
+}
+
+// storeResults adds the results of a scan that finished without stopping
+// early to the cache. Files and archives with errors aren't cached, since
+// their results might be different next time.
+func (s *Scanner) storeResults(r *cacheRecorder) {
+	if r == nil {
+		return
+	}
+
+	//
+	// Results are sorted by path element, so that the contents of an
+	// archive come right after it, before files such as a.zip.sha256
+	// that would sort between a.zip and a.zip/ by name.
+	//
+	sort.Slice(r.results, func(i, j int) bool {
+		return pathLess(r.results[i].File, r.results[j].File)
+	})
+	files := make([]string, len(r.results))
+	for i, sr := range r.results {
+		files[i] = sr.File
+	}
+
+	for i, sr := range r.results {
+		key, ok := r.keys[sr.File]
+		if !ok || !cacheable(sr) {
+			continue
+		}
+		e := &cache.Entry{
+			Key:     key,
+			Results: []cache.Result{{Hits: output.Matches(sr.Hits)}},
+		}
+
+		//
+		// The contents of an archive are the results after it with its
+		// path as a prefix.
+		//
+		if key.Archive {
+			prefix := sr.File + "/"
+			for j := i + 1; j < len(files) && strings.HasPrefix(files[j], prefix); j++ {
+				c := r.results[j]
+				if !cacheable(c) {
+					e = nil
+					break
+				}
+				e.Results = append(e.Results, cache.Result{
+					Path:     strings.TrimPrefix(c.File, sr.File),
+					Hits:     output.Matches(c.Hits),
+					Metadata: c.Metadata,
+					Archive:  c.Archive,
+				})
+			}
+		}
+		if e != nil {
+			s.cache.Put(e)
+		}
+	}
+}
+
+// pathLess reports whether the path a sorts before b, comparing them one
+// element at a time.
+func pathLess(a, b string) bool {
+	ae, be := strings.Split(a, "/"), strings.Split(b, "/")
+	for i := 0; i < len(ae) && i < len(be); i++ {
+		if ae[i] != be[i] {
+			return ae[i] < be[i]
+		}
+	}
+	return len(ae) < len(be)
+}
+
+func cacheable(sr output.ScanResult) bool {
+	return len(sr.Errors) == 0 && !sr.Incomplete
+}
@@ -0,0 +1,82 @@
+package scanner
+
+import (
+	"sync"
+
+	"github.com/joelanford/goscan/utils/archive"
+	"github.com/joelanford/goscan/utils/output"
+	"github.com/joelanford/goscan/utils/scratch"
+)
+
+// dedup holds the results of the files scanned so far, by content hash.
+type dedup struct {
+	files *archive.Dedup
+
+	mu      sync.Mutex
+	entries map[dedupKey]*dedupEntry
+}
+
+type dedupKey struct {
+	sum     string
+	archive bool
+}
+
+// hashFile returns the SHA-256 hash of the file of ur, or an empty string if
+// it can't be read. Archives were already hashed by the unarchiver.
+func hashFile(fsys scratch.FS, ur archive.UnarchiveResult) string {
+	if ur.SHA256 != "" {
+		return ur.SHA256
+	}
+	if ur.Error != nil {
+		if _, ok := ur.Error.(*archive.UnarchiveError); !ok {
+			return ""
+		}
+	}
+	sum, err := archive.HashFile(fsys, ur.File)
+	if err != nil {
+		return ""
+	}
+	return sum
+}
+
+// dedupEntry is the result of the first file with a given hash, which is
+// available once done is closed.
+type dedupEntry struct {
+	done   chan struct{}
+	result output.ScanResult
+}
+
+func newDedup() *dedup {
+	return &dedup{
+		files:   archive.NewDedup(),
+		entries: make(map[dedupKey]*dedupEntry),
+	}
+}
+
+// lookup returns the entry for the contents of the file of ur, which have
+// the hash sum, and whether the file is a duplicate. Files that couldn't be
+// hashed aren't deduplicated, and have no entry.
+func (d *dedup) lookup(ur archive.UnarchiveResult, sum string) (*dedupEntry, bool) {
+	if sum == "" {
+		return nil, false
+	}
+
+	//
+	// Archives were already claimed by the unarchiver.
+	//
+	key := dedupKey{sum: sum, archive: true}
+	canonical := ur.DuplicateOf
+	if ur.SHA256 == "" {
+		key.archive = false
+		canonical = d.files.Claim(sum, ur.File, false)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	e, ok := d.entries[key]
+	if !ok {
+		e = &dedupEntry{done: make(chan struct{})}
+		d.entries[key] = e
+	}
+	return e, canonical != "" && canonical != ur.File
+}
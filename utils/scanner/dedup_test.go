@@ -0,0 +1,40 @@
+package scanner
+
+import (
+	"testing"
+
+	"github.com/joelanford/goscan/utils/archive"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDedupLookup(t *testing.T) {
+	d := newDedup()
+
+	//
+	// The unarchiver may report a duplicate archive before the archive it
+	// duplicates. Both share an entry, and only the duplicate says so.
+	//
+	dup, dupOf := d.lookup(archive.UnarchiveResult{File: "b.zip", SHA256: "1", DuplicateOf: "a.zip"}, "1")
+	orig, origDupOf := d.lookup(archive.UnarchiveResult{File: "a.zip", SHA256: "1"}, "1")
+	assert.True(t, dupOf)
+	assert.False(t, origDupOf)
+	assert.True(t, dup == orig)
+
+	//
+	// Other files are claimed in the order they are looked up, and are
+	// never duplicates of archives with the same contents.
+	//
+	first, firstDupOf := d.lookup(archive.UnarchiveResult{File: "a.txt"}, "1")
+	second, secondDupOf := d.lookup(archive.UnarchiveResult{File: "b.txt"}, "1")
+	assert.False(t, firstDupOf)
+	assert.True(t, secondDupOf)
+	assert.True(t, first == second)
+	assert.True(t, first != orig)
+
+	//
+	// Files that couldn't be hashed aren't deduplicated.
+	//
+	e, isDup := d.lookup(archive.UnarchiveResult{File: "c.txt"}, "")
+	assert.Nil(t, e)
+	assert.False(t, isDup)
+}
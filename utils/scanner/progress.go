@@ -0,0 +1,188 @@
+package scanner
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/joelanford/goscan/utils/archive"
+	"github.com/pkg/errors"
+)
+
+// Progress is a snapshot of a running scan.
+type Progress struct {
+	// FilesFound and BytesFound count the files found so far, including
+	// the input file and the contents of unarchived archives.
+	FilesFound int64 `json:"filesFound"`
+	BytesFound int64 `json:"bytesFound"`
+
+	// ArchivesUnarchived counts the archives that have been unarchived,
+	// and BytesUnarchived the size of their contents. Unarchiving counts
+	// the archives being unarchived right now.
+	ArchivesUnarchived int64 `json:"archivesUnarchived"`
+	BytesUnarchived    int64 `json:"bytesUnarchived"`
+	Unarchiving        int   `json:"unarchiving"`
+
+	// FilesScanned counts the results produced so far, and BytesScanned
+	// the size of the files they are for.
+	FilesScanned int64 `json:"filesScanned"`
+	BytesScanned int64 `json:"bytesScanned"`
+
+	// Depth is the archive nesting depth of the most recently found file,
+	// and MaxDepth the deepest nesting found so far.
+	Depth    int `json:"depth"`
+	MaxDepth int `json:"maxDepth"`
+
+	// ActiveWorkers counts the workers that are scanning a file.
+	ActiveWorkers int `json:"activeWorkers"`
+	Workers       int `json:"workers"`
+
+	Elapsed time.Duration `json:"elapsed"`
+
+	// ETA estimates the time left from the rate at which bytes are
+	// scanned. Archives that haven't been unarchived yet aren't accounted
+	// for, so it is a lower bound. It is zero until there is an estimate.
+	ETA time.Duration `json:"eta"`
+
+	// Done is set on the last snapshot of a scan.
+	Done bool `json:"done"`
+}
+
+// OnProgress makes the scanner call fn with a snapshot of its progress
+// every interval while it scans, and once more when it is done. fn is
+// called from a single goroutine.
+func OnProgress(interval time.Duration, fn func(Progress)) Option {
+	return func(s *Scanner) error {
+		if interval <= 0 {
+			return errors.New("error: progress interval must be > 0")
+		}
+		s.progressInterval = interval
+		s.progressFunc = fn
+		return nil
+	}
+}
+
+// progressTracker counts the progress of a scan. Its methods do nothing on
+// a nil tracker.
+type progressTracker struct {
+	start   time.Time
+	now     func() time.Time
+	fn      func(Progress)
+	done    chan struct{}
+	stopped chan struct{}
+
+	mu sync.Mutex
+	p  Progress
+}
+
+// newProgressTracker returns a tracker that calls fn with a snapshot every
+// interval until it is stopped.
+func newProgressTracker(workers int, interval time.Duration, fn func(Progress)) *progressTracker {
+	t := &progressTracker{
+		start:   time.Now(),
+		now:     time.Now,
+		fn:      fn,
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+		p:       Progress{Workers: workers},
+	}
+	go func() {
+		defer close(t.stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-t.done:
+				return
+			case <-ticker.C:
+				fn(t.snapshot())
+			}
+		}
+	}()
+	return t
+}
+
+// stop stops the periodic snapshots, and calls fn with the last one.
+func (t *progressTracker) stop() {
+	if t == nil {
+		return
+	}
+	close(t.done)
+	<-t.stopped
+	p := t.snapshot()
+	p.Done = true
+	t.fn(p)
+}
+
+func (t *progressTracker) snapshot() Progress {
+	t.mu.Lock()
+	p := t.p
+	t.mu.Unlock()
+
+	p.Elapsed = t.now().Sub(t.start)
+	if p.BytesScanned > 0 && p.BytesFound > p.BytesScanned {
+		rate := float64(p.BytesScanned) / float64(p.Elapsed)
+		p.ETA = time.Duration(float64(p.BytesFound-p.BytesScanned) / rate)
+	}
+	return p
+}
+
+func (t *progressTracker) Found(file string, size int64) {
+	if t == nil {
+		return
+	}
+	depth := strings.Count(file, ".goscan-unar")
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.p.FilesFound++
+	t.p.BytesFound += size
+	t.p.Depth = depth
+	if depth > t.p.MaxDepth {
+		t.p.MaxDepth = depth
+	}
+}
+
+func (t *progressTracker) Unarchiving(file string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.p.Unarchiving++
+	t.mu.Unlock()
+}
+
+func (t *progressTracker) Unarchived(file string, x *archive.Extraction) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.p.Unarchiving--
+	t.p.ArchivesUnarchived++
+	if x != nil {
+		t.p.BytesUnarchived += x.BytesOut
+	}
+}
+
+// working updates the number of active workers by n.
+func (t *progressTracker) working(n int) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.p.ActiveWorkers += n
+	t.mu.Unlock()
+}
+
+// scanned counts files results were produced for, of which the first had
+// size bytes. The rest are the contents of a cached archive, whose bytes
+// were never found.
+func (t *progressTracker) scanned(files int, size int64) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.p.FilesScanned += int64(files)
+	t.p.BytesScanned += size
+	t.mu.Unlock()
+}
@@ -0,0 +1,81 @@
+package scanner
+
+import (
+	"testing"
+	"time"
+
+	"github.com/joelanford/goscan/utils/archive"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProgressTracker(t *testing.T) {
+	var last Progress
+	calls := 0
+	tr := newProgressTracker(4, time.Hour, func(p Progress) {
+		last = p
+		calls++
+	})
+	start := time.Now()
+	now := start
+	tr.start, tr.now = start, func() time.Time { return now }
+
+	tr.Found("in.tar", 1000)
+	tr.Found("in.tar.goscan-unar/a.zip", 400)
+	tr.Found("in.tar.goscan-unar/a.zip.goscan-unar/x.txt", 100)
+	tr.Found("in.tar.goscan-unar/b.txt", 100)
+	tr.Unarchiving("in.tar")
+	tr.Unarchiving("in.tar.goscan-unar/a.zip")
+	tr.Unarchived("in.tar", &archive.Extraction{BytesOut: 500})
+	tr.working(1)
+	tr.working(1)
+	tr.working(-1)
+
+	//
+	// There is no estimate until bytes have been scanned.
+	//
+	p := tr.snapshot()
+	assert.Equal(t, time.Duration(0), p.ETA)
+	assert.Equal(t, Progress{
+		FilesFound:         4,
+		BytesFound:         1600,
+		ArchivesUnarchived: 1,
+		BytesUnarchived:    500,
+		Unarchiving:        1,
+		Depth:              1,
+		MaxDepth:           2,
+		ActiveWorkers:      1,
+		Workers:            4,
+	}, p)
+
+	//
+	// 400 bytes in 10s leaves 1200 bytes for 30s. The contents of a cached
+	// archive count as files, but not as bytes.
+	//
+	now = start.Add(10 * time.Second)
+	tr.scanned(1, 400)
+	tr.scanned(3, 0)
+	p = tr.snapshot()
+	assert.Equal(t, int64(4), p.FilesScanned)
+	assert.Equal(t, int64(400), p.BytesScanned)
+	assert.Equal(t, 10*time.Second, p.Elapsed)
+	assert.Equal(t, 30*time.Second, p.ETA)
+
+	tr.Unarchived("in.tar.goscan-unar/a.zip", nil)
+	tr.scanned(1, 1200)
+	tr.stop()
+	assert.Equal(t, 1, calls)
+	assert.True(t, last.Done)
+	assert.Equal(t, 0, last.Unarchiving)
+	assert.Equal(t, int64(1600), last.BytesScanned)
+	assert.Equal(t, time.Duration(0), last.ETA)
+}
+
+func TestProgressTrackerNil(t *testing.T) {
+	var tr *progressTracker
+	tr.Found("a", 1)
+	tr.Unarchiving("a")
+	tr.Unarchived("a", nil)
+	tr.working(1)
+	tr.scanned(1, 1)
+	tr.stop()
+}
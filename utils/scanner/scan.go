@@ -0,0 +1,164 @@
+package scanner
+
+import (
+	"context"
+	"io"
+	"io/fs"
+
+	"github.com/joelanford/goscan/utils/archive"
+	"github.com/joelanford/goscan/utils/keywords"
+	"github.com/joelanford/goscan/utils/output"
+	"github.com/joelanford/goscan/utils/scratch"
+	"github.com/pkg/errors"
+)
+
+// Source is something to scan. Import brings it into the scratch space ss,
+// and returns its name within ss.FS().
+type Source interface {
+	Import(ss *scratch.Scratch) (string, error)
+}
+
+// SourceFunc adapts a function to a Source.
+type SourceFunc func(ss *scratch.Scratch) (string, error)
+
+func (f SourceFunc) Import(ss *scratch.Scratch) (string, error) {
+	return f(ss)
+}
+
+// File is the file at path on disk. It is linked or read in place if
+// possible, and copied otherwise.
+func File(path string) Source {
+	return SourceFunc(func(ss *scratch.Scratch) (string, error) {
+		return ss.ImportFile(path)
+	})
+}
+
+// CopiedFile is the file at path on disk, which is always copied into the
+// scratch space, so that it may change during the scan.
+func CopiedFile(path string) Source {
+	return SourceFunc(func(ss *scratch.Scratch) (string, error) {
+		return ss.CopyFile(path)
+	})
+}
+
+// Reader is the contents of r, which are reported as a file called name.
+func Reader(name string, r io.Reader) Source {
+	return SourceFunc(func(ss *scratch.Scratch) (string, error) {
+		return ss.CopyReader(r, name)
+	})
+}
+
+// FS is the regular files in fsys, which are reported as being in a
+// directory called name.
+func FS(name string, fsys fs.FS) Source {
+	return SourceFunc(func(ss *scratch.Scratch) (string, error) {
+		return ss.CopyFS(fsys, name)
+	})
+}
+
+// Stream is the contents of r, which can only be read once, such as stdin
+// or a named pipe. It is reported as a file called name. gzip and bzip2
+// streams are decompressed while they are copied into the scratch space,
+// rather than once they have been.
+func Stream(name string, r io.Reader) Source {
+	return &streamSource{name: name, r: r}
+}
+
+type streamSource struct {
+	name string
+	r    io.Reader
+}
+
+func (src *streamSource) Import(ss *scratch.Scratch) (string, error) {
+	return ss.CopyReader(src.r, src.name)
+}
+
+// importStream copies src into ss, and returns the extraction of its
+// contents if it was decompressed.
+func (s *Scanner) importStream(ctx context.Context, ss *scratch.Scratch, src *streamSource) (string, map[string]archive.StreamResult, error) {
+	file, err := ss.Name(src.name)
+	if err != nil {
+		return "", nil, err
+	}
+	x, err := archive.CopyStream(ctx, scratch.WithContext(ctx, ss.FS()), src.r, file, archive.Options{Extension: ".goscan-unar"})
+	if x == nil {
+		return file, nil, err
+	}
+	return file, map[string]archive.StreamResult{file: {Extraction: x, Err: err}}, nil
+}
+
+// Handler handles the results of a scan.
+type Handler interface {
+	// HandleResult is called with each result. If it returns an error,
+	// the scan stops and Scan returns that error.
+	HandleResult(output.ScanResult) error
+}
+
+// HandlerFunc adapts a function to a Handler.
+type HandlerFunc func(output.ScanResult) error
+
+func (f HandlerFunc) HandleResult(sr output.ScanResult) error {
+	return f(sr)
+}
+
+// Scratch makes the scanner use ss, which the caller has set up and tears
+// down, as its scratch space, along with its quota and manifest. Without
+// it, Scan sets up a scratch space in the base directory for each scan.
+func Scratch(ss *scratch.Scratch) Option {
+	return func(s *Scanner) error {
+		s.scratch = ss
+		s.quota = ss.Quota()
+		s.manifest = ss.Manifest()
+		return nil
+	}
+}
+
+// Scan scans src, and calls h with each result. h is called from the
+// goroutine that called Scan, one result at a time.
+//
+// Scan returns once the scan is done and every goroutine it started has
+// stopped, so h is never called after it returns. It returns the first
+// error that stopped the scan: an error returned by h, an error reading a
+// file with FailFast, or ctx.Err() if ctx was canceled.
+//
+// Scan may be called from several goroutines at once. The scans share the
+// scanner's parallelism, so that no more files than that are scanned at
+// the same time.
+//
+// Scan scans with the keywords the scanner has when it starts, even if
+// they are replaced before it is done.
+func (s *Scanner) Scan(ctx context.Context, src Source, h Handler) error {
+	return s.ScanWith(ctx, s.Keywords(), src, h)
+}
+
+// ScanWith is like Scan, but scans with kw, such as keywords returned by
+// Keywords earlier so that several scans use the same ones.
+func (s *Scanner) ScanWith(ctx context.Context, kw *keywords.Keywords, src Source, h Handler) (err error) {
+	ss := s.scratch
+	if ss == nil {
+		ss, err = scratch.New(s.baseDir)
+		if err != nil {
+			return errors.Wrap(err, "failed to initialize scratch space")
+		}
+		if err := ss.Setup(); err != nil {
+			return errors.Wrap(err, "scratch setup failed")
+		}
+		defer func() {
+			if terr := ss.Teardown(); terr != nil && err == nil {
+				err = errors.Wrap(terr, "error cleaning up scratch space")
+			}
+		}()
+	}
+
+	var file string
+	var streamed map[string]archive.StreamResult
+	if st, ok := src.(*streamSource); ok {
+		file, streamed, err = s.importStream(ctx, ss, st)
+	} else {
+		file, err = src.Import(ss)
+	}
+	if err != nil {
+		return errors.Wrap(err, "error importing scan source")
+	}
+	return s.scanFS(ctx, kw, ss.FS(), file, streamed, h.HandleResult)
+}
@@ -0,0 +1,344 @@
+package scanner_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/joelanford/goscan/utils/cache"
+	"github.com/joelanford/goscan/utils/keywords"
+	"github.com/joelanford/goscan/utils/output"
+	"github.com/joelanford/goscan/utils/scanner"
+	"github.com/joelanford/goscan/utils/scratch"
+	"github.com/stretchr/testify/assert"
+)
+
+func newScanner(t *testing.T, opts ...scanner.Option) *scanner.Scanner {
+	kw, err := keywords.LoadReader(strings.NewReader("- word: password\n"), nil)
+	assert.NoError(t, err)
+	base, err := ioutil.TempDir("", "goscan-scanner")
+	assert.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(base) })
+
+	s, err := scanner.NewScanner(kw, append([]scanner.Option{scanner.BaseDir(base), scanner.Parallelism(2)}, opts...)...)
+	assert.NoError(t, err)
+	return s
+}
+
+func TestScan(t *testing.T) {
+	s := newScanner(t)
+
+	hits := make(map[string]int)
+	err := s.Scan(context.Background(), scanner.Reader("in.txt", strings.NewReader("my password")), scanner.HandlerFunc(func(sr output.ScanResult) error {
+		hits[sr.File] = len(sr.Hits)
+		return nil
+	}))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int{"/in.txt": 1}, hits)
+
+	hits = make(map[string]int)
+	fsys := fstest.MapFS{
+		"a.txt":     {Data: []byte("password")},
+		"sub/b.txt": {Data: []byte("nothing here")},
+	}
+	err = s.Scan(context.Background(), scanner.FS("dir", fsys), scanner.HandlerFunc(func(sr output.ScanResult) error {
+		hits[sr.File] = len(sr.Hits)
+		return nil
+	}))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int{"/dir/a.txt": 1, "/dir/sub/b.txt": 0}, hits)
+}
+
+func gzipData(t *testing.T, data string) []byte {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte(data))
+	assert.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+func TestScanStream(t *testing.T) {
+	s := newScanner(t, scanner.NativeUnarchive(true))
+
+	hits := make(map[string]int)
+	err := s.Scan(context.Background(), scanner.Stream("in.txt.gz", bytes.NewReader(gzipData(t, "a password"))), scanner.HandlerFunc(func(sr output.ScanResult) error {
+		assert.Empty(t, sr.Errors)
+		hits[sr.File] = len(sr.Hits)
+		return nil
+	}))
+	assert.NoError(t, err)
+	assert.Len(t, hits, 2)
+	assert.Equal(t, 1, hits["/in.txt.gz/in.txt"])
+}
+
+func TestScanHandlerError(t *testing.T) {
+	s := newScanner(t)
+
+	fsys := fstest.MapFS{}
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		fsys[name] = &fstest.MapFile{Data: []byte("password")}
+	}
+	stop := errors.New("stop")
+	calls := 0
+	err := s.Scan(context.Background(), scanner.FS("dir", fsys), scanner.HandlerFunc(func(sr output.ScanResult) error {
+		calls++
+		return stop
+	}))
+	assert.Equal(t, stop, err)
+	assert.Equal(t, 1, calls)
+}
+
+// brokenFS fails to open the file called open, and fails to read the file
+// called read past its first 512 bytes, which is enough to tell what kind
+// of file it is.
+type brokenFS struct {
+	scratch.FS
+	open, read string
+}
+
+var errBroken = errors.New("broken")
+
+func (b brokenFS) Open(name string) (fs.File, error) {
+	if path.Base(name) == b.open {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: errBroken}
+	}
+	f, err := b.FS.Open(name)
+	if err != nil || path.Base(name) != b.read {
+		return f, err
+	}
+	return &brokenFile{File: f}, nil
+}
+
+type brokenFile struct {
+	fs.File
+	pos int64
+}
+
+func (f *brokenFile) Read(p []byte) (int, error) {
+	if f.pos >= 512 {
+		return 0, errBroken
+	}
+	n, err := f.File.Read(p)
+	f.pos += int64(n)
+	return n, err
+}
+
+func (f *brokenFile) Seek(offset int64, whence int) (int64, error) {
+	pos, err := f.File.(io.Seeker).Seek(offset, whence)
+	f.pos = pos
+	return pos, err
+}
+
+func newBrokenFS(t *testing.T, names ...string) brokenFS {
+	fsys := scratch.MemFS(16<<20, "")
+	for _, name := range names {
+		w, err := fsys.Create("in/dir/"+name, 0644, time.Now())
+		assert.NoError(t, err)
+		w.Write([]byte(strings.Repeat(" ", 1024) + "password"))
+		assert.NoError(t, w.Close())
+	}
+	return brokenFS{FS: fsys, open: "open.txt", read: "read.txt"}
+}
+
+func scanFile(s *scanner.Scanner, fsys scratch.FS) ([]output.ScanResult, error) {
+	results := make(chan output.ScanResult)
+	errChan := make(chan error, 1)
+	s.ScanFile(context.Background(), fsys, "in/dir", results, errChan)
+	var srs []output.ScanResult
+	for sr := range results {
+		srs = append(srs, sr)
+	}
+	select {
+	case err := <-errChan:
+		return srs, err
+	default:
+		return srs, nil
+	}
+}
+
+func TestScanFileErrors(t *testing.T) {
+	s := newScanner(t)
+
+	//
+	// Files that can't be opened or read are reported with their errors,
+	// and the files around them are still scanned.
+	//
+	srs, err := scanFile(s, newBrokenFS(t, "a.txt", "open.txt", "read.txt", "z.txt"))
+	assert.NoError(t, err)
+	errs := make(map[string][]output.ScanError)
+	hits := make(map[string]int)
+	for _, sr := range srs {
+		errs[sr.File], hits[sr.File] = sr.Errors, len(sr.Hits)
+	}
+	assert.Equal(t, map[string]int{"/dir/a.txt": 1, "/dir/open.txt": 0, "/dir/read.txt": 0, "/dir/z.txt": 1}, hits)
+	assert.Empty(t, errs["/dir/a.txt"])
+	assert.Empty(t, errs["/dir/z.txt"])
+	if assert.Len(t, errs["/dir/open.txt"], 1) {
+		assert.Equal(t, output.ErrorOpen, errs["/dir/open.txt"][0].Kind)
+	}
+	if assert.Len(t, errs["/dir/read.txt"], 1) {
+		assert.Equal(t, output.ErrorRead, errs["/dir/read.txt"][0].Kind)
+		assert.Contains(t, errs["/dir/read.txt"][0].Message, "broken")
+	}
+}
+
+func TestScanFailFast(t *testing.T) {
+	var names []string
+	for i := 0; i < 50; i++ {
+		names = append(names, fmt.Sprintf("%02d.txt", i))
+	}
+
+	//
+	// With a single worker, nothing after the first broken file is scanned,
+	// whether it can't be opened or can't be read.
+	//
+	for _, fsys := range []brokenFS{
+		{FS: newBrokenFS(t, names...).FS, open: "00.txt"},
+		{FS: newBrokenFS(t, names...).FS, read: "00.txt"},
+	} {
+		s := newScanner(t, scanner.FailFast(true), scanner.Parallelism(1))
+		srs, err := scanFile(s, fsys)
+		if assert.Error(t, err) {
+			assert.Contains(t, err.Error(), "broken")
+		}
+		assert.Empty(t, srs)
+	}
+}
+
+func TestScanCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goscan-cache")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	c, err := cache.Open(filepath.Join(dir, "cache.jsonl"))
+	assert.NoError(t, err)
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, err := zw.Create("inner.txt")
+	assert.NoError(t, err)
+	w.Write([]byte("a password"))
+	assert.NoError(t, zw.Close())
+
+	//
+	// a.zip.sha256 sorts between a.zip and a.zip/inner.txt by name, and
+	// mustn't keep the contents of a.zip out of its cache entry.
+	//
+	fsys := fstest.MapFS{
+		"a.zip":        {Data: buf.Bytes()},
+		"a.zip.sha256": {Data: []byte("0123 a.zip password")},
+	}
+	s := newScanner(t, scanner.NativeUnarchive(true), scanner.Cache(c))
+	scan := func() (map[string]int, int, []string) {
+		hits := make(map[string]int)
+		cached := 0
+		var archives []string
+		err := s.Scan(context.Background(), scanner.FS("d", fsys), scanner.HandlerFunc(func(sr output.ScanResult) error {
+			hits[sr.File] = len(sr.Hits)
+			if sr.Cached {
+				cached++
+			}
+			if sr.Archive {
+				archives = append(archives, sr.File)
+			}
+			return nil
+		}))
+		assert.NoError(t, err)
+		return hits, cached, archives
+	}
+	first, cached, archives := scan()
+	assert.Equal(t, map[string]int{"/d/a.zip": 1, "/d/a.zip/inner.txt": 1, "/d/a.zip.sha256": 1}, first)
+	assert.Zero(t, cached)
+	assert.Equal(t, []string{"/d/a.zip"}, archives)
+
+	//
+	// Replayed results are the same, down to which of them are archives.
+	//
+	second, cached, archives := scan()
+	assert.Equal(t, first, second)
+	assert.Equal(t, 3, cached)
+	assert.Equal(t, []string{"/d/a.zip"}, archives)
+}
+
+func TestScanDedup(t *testing.T) {
+	s := newScanner(t, scanner.NativeUnarchive(true), scanner.Dedup(true))
+
+	//
+	// corrupt.gz can't be unarchived, and its duplicate has its errors.
+	//
+	corrupt := append(gzipData(t, "a password")[:12], "not gzip"...)
+	fsys := fstest.MapFS{
+		"a.txt":          {Data: []byte("a password")},
+		"b.txt":          {Data: []byte("a password")},
+		"c.txt":          {Data: []byte("something else")},
+		"corrupt.gz":     {Data: corrupt},
+		"dup/corrupt.gz": {Data: corrupt},
+	}
+	results := make(map[string]output.ScanResult)
+	err := s.Scan(context.Background(), scanner.FS("d", fsys), scanner.HandlerFunc(func(sr output.ScanResult) error {
+		results[sr.File] = sr
+		return nil
+	}))
+	assert.NoError(t, err)
+	assert.Len(t, results, 5)
+
+	a, b := results["/d/a.txt"], results["/d/b.txt"]
+	if a.Duplicate != nil {
+		a, b = b, a
+	}
+	if assert.NotNil(t, b.Duplicate) {
+		assert.Equal(t, a.File, b.Duplicate.Of)
+		assert.Equal(t, int64(len("a password")), b.Duplicate.Size)
+	}
+	assert.Nil(t, a.Duplicate)
+	assert.Equal(t, a.Hits, b.Hits)
+	assert.Nil(t, results["/d/c.txt"].Duplicate)
+
+	orig, dup := results["/d/corrupt.gz"], results["/d/dup/corrupt.gz"]
+	assert.Nil(t, orig.Duplicate)
+	if assert.NotNil(t, dup.Duplicate) {
+		assert.Equal(t, "/d/corrupt.gz", dup.Duplicate.Of)
+	}
+	if assert.Len(t, orig.Errors, 1) {
+		assert.Equal(t, output.ErrorUnarchive, orig.Errors[0].Kind)
+	}
+	assert.Equal(t, orig.Errors, dup.Errors)
+}
+
+func TestSetKeywords(t *testing.T) {
+	s := newScanner(t)
+	old := s.Keywords()
+	kw, err := keywords.LoadReader(strings.NewReader("- word: secret\n"), nil)
+	assert.NoError(t, err)
+	assert.NotEqual(t, old.Fingerprint(), kw.Fingerprint())
+
+	count := func(kw *keywords.Keywords) int {
+		hits := 0
+		err := s.ScanWith(context.Background(), kw, scanner.Reader("in.txt", strings.NewReader("password secret")), scanner.HandlerFunc(func(sr output.ScanResult) error {
+			for _, hit := range sr.Hits {
+				if hit.Word == "secret" {
+					hits++
+				}
+			}
+			return nil
+		}))
+		assert.NoError(t, err)
+		return hits
+	}
+	s.SetKeywords(kw)
+	assert.Equal(t, kw, s.Keywords())
+	assert.Equal(t, 1, count(s.Keywords()))
+	assert.Equal(t, 0, count(old))
+}
@@ -7,10 +7,15 @@ import (
 	"runtime"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/joelanford/goscan/utils/archive"
+	"github.com/joelanford/goscan/utils/cache"
 	"github.com/joelanford/goscan/utils/keywords"
+	"github.com/joelanford/goscan/utils/metadata"
 	"github.com/joelanford/goscan/utils/output"
+	"github.com/joelanford/goscan/utils/scratch"
 	"github.com/pkg/errors"
 )
 
@@ -33,6 +38,92 @@ func HitContext(hitContext int) Option {
 	}
 }
 
+// Metadata enables recording the size, SHA-256 hash, file type, mtime and
+// mode of each scanned file.
+func Metadata(metadata bool) Option {
+	return func(s *Scanner) error {
+		s.metadata = metadata
+		return nil
+	}
+}
+
+// LegacyHashes enables recording MD5 and SHA-1 hashes in addition to
+// SHA-256 when metadata is enabled.
+func LegacyHashes(legacyHashes bool) Option {
+	return func(s *Scanner) error {
+		s.legacyHashes = legacyHashes
+		return nil
+	}
+}
+
+// FailFast makes the scan stop at the first file that can't be read, rather
+// than recording the error in that file's result and continuing.
+func FailFast(failFast bool) Option {
+	return func(s *Scanner) error {
+		s.failFast = failFast
+		return nil
+	}
+}
+
+// NativeUnarchive enables the built-in extractors for the archive formats
+// they support, rather than always using unar.
+func NativeUnarchive(native bool) Option {
+	return func(s *Scanner) error {
+		s.nativeUnarchive = native
+		return nil
+	}
+}
+
+// UnarchiveTimeout limits how long unarchiving a single archive may take.
+// A timeout of zero means no limit.
+func UnarchiveTimeout(timeout time.Duration) Option {
+	return func(s *Scanner) error {
+		if timeout < 0 {
+			return errors.New("error: unarchive timeout must be >= 0")
+		}
+		s.unarchiveTimeout = timeout
+		return nil
+	}
+}
+
+// Quota makes unarchiving respect the scratch space quota q. Files are
+// removed from the scratch space once they are scanned, so that paused
+// extractions can continue.
+func Quota(q *scratch.Quota) Option {
+	return func(s *Scanner) error {
+		s.quota = q
+		return nil
+	}
+}
+
+// Dedup makes the scanner scan and unarchive files with identical contents
+// only once. The results of duplicates refer to the first such file.
+func Dedup(dedup bool) Option {
+	return func(s *Scanner) error {
+		s.dedup = dedup
+		return nil
+	}
+}
+
+// Cache makes the scanner look up the results of files and archives in c
+// before scanning or unarchiving them, and add new results to it. The cache
+// is keyed by the keywords and the options that affect results, so results
+// are never reused after either changes.
+func Cache(c *cache.Cache) Option {
+	return func(s *Scanner) error {
+		s.cache = c
+		return nil
+	}
+}
+
+// Manifest records each scanned file and unarchived archive in m.
+func Manifest(m *scratch.Manifest) Option {
+	return func(s *Scanner) error {
+		s.manifest = m
+		return nil
+	}
+}
+
 func BaseDir(baseDir string) Option {
 	return func(s *Scanner) error {
 		s.baseDir = baseDir
@@ -51,18 +142,38 @@ func Parallelism(parallelism int) Option {
 }
 
 type Scanner struct {
-	keywords *keywords.Keywords
+	// keywords holds the *keywords.Keywords that new scans use.
+	keywords atomic.Value
+
+	hitsOnly     bool
+	hitContext   int
+	baseDir      string
+	parallelism  int
+	metadata     bool
+	legacyHashes bool
+	failFast     bool
+
+	unarchiveTimeout time.Duration
+	nativeUnarchive  bool
+	scratch          *scratch.Scratch
+	quota            *scratch.Quota
+	manifest         *scratch.Manifest
+	dedup            bool
+	cache            *cache.Cache
+
+	progressInterval time.Duration
+	progressFunc     func(Progress)
 
-	hitsOnly    bool
-	hitContext  int
-	baseDir     string
-	parallelism int
+	// sem bounds the number of files being scanned at once by all the
+	// scans of the scanner to its parallelism.
+	sem chan struct{}
 }
 
 func NewScanner(keywords *keywords.Keywords, opts ...Option) (*Scanner, error) {
+	if keywords == nil {
+		return nil, errors.New("error: keywords must not be nil")
+	}
 	s := &Scanner{
-		keywords: keywords,
-
 		hitsOnly:    true,
 		hitContext:  20,
 		baseDir:     os.TempDir(),
@@ -74,22 +185,116 @@ func NewScanner(keywords *keywords.Keywords, opts ...Option) (*Scanner, error) {
 			return nil, err
 		}
 	}
+	s.sem = make(chan struct{}, s.parallelism)
+	s.keywords.Store(keywords)
 	return s, nil
 }
 
-func (s *Scanner) ScanFile(ctx context.Context, ifile string, scanResults chan<- output.ScanResult, errChan chan<- error) error {
+// Keywords returns the keywords that scans started now use.
+func (s *Scanner) Keywords() *keywords.Keywords {
+	return s.keywords.Load().(*keywords.Keywords)
+}
+
+// SetKeywords replaces the keywords of the scanner. Scans in progress
+// finish with the keywords they started with.
+func (s *Scanner) SetKeywords(kw *keywords.Keywords) {
+	s.keywords.Store(kw)
+}
+
+// ScanFile scans ifile, a file in the scratch filesystem fsys. It returns
+// immediately. Results are sent on scanResults, which is closed once the
+// scan is done. If the scan fails or ctx is canceled, the error is sent on
+// errChan before scanResults is closed.
+//
+// Scan is easier to use correctly; ScanFile is kept for existing callers.
+func (s *Scanner) ScanFile(ctx context.Context, fsys scratch.FS, ifile string, scanResults chan<- output.ScanResult, errChan chan<- error) error {
+	go func() {
+		err := s.scanFS(ctx, s.Keywords(), fsys, ifile, nil, func(sr output.ScanResult) error {
+			scanResults <- sr
+			return nil
+		})
+		if err != nil {
+			errChan <- err
+		}
+		close(scanResults)
+	}()
+	return nil
+}
+
+// scanFS scans ifile, a file or directory in fsys, for kw, calling handle
+// with each result from the calling goroutine. streamed holds the archives
+// that were already unarchived while they were copied. It returns once
+// every goroutine it started has stopped, with the first error that
+// stopped the scan.
+func (s *Scanner) scanFS(ctx context.Context, kw *keywords.Keywords, fsys scratch.FS, ifile string, streamed map[string]archive.StreamResult, handle func(output.ScanResult) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	//
+	// Writes waiting for the scratch quota stop once the scan does.
+	//
+	fsys = scratch.WithContext(ctx, fsys)
+
+	//
+	// The first fatal error, or handler error, stops the scan.
+	//
+	var failOnce sync.Once
+	var failErr error
+	fail := func(err error) {
+		failOnce.Do(func() {
+			failErr = err
+			cancel()
+		})
+	}
+
 	//
 	// Recursively unarchive the files to be scanned
 	//
+	var d *dedup
+	opts := archive.Options{
+		Extension: ".goscan-unar",
+		Timeout:   s.unarchiveTimeout,
+		Native:    s.nativeUnarchive,
+		Quota:     s.quota,
+		Streamed:  streamed,
+	}
+	if s.dedup {
+		d = newDedup()
+		opts.Dedup = d.files
+	}
+	var rec *cacheRecorder
+	var keys cacheKeys
+	if s.cache != nil {
+		rec = &cacheRecorder{}
+		keys = s.cacheKeys(kw)
+		opts.Cache = archiveCache{cache: s.cache, keys: keys}
+	}
+	var t *progressTracker
+	if s.progressFunc != nil {
+		t = newProgressTracker(s.parallelism, s.progressInterval, s.progressFunc)
+		opts.Progress = t
+	}
+
 	unarchiveResults := make(chan archive.UnarchiveResult)
 	go func() {
-		archive.UnarchiveRecursive(ctx, ifile, ".goscan-unar", unarchiveResults)
+		archive.UnarchiveRecursive(ctx, fsys, ifile, opts, unarchiveResults)
 		close(unarchiveResults)
 	}()
 
 	//
-	// Scan unarchived files for hits
+	// Scan unarchived files for hits. Results are only sent while the scan
+	// is running, so that no worker is left blocked once it stops.
 	//
+	results := make(chan output.ScanResult)
+	send := func(sr output.ScanResult) bool {
+		select {
+		case results <- sr:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
 	var scanWg sync.WaitGroup
 	scanWg.Add(s.parallelism)
 	for i := 0; i < s.parallelism; i++ {
@@ -98,25 +303,89 @@ func (s *Scanner) ScanFile(ctx context.Context, ifile string, scanResults chan<-
 			for {
 				select {
 				case <-ctx.Done():
-					errChan <- ctx.Err()
 					return
 				case ur, ok := <-unarchiveResults:
 					if !ok {
 						return
 					}
-					if ur.Error != nil {
-						errChan <- ur.Error
+					select {
+					case s.sem <- struct{}{}:
+					case <-ctx.Done():
 						return
 					}
-					hits, err := s.keywords.MatchFile(ur.File, s.hitContext)
+					t.working(1)
+
+					var sum string
+					if d != nil || s.cache != nil {
+						sum = hashFile(fsys, ur)
+					}
+
+					var e *dedupEntry
+					if d != nil {
+						var dup bool
+						if e, dup = d.lookup(ur, sum); dup {
+							//
+							// Duplicates wait for the file they duplicate in
+							// the background, since it may still be on its
+							// way from the unarchiver.
+							//
+							scanWg.Add(1)
+							go func(ur archive.UnarchiveResult) {
+								defer scanWg.Done()
+								select {
+								case <-ctx.Done():
+								case <-e.done:
+									sr := s.duplicateResult(fsys, ifile, ur, e.result)
+									if s.sendResult(fsys, ur, sr, send) {
+										rec.add(sr, nil)
+										t.scanned(1, ur.Size)
+									}
+								}
+							}(ur)
+							t.working(-1)
+							<-s.sem
+							continue
+						}
+					}
+
+					//
+					// Cached files are neither unarchived nor scanned. Their
+					// results, and those of their contents, are replayed.
+					//
+					var sr output.ScanResult
+					var contents []output.ScanResult
+					var key *cache.Key
+					var err error
+					if ce := s.cacheEntry(keys, ur, sum); ce != nil {
+						sr, contents = s.cachedResults(fsys, ifile, ur, ce)
+					} else {
+						sr, err = s.scan(kw, fsys, ifile, ur)
+						if sum != "" {
+							k := keys.key(sum, ur.SHA256 != "")
+							key = &k
+						}
+					}
+					if e != nil {
+						e.result = sr
+						close(e.done)
+					}
+					t.working(-1)
+					<-s.sem
 					if err != nil {
-						errChan <- err
+						fail(err)
+						return
+					}
+					if !s.sendResult(fsys, ur, sr, send) {
 						return
 					}
-					scanResults <- output.ScanResult{
-						File: strings.Replace(strings.Replace(ur.File, path.Dir(ifile), "", -1), ".goscan-unar", "", -1),
-						Hits: hits,
+					rec.add(sr, key)
+					for _, r := range contents {
+						if !send(r) {
+							return
+						}
+						rec.add(r, nil)
 					}
+					t.scanned(1+len(contents), ur.Size)
 				}
 			}
 		}()
@@ -124,8 +393,207 @@ func (s *Scanner) ScanFile(ctx context.Context, ifile string, scanResults chan<-
 
 	go func() {
 		scanWg.Wait()
-		close(scanResults)
+		t.stop()
+		close(results)
 	}()
 
+	for sr := range results {
+		if ctx.Err() == nil {
+			if err := handle(sr); err != nil {
+				fail(err)
+			}
+		}
+	}
+
+	//
+	// The unarchiver stops at the next file once the scan is canceled. Its
+	// remaining results are discarded.
+	//
+	for range unarchiveResults {
+	}
+
+	if failErr != nil {
+		return failErr
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	//
+	// Only scans that weren't stopped by an error, by the handler or by
+	// a cancellation have the complete contents of their archives.
+	//
+	s.storeResults(rec)
 	return nil
 }
+
+// resultPath returns the path of file in the results, relative to the
+// directory of the input file ifile and without unarchive extensions.
+func resultPath(ifile, file string) string {
+	return strings.Replace(strings.Replace(file, path.Dir(ifile), "", -1), ".goscan-unar", "", -1)
+}
+
+// scan scans the file of ur for kw. It only returns an error if the scan
+// must stop.
+func (s *Scanner) scan(kw *keywords.Keywords, fsys scratch.FS, ifile string, ur archive.UnarchiveResult) (output.ScanResult, error) {
+	sr := output.ScanResult{
+		File:    resultPath(ifile, ur.File),
+		Hits:    make([]output.Hit, 0),
+		Archive: ur.Extraction != nil,
+	}
+
+	//
+	// Files that couldn't be opened are reported without scanning them.
+	// Archives that couldn't be unarchived are still scanned themselves.
+	//
+	if ur.Error != nil {
+		serr := newScanError(ur.Error)
+		if serr.Kind == output.ErrorOpen {
+			if s.failFast {
+				return sr, ur.Error
+			}
+			sr.Errors = append(sr.Errors, serr)
+			return sr, nil
+		}
+		sr.Errors = append(sr.Errors, serr)
+		sr.Incomplete = serr.Kind == output.ErrorLimit || serr.Kind == output.ErrorTimeout
+	}
+
+	hits, md, err := s.matchFile(kw, fsys, ur.File)
+	if err != nil {
+		if s.failFast {
+			return sr, err
+		}
+		serr := newScanError(err)
+		sr.Errors = append(sr.Errors, serr)
+		sr.Incomplete = sr.Incomplete || serr.Kind == output.ErrorLimit
+	} else {
+		sr.Hits = output.NewHits(hits)
+		sr.Metadata = md
+	}
+	return sr, nil
+}
+
+// duplicateResult returns the result of a duplicate of the file that had
+// the result orig.
+func (s *Scanner) duplicateResult(fsys scratch.FS, ifile string, ur archive.UnarchiveResult, orig output.ScanResult) output.ScanResult {
+	sr := output.ScanResult{
+		File:       resultPath(ifile, ur.File),
+		Hits:       orig.Hits,
+		Errors:     orig.Errors,
+		Incomplete: orig.Incomplete,
+		Duplicate:  &output.Duplicate{Of: orig.File},
+	}
+	info, err := fsys.Stat(ur.File)
+	if err == nil {
+		sr.Duplicate.Size = info.Size()
+	}
+	if orig.Metadata != nil {
+		md := *orig.Metadata
+		if err == nil {
+			md.ModTime = info.ModTime()
+			md.Mode = info.Mode().String()
+		}
+		sr.Metadata = &md
+	}
+	return sr
+}
+
+// sendResult records a scanned file and sends its result. It returns
+// false if the result could not be sent because the scan stopped.
+func (s *Scanner) sendResult(fsys scratch.FS, ur archive.UnarchiveResult, sr output.ScanResult, send func(output.ScanResult) bool) bool {
+	if s.manifest != nil {
+		s.addToManifest(fsys, ur, sr.File)
+	}
+
+	//
+	// With a quota, free the space used by each scanned file. Unarchived
+	// directories are left for the walk to finish.
+	//
+	if s.quota != nil {
+		if info, err := fsys.Stat(ur.File); err == nil && !info.IsDir() {
+			fsys.RemoveAll(ur.File)
+		}
+	}
+	return send(sr)
+}
+
+func (s *Scanner) addToManifest(fsys scratch.FS, ur archive.UnarchiveResult, file string) {
+	mf := scratch.ManifestFile{ScratchPath: ur.File, Path: file}
+	if info, err := fsys.Stat(ur.File); err == nil {
+		mf.Size = info.Size()
+	}
+	s.manifest.AddFile(mf)
+
+	if x := ur.Extraction; x != nil {
+		ma := scratch.ManifestArchive{
+			ScratchPath: x.OutputDir,
+			Path:        file,
+			Extractor:   x.Extractor,
+			ExitStatus:  x.ExitStatus,
+			BytesIn:     x.BytesIn,
+			BytesOut:    x.BytesOut,
+			Duration:    x.Duration.Seconds(),
+		}
+		if ur.Error != nil {
+			ma.Error = ur.Error.Error()
+		}
+		s.manifest.AddArchive(ma)
+	}
+}
+
+func (s *Scanner) matchFile(kw *keywords.Keywords, fsys scratch.FS, file string) ([]keywords.Hit, *metadata.Metadata, error) {
+	fsf, err := fsys.Open(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer fsf.Close()
+	f, ok := fsf.(metadata.File)
+	if !ok {
+		return nil, nil, errors.Errorf("%s is not seekable", file)
+	}
+
+	if !s.metadata {
+		hits, err := kw.MatchReadSeeker(f, s.hitContext)
+		if err != nil {
+			return nil, nil, readError{err}
+		}
+		return hits, nil, nil
+	}
+
+	r := metadata.NewReader(f, s.legacyHashes)
+	hits, err := kw.MatchReadSeeker(r, s.hitContext)
+	if err != nil {
+		return nil, nil, readError{err}
+	}
+	md, err := r.Metadata()
+	if err != nil {
+		return nil, nil, readError{err}
+	}
+	return hits, md, nil
+}
+
+// readError marks an error that occurred after a file was opened.
+type readError struct {
+	error
+}
+
+func newScanError(err error) output.ScanError {
+	switch err := err.(type) {
+	case *archive.UnarchiveError:
+		if err.TimedOut {
+			return output.ScanError{Kind: output.ErrorTimeout, Message: err.Error()}
+		}
+		if errors.Cause(err.Err) == scratch.ErrLimit {
+			return output.ScanError{Kind: output.ErrorLimit, Message: err.Error()}
+		}
+		return output.ScanError{Kind: output.ErrorUnarchive, Message: err.Error()}
+	case readError:
+		if errors.Cause(err.error) == scratch.ErrLimit {
+			return output.ScanError{Kind: output.ErrorLimit, Message: err.Error()}
+		}
+		return output.ScanError{Kind: output.ErrorRead, Message: err.Error()}
+	default:
+		return output.ScanError{Kind: output.ErrorOpen, Message: err.Error()}
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !linux && !darwin && !freebsd
+// +build !linux,!darwin,!freebsd
+
+package scratch
+
+import "github.com/pkg/errors"
+
+func diskFree(dir string) (int64, error) {
+	return 0, errors.New("free space checks are not supported on this platform")
+}
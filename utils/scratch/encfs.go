@@ -0,0 +1,346 @@
+package scratch
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	"io/fs"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrEncrypted is returned when a tool needs the plaintext of a file in an
+// encrypted scratch space on disk.
+var ErrEncrypted = errors.New("scratch space is encrypted")
+
+const (
+	// encChunkSize is the size of the plaintext chunks that are encrypted
+	// separately, so that files can be read at random offsets.
+	encChunkSize = 64 << 10
+
+	// encPrefixSize is the size of the random nonce prefix at the start of
+	// each file. The rest of each chunk's nonce is its index.
+	encPrefixSize = 8
+)
+
+// encFS is an FS that encrypts the files written to it with AES-GCM, using
+// a key that only exists in memory for the life of the scan.
+type encFS struct {
+	FS
+
+	mu   sync.RWMutex
+	key  []byte
+	aead cipher.AEAD
+}
+
+func newEncFS(base FS) (*encFS, error) {
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &encFS{FS: base, key: key, aead: aead}, nil
+}
+
+// destroy zeroes the key and drops the cipher, after which the files can't
+// be decrypted through e. The AES key schedule that the cipher expanded
+// from the key can't be zeroed, and stays in memory until it is garbage
+// collected and reused, along with any copy held by a file still open.
+func (e *encFS) destroy() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for i := range e.key {
+		e.key[i] = 0
+	}
+	e.key, e.aead = nil, nil
+}
+
+func (e *encFS) currentAEAD() (cipher.AEAD, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if e.aead == nil {
+		return nil, errors.New("scratch space encryption key has been destroyed")
+	}
+	return e.aead, nil
+}
+
+func (e *encFS) Open(name string) (fs.File, error) {
+	f, err := e.FS.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if info.IsDir() {
+		return f, nil
+	}
+	aead, err := e.currentAEAD()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	ra, ok := f.(io.ReaderAt)
+	if !ok {
+		f.Close()
+		return nil, errors.Errorf("%s does not support random access", name)
+	}
+	prefix := make([]byte, encPrefixSize)
+	if _, err := ra.ReadAt(prefix, 0); err != nil {
+		f.Close()
+		return nil, errors.Wrapf(err, "error reading %s", name)
+	}
+	return &encFile{
+		File:   f,
+		ra:     ra,
+		aead:   aead,
+		prefix: prefix,
+		info:   encInfo{info},
+		chunk:  -1,
+	}, nil
+}
+
+func (e *encFS) Stat(name string) (fs.FileInfo, error) {
+	info, err := e.FS.Stat(name)
+	if err != nil || info.IsDir() {
+		return info, err
+	}
+	return encInfo{info}, nil
+}
+
+func (e *encFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	entries, err := e.FS.ReadDir(name)
+	if err != nil {
+		return nil, err
+	}
+	for i, d := range entries {
+		if !d.IsDir() {
+			entries[i] = encDirEntry{d}
+		}
+	}
+	return entries, nil
+}
+
+func (e *encFS) Create(name string, mode os.FileMode, modTime time.Time) (io.WriteCloser, error) {
+	aead, err := e.currentAEAD()
+	if err != nil {
+		return nil, err
+	}
+	prefix := make([]byte, encPrefixSize)
+	if _, err := io.ReadFull(rand.Reader, prefix); err != nil {
+		return nil, err
+	}
+	w, err := e.FS.Create(name, mode, modTime)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(prefix); err != nil {
+		w.Close()
+		return nil, err
+	}
+	return &encWriter{w: w, aead: aead, prefix: prefix}, nil
+}
+
+// DiskPath fails, since the files on disk are only ciphertext.
+func (e *encFS) DiskPath(name string) (string, error) {
+	return "", errors.Wrapf(ErrEncrypted, "%s can't be written to disk in plaintext", name)
+}
+
+func encNonce(aead cipher.AEAD, prefix []byte, chunk int64) []byte {
+	nonce := make([]byte, aead.NonceSize())
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[encPrefixSize:], uint32(chunk))
+	return nonce
+}
+
+// encAdditionalData marks the last chunk of a file, so that a truncated file
+// fails to decrypt.
+func encAdditionalData(last bool) []byte {
+	if last {
+		return []byte{1}
+	}
+	return []byte{0}
+}
+
+// encPlainSize returns the plaintext size of an encrypted file of the given
+// size on disk.
+func encPlainSize(size int64) int64 {
+	sealedChunk := int64(encChunkSize + 16)
+	size -= encPrefixSize
+	if size <= 0 {
+		return 0
+	}
+	chunks := (size + sealedChunk - 1) / sealedChunk
+	return size - chunks*16
+}
+
+// encWriter encrypts a file one chunk at a time. A full chunk is only
+// written once more data arrives, since the last chunk is marked as such.
+type encWriter struct {
+	w      io.WriteCloser
+	aead   cipher.AEAD
+	prefix []byte
+	buf    []byte
+	chunk  int64
+	err    error
+}
+
+func (w *encWriter) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	n := len(p)
+	for len(p) > 0 {
+		if len(w.buf) == encChunkSize {
+			if w.err = w.seal(false); w.err != nil {
+				return 0, w.err
+			}
+		}
+		free := encChunkSize - len(w.buf)
+		if free > len(p) {
+			free = len(p)
+		}
+		w.buf = append(w.buf, p[:free]...)
+		p = p[free:]
+	}
+	return n, nil
+}
+
+func (w *encWriter) seal(last bool) error {
+	sealed := w.aead.Seal(nil, encNonce(w.aead, w.prefix, w.chunk), w.buf, encAdditionalData(last))
+	w.chunk++
+	w.buf = w.buf[:0]
+	_, err := w.w.Write(sealed)
+	return err
+}
+
+func (w *encWriter) Close() error {
+	if w.err == nil {
+		w.err = w.seal(true)
+	}
+	if err := w.w.Close(); err != nil && w.err == nil {
+		w.err = err
+	}
+	return w.err
+}
+
+// encFile decrypts a file, caching the last chunk it read.
+type encFile struct {
+	fs.File
+	ra     io.ReaderAt
+	aead   cipher.AEAD
+	prefix []byte
+	info   encInfo
+	pos    int64
+
+	chunk int64
+	plain []byte
+}
+
+func (f *encFile) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+func (f *encFile) ReadAt(p []byte, off int64) (int, error) {
+	size := f.info.Size()
+	n := 0
+	for len(p) > 0 {
+		if off >= size {
+			return n, io.EOF
+		}
+		chunk := off / encChunkSize
+		if err := f.load(chunk, size); err != nil {
+			return n, err
+		}
+		c := copy(p, f.plain[off-chunk*encChunkSize:])
+		n += c
+		off += int64(c)
+		p = p[c:]
+	}
+	return n, nil
+}
+
+func (f *encFile) load(chunk, size int64) error {
+	if chunk == f.chunk {
+		return nil
+	}
+	lastChunk := size / encChunkSize
+	if size > 0 && size%encChunkSize == 0 {
+		lastChunk--
+	}
+	plainLen := int64(encChunkSize)
+	if chunk == lastChunk {
+		plainLen = size - chunk*encChunkSize
+	}
+	sealed := make([]byte, plainLen+16)
+	if _, err := f.ra.ReadAt(sealed, encPrefixSize+chunk*(encChunkSize+16)); err != nil && err != io.EOF {
+		return err
+	}
+	plain, err := f.aead.Open(f.plain[:0], encNonce(f.aead, f.prefix, chunk), sealed, encAdditionalData(chunk == lastChunk))
+	if err != nil {
+		f.chunk = -1
+		return errors.Wrapf(err, "error decrypting %s", f.info.Name())
+	}
+	f.chunk, f.plain = chunk, plain
+	return nil
+}
+
+func (f *encFile) Read(p []byte) (int, error) {
+	n, err := f.ReadAt(p, f.pos)
+	f.pos += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+func (f *encFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.pos
+	case io.SeekEnd:
+		offset += f.info.Size()
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	f.pos = offset
+	return offset, nil
+}
+
+type encInfo struct {
+	fs.FileInfo
+}
+
+func (i encInfo) Size() int64 {
+	return encPlainSize(i.FileInfo.Size())
+}
+
+type encDirEntry struct {
+	fs.DirEntry
+}
+
+func (d encDirEntry) Info() (fs.FileInfo, error) {
+	info, err := d.DirEntry.Info()
+	if err != nil {
+		return nil, err
+	}
+	return encInfo{info}, nil
+}
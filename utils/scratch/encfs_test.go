@@ -0,0 +1,77 @@
+package scratch_test
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/joelanford/goscan/utils/scratch"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncrypt(t *testing.T) {
+	base, err := ioutil.TempDir("", "goscan-encrypt")
+	assert.NoError(t, err)
+	defer os.RemoveAll(base)
+
+	s, err := scratch.New(base, scratch.Encrypt())
+	assert.NoError(t, err)
+	assert.NoError(t, s.Setup())
+	defer s.Teardown()
+	fsys := s.FS()
+
+	for _, size := range []int{0, 1, 64 << 10, 64<<10 + 1, 200 << 10} {
+		data := bytes.Repeat([]byte("plaintext secret "), size/17+1)[:size]
+		assert.NoError(t, writeFile(fsys, "dir/file", string(data)))
+
+		info, err := fsys.Stat("dir/file")
+		assert.NoError(t, err)
+		assert.Equal(t, int64(size), info.Size())
+
+		read, err := fs.ReadFile(fsys, "dir/file")
+		assert.NoError(t, err)
+		assert.Equal(t, data, read)
+
+		//
+		// Only ciphertext is written to disk.
+		//
+		onDisk, err := ioutil.ReadFile(filepath.Join(s.Dir(), "dir", "file"))
+		assert.NoError(t, err)
+		if size > 0 {
+			assert.False(t, bytes.Contains(onDisk, []byte("plaintext")))
+		}
+
+		f, err := fsys.Open("dir/file")
+		assert.NoError(t, err)
+		rs := f.(io.ReadSeeker)
+		for i := 0; i < 10 && size > 0; i++ {
+			off := rand.Intn(size)
+			_, err := rs.Seek(int64(off), io.SeekStart)
+			assert.NoError(t, err)
+			buf := make([]byte, 100)
+			n, _ := io.ReadFull(rs, buf)
+			assert.Equal(t, data[off:off+n], buf[:n])
+		}
+		f.Close()
+	}
+
+	_, err = fsys.DiskPath("dir/file")
+	assert.Equal(t, scratch.ErrEncrypted, errors.Cause(err))
+
+	//
+	// Tampering with the ciphertext is detected.
+	//
+	path := filepath.Join(s.Dir(), "dir", "file")
+	onDisk, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	onDisk[100] ^= 1
+	assert.NoError(t, ioutil.WriteFile(path, onDisk, 0600))
+	_, err = fs.ReadFile(fsys, "dir/file")
+	assert.Error(t, err)
+}
@@ -0,0 +1,12 @@
+package scratch
+
+import "github.com/pkg/errors"
+
+// FailRamdiskMount makes mounting ramdisks fail until restore is called, so
+// that scratch spaces fall back to a shared ramdisk.
+func FailRamdiskMount() (restore func()) {
+	mount = func(dir string, size int64) (*ramdisk, error) {
+		return nil, errors.New("mount disabled for testing")
+	}
+	return func() { mount = mountRamdisk }
+}
@@ -0,0 +1,131 @@
+package scratch
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// FS is a writable filesystem that holds the files being scanned. Names are
+// slash-separated and unrooted, as in io/fs.
+type FS interface {
+	fs.StatFS
+	fs.ReadDirFS
+
+	// Create creates or truncates the named file, creating its parent
+	// directories as needed. The file's mode and modification time are set
+	// when it is closed.
+	Create(name string, mode os.FileMode, modTime time.Time) (io.WriteCloser, error)
+
+	// MkdirAll creates the named directory along with any parents.
+	MkdirAll(name string) error
+
+	// RemoveAll removes name and any children it contains.
+	RemoveAll(name string) error
+
+	// DiskPath returns the path on disk of name, for tools that can only
+	// work with real files. Files that are held in memory are written to
+	// disk first. name does not need to exist yet.
+	DiskPath(name string) (string, error)
+}
+
+// dirFS is an FS backed by a directory on disk.
+type dirFS struct {
+	root string
+}
+
+// DirFS returns an FS for the tree of files rooted at dir.
+func DirFS(dir string) FS {
+	return &dirFS{root: dir}
+}
+
+func (d *dirFS) path(op, name string) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	return filepath.Join(d.root, filepath.FromSlash(name)), nil
+}
+
+func (d *dirFS) Open(name string) (fs.File, error) {
+	p, err := d.path("open", name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(p)
+}
+
+func (d *dirFS) Stat(name string) (fs.FileInfo, error) {
+	p, err := d.path("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Stat(p)
+}
+
+func (d *dirFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	p, err := d.path("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadDir(p)
+}
+
+func (d *dirFS) Create(name string, mode os.FileMode, modTime time.Time) (io.WriteCloser, error) {
+	p, err := d.path("create", name)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0777); err != nil {
+		return nil, err
+	}
+	f, err := os.Create(p)
+	if err != nil {
+		return nil, err
+	}
+	return &diskFile{File: f, mode: mode, modTime: modTime}, nil
+}
+
+func (d *dirFS) MkdirAll(name string) error {
+	p, err := d.path("mkdir", name)
+	if err != nil {
+		return err
+	}
+	return os.MkdirAll(p, 0777)
+}
+
+func (d *dirFS) RemoveAll(name string) error {
+	p, err := d.path("remove", name)
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(p)
+}
+
+func (d *dirFS) DiskPath(name string) (string, error) {
+	return d.path("diskpath", name)
+}
+
+// diskFile sets the mode and modification time of a file on disk when it
+// is closed.
+type diskFile struct {
+	*os.File
+	mode    os.FileMode
+	modTime time.Time
+}
+
+func (f *diskFile) Close() error {
+	if err := f.File.Close(); err != nil {
+		return err
+	}
+	if f.mode != 0 {
+		if err := os.Chmod(f.Name(), f.mode.Perm()); err != nil {
+			return err
+		}
+	}
+	if !f.modTime.IsZero() {
+		return os.Chtimes(f.Name(), f.modTime, f.modTime)
+	}
+	return nil
+}
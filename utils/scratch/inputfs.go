@@ -0,0 +1,132 @@
+package scratch
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// inputFS is an FS with input files that weren't copied into it. They are
+// either links in the scratch directory that it owns, or files elsewhere
+// that are only ever read.
+type inputFS struct {
+	FS
+
+	mu    sync.Mutex
+	files map[string]inputFile
+}
+
+type inputFile struct {
+	path  string
+	owned bool
+}
+
+func (i *inputFS) add(name, p string, owned bool) error {
+	if err := i.FS.MkdirAll(path.Dir(name)); err != nil {
+		return err
+	}
+	i.mu.Lock()
+	i.files[name] = inputFile{path: p, owned: owned}
+	i.mu.Unlock()
+	return nil
+}
+
+func (i *inputFS) lookup(name string) (inputFile, bool) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	f, ok := i.files[name]
+	return f, ok
+}
+
+func (i *inputFS) Open(name string) (fs.File, error) {
+	if f, ok := i.lookup(name); ok {
+		return os.Open(f.path)
+	}
+	return i.FS.Open(name)
+}
+
+func (i *inputFS) Stat(name string) (fs.FileInfo, error) {
+	if f, ok := i.lookup(name); ok {
+		return os.Stat(f.path)
+	}
+	return i.FS.Stat(name)
+}
+
+func (i *inputFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	entries, err := i.FS.ReadDir(name)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool, len(entries))
+	for _, e := range entries {
+		seen[e.Name()] = true
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	for n, f := range i.files {
+		if path.Dir(n) != name || seen[path.Base(n)] {
+			continue
+		}
+		info, err := os.Stat(f.path)
+		if err != nil {
+			continue
+		}
+		entries = append(entries, fs.FileInfoToDirEntry(info))
+	}
+	sort.Slice(entries, func(a, b int) bool {
+		return entries[a].Name() < entries[b].Name()
+	})
+	return entries, nil
+}
+
+// Create never writes to an input file. It replaces it in the FS instead.
+func (i *inputFS) Create(name string, mode os.FileMode, modTime time.Time) (io.WriteCloser, error) {
+	return i.createContext(context.Background(), name, mode, modTime)
+}
+
+func (i *inputFS) createContext(ctx context.Context, name string, mode os.FileMode, modTime time.Time) (io.WriteCloser, error) {
+	if err := i.remove(name); err != nil {
+		return nil, err
+	}
+	return create(ctx, i.FS, name, mode, modTime)
+}
+
+func (i *inputFS) RemoveAll(name string) error {
+	if err := i.remove(name); err != nil {
+		return err
+	}
+	return i.FS.RemoveAll(name)
+}
+
+// remove forgets the input files at or below name, deleting the links
+// to them that it owns.
+func (i *inputFS) remove(name string) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	for n, f := range i.files {
+		if n != name && name != "." && !strings.HasPrefix(n, name+"/") {
+			continue
+		}
+		if f.owned {
+			if err := os.Remove(f.path); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		delete(i.files, n)
+	}
+	return nil
+}
+
+func (i *inputFS) DiskPath(name string) (string, error) {
+	if f, ok := i.lookup(name); ok {
+		return f.path, nil
+	}
+	return i.FS.DiskPath(name)
+}
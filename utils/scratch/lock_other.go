@@ -0,0 +1,24 @@
+//go:build !linux && !darwin && !freebsd
+// +build !linux,!darwin,!freebsd
+
+package scratch
+
+import "os"
+
+func lockFile(f *os.File) error {
+	return nil
+}
+
+// ownerAlive returns true if the process that owns dir still exists. Without
+// file locks, that is the best we can do.
+func ownerAlive(dir string, o *Owner) bool {
+	if o == nil {
+		return false
+	}
+	p, err := os.FindProcess(o.PID)
+	if err != nil {
+		return false
+	}
+	p.Release()
+	return true
+}
@@ -0,0 +1,25 @@
+//go:build linux || darwin || freebsd
+// +build linux darwin freebsd
+
+package scratch
+
+import (
+	"os"
+	"syscall"
+)
+
+func lockFile(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+}
+
+// ownerAlive returns true if the owner file of dir is still locked. The lock
+// is released by the kernel when its process exits, however it exits, so o
+// isn't needed.
+func ownerAlive(dir string, o *Owner) bool {
+	f, err := os.Open(dir + ownerSuffix)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB) == syscall.EWOULDBLOCK
+}
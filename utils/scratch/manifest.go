@@ -0,0 +1,109 @@
+package scratch
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// ManifestName is the name of the manifest written in a kept scratch
+// directory.
+const ManifestName = ".goscan-manifest.json"
+
+// Manifest records where the files in a kept scratch directory came from.
+type Manifest struct {
+	Dir      string            `json:"dir"`
+	Created  time.Time         `json:"created"`
+	Files    []ManifestFile    `json:"files"`
+	Archives []ManifestArchive `json:"archives"`
+
+	mu sync.Mutex
+}
+
+// ManifestFile maps a file in the scratch space to its path in the results,
+// which is its lineage through the archives it was extracted from.
+type ManifestFile struct {
+	ScratchPath string `json:"scratchPath"`
+	Path        string `json:"path"`
+	Size        int64  `json:"size"`
+}
+
+// ManifestArchive records how an archive was unarchived. ScratchPath is
+// the directory it was unarchived into.
+type ManifestArchive struct {
+	ScratchPath string  `json:"scratchPath"`
+	Path        string  `json:"path"`
+	Extractor   string  `json:"extractor"`
+	ExitStatus  int     `json:"exitStatus"`
+	Error       string  `json:"error,omitempty"`
+	BytesIn     int64   `json:"bytesIn"`
+	BytesOut    int64   `json:"bytesOut"`
+	Duration    float64 `json:"duration"`
+}
+
+// AddFile records a file. ScratchPath is its name in the scratch FS, which
+// is resolved to its path on disk when the manifest is written.
+func (m *Manifest) AddFile(f ManifestFile) {
+	m.mu.Lock()
+	m.Files = append(m.Files, f)
+	m.mu.Unlock()
+}
+
+// AddArchive records an archive, with ScratchPath as in AddFile.
+func (m *Manifest) AddArchive(a ManifestArchive) {
+	m.mu.Lock()
+	m.Archives = append(m.Archives, a)
+	m.mu.Unlock()
+}
+
+// ReadManifest reads the manifest of a kept scratch directory. path may be
+// the directory or the manifest itself.
+func ReadManifest(path string) (*Manifest, error) {
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		path = filepath.Join(path, ManifestName)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var m Manifest
+	if err := json.NewDecoder(f).Decode(&m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// write resolves the scratch paths of the manifest to paths on disk, and
+// writes it into the scratch directory.
+func (m *Manifest) write(fsys FS, dir string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.Dir = dir
+	for i, f := range m.Files {
+		if p, err := fsys.DiskPath(f.ScratchPath); err == nil {
+			m.Files[i].ScratchPath = p
+		}
+	}
+	for i, a := range m.Archives {
+		if p, err := fsys.DiskPath(a.ScratchPath); err == nil {
+			m.Archives[i].ScratchPath = p
+		}
+	}
+
+	f, err := os.Create(filepath.Join(dir, ManifestName))
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(m); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,434 @@
+package scratch
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrLimit is returned when writing to the scratch space would exceed its
+// configured size.
+var ErrLimit = errors.New("scratch space limit exceeded")
+
+// memFS is an FS that holds files in memory. Files that would take it over
+// its memory budget, and files that must be on disk for external tools, are
+// spilled to a directory on disk, if there is one.
+type memFS struct {
+	mu       sync.Mutex
+	budget   int64
+	used     int64
+	spill    FS
+	files    map[string]*memFile
+	dirs     map[string]time.Time
+	children map[string]map[string]bool
+}
+
+type memFile struct {
+	data    []byte
+	mode    os.FileMode
+	modTime time.Time
+}
+
+// MemFS returns an FS that keeps up to budget bytes of file data in memory.
+// If spillDir is not empty, files beyond the budget are written there.
+// Otherwise, exceeding the budget is an error.
+func MemFS(budget int64, spillDir string) FS {
+	if spillDir == "" {
+		return newMemFS(budget, nil)
+	}
+	return newMemFS(budget, DirFS(spillDir))
+}
+
+func newMemFS(budget int64, spill FS) *memFS {
+	return &memFS{
+		budget:   budget,
+		spill:    spill,
+		files:    make(map[string]*memFile),
+		dirs:     map[string]time.Time{".": time.Now()},
+		children: make(map[string]map[string]bool),
+	}
+}
+
+func (m *memFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	m.mu.Lock()
+	f, isFile := m.files[name]
+	_, isDir := m.dirs[name]
+	m.mu.Unlock()
+
+	switch {
+	case isFile:
+		return &memOpenFile{Reader: bytes.NewReader(f.data), info: f.info(name)}, nil
+	case isDir:
+		return &memDirFile{fs: m, name: name}, nil
+	case m.spill != nil:
+		return m.spill.Open(name)
+	}
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+}
+
+func (m *memFS) Stat(name string) (fs.FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrInvalid}
+	}
+	m.mu.Lock()
+	f, isFile := m.files[name]
+	modTime, isDir := m.dirs[name]
+	m.mu.Unlock()
+
+	switch {
+	case isFile:
+		return f.info(name), nil
+	case isDir:
+		return &memInfo{name: path.Base(name), mode: os.ModeDir | 0777, modTime: modTime}, nil
+	case m.spill != nil:
+		return m.spill.Stat(name)
+	}
+	return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+}
+
+// ReadDir merges the entries held in memory with those spilled to disk.
+func (m *memFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+	entries := make(map[string]fs.DirEntry)
+
+	m.mu.Lock()
+	_, isDir := m.dirs[name]
+	for child := range m.children[name] {
+		childName := path.Join(name, child)
+		if f, ok := m.files[childName]; ok {
+			entries[child] = fs.FileInfoToDirEntry(f.info(childName))
+		} else {
+			info := &memInfo{name: child, mode: os.ModeDir | 0777, modTime: m.dirs[childName]}
+			entries[child] = fs.FileInfoToDirEntry(info)
+		}
+	}
+	m.mu.Unlock()
+
+	if m.spill != nil {
+		spilled, err := m.spill.ReadDir(name)
+		if err != nil && !isDir {
+			return nil, err
+		}
+		for _, e := range spilled {
+			if _, ok := entries[e.Name()]; !ok {
+				entries[e.Name()] = e
+			}
+		}
+	} else if !isDir {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+
+	sorted := make([]fs.DirEntry, 0, len(entries))
+	for _, e := range entries {
+		sorted = append(sorted, e)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Name() < sorted[j].Name()
+	})
+	return sorted, nil
+}
+
+func (m *memFS) Create(name string, mode os.FileMode, modTime time.Time) (io.WriteCloser, error) {
+	if !fs.ValidPath(name) || name == "." {
+		return nil, &fs.PathError{Op: "create", Path: name, Err: fs.ErrInvalid}
+	}
+	if modTime.IsZero() {
+		modTime = time.Now()
+	}
+	return &memWriter{fs: m, name: name, mode: mode, modTime: modTime}, nil
+}
+
+func (m *memFS) MkdirAll(name string) error {
+	if !fs.ValidPath(name) {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrInvalid}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.mkdirAll(name)
+	return nil
+}
+
+func (m *memFS) mkdirAll(name string) {
+	for name != "." {
+		if _, ok := m.dirs[name]; ok {
+			return
+		}
+		m.dirs[name] = time.Now()
+		m.addChild(name)
+		name = path.Dir(name)
+	}
+}
+
+func (m *memFS) addChild(name string) {
+	dir := path.Dir(name)
+	if m.children[dir] == nil {
+		m.children[dir] = make(map[string]bool)
+	}
+	m.children[dir][path.Base(name)] = true
+}
+
+func (m *memFS) RemoveAll(name string) error {
+	if !fs.ValidPath(name) {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrInvalid}
+	}
+	m.mu.Lock()
+	m.removeAll(name)
+	m.mu.Unlock()
+	if m.spill != nil {
+		return m.spill.RemoveAll(name)
+	}
+	return nil
+}
+
+func (m *memFS) removeAll(name string) {
+	for child := range m.children[name] {
+		m.removeAll(path.Join(name, child))
+	}
+	if f, ok := m.files[name]; ok {
+		m.used -= int64(len(f.data))
+		delete(m.files, name)
+	}
+	delete(m.dirs, name)
+	delete(m.children, name)
+	if name != "." {
+		delete(m.children[path.Dir(name)], path.Base(name))
+	} else {
+		m.dirs["."] = time.Now()
+	}
+}
+
+func (m *memFS) DiskPath(name string) (string, error) {
+	if m.spill == nil {
+		return "", errors.Wrapf(ErrLimit, "%s can't be written to disk", name)
+	}
+	if err := m.spillAll(name); err != nil {
+		return "", err
+	}
+	if err := m.spill.MkdirAll(path.Dir(name)); err != nil {
+		return "", err
+	}
+	return m.spill.DiskPath(name)
+}
+
+// spillAll moves name, and anything below it, from memory to disk.
+func (m *memFS) spillAll(name string) error {
+	m.mu.Lock()
+	var names []string
+	for n := range m.files {
+		if n == name || name == "." || (len(n) > len(name) && n[:len(name)+1] == name+"/") {
+			names = append(names, n)
+		}
+	}
+	m.mu.Unlock()
+
+	for _, n := range names {
+		m.mu.Lock()
+		f, ok := m.files[n]
+		m.mu.Unlock()
+		if !ok {
+			continue
+		}
+		w, err := m.spill.Create(n, f.mode, f.modTime)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(f.data); err != nil {
+			w.Close()
+			return err
+		}
+		if err := w.Close(); err != nil {
+			return err
+		}
+		m.mu.Lock()
+		if m.files[n] == f {
+			m.used -= int64(len(f.data))
+			delete(m.files, n)
+			delete(m.children[path.Dir(n)], path.Base(n))
+		}
+		m.mu.Unlock()
+	}
+	return nil
+}
+
+// reserve accounts for n more bytes of memory, returning false if that
+// would exceed the budget.
+func (m *memFS) reserve(n int64) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.used+n > m.budget {
+		return false
+	}
+	m.used += n
+	return true
+}
+
+func (m *memFS) release(n int64) {
+	m.mu.Lock()
+	m.used -= n
+	m.mu.Unlock()
+}
+
+// memWriter buffers a file in memory until it is closed, switching to a
+// file on disk if the memory budget runs out.
+type memWriter struct {
+	fs      *memFS
+	name    string
+	mode    os.FileMode
+	modTime time.Time
+	buf     bytes.Buffer
+	disk    io.WriteCloser
+	err     error
+}
+
+func (w *memWriter) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	if w.disk != nil {
+		return w.diskWrite(p)
+	}
+	if w.fs.reserve(int64(len(p))) {
+		return w.buf.Write(p)
+	}
+	if w.fs.spill == nil {
+		w.err = errors.Wrapf(ErrLimit, "writing %s", w.name)
+		return 0, w.err
+	}
+
+	disk, err := w.fs.spill.Create(w.name, w.mode, w.modTime)
+	if err != nil {
+		w.err = err
+		return 0, err
+	}
+	w.disk = disk
+	_, err = w.diskWrite(w.buf.Bytes())
+	w.fs.release(int64(w.buf.Len()))
+	w.buf = bytes.Buffer{}
+	if err != nil {
+		return 0, err
+	}
+	return w.diskWrite(p)
+}
+
+// diskWrite writes p to the spilled file. A failed write fails the file,
+// so that Close doesn't add it truncated.
+func (w *memWriter) diskWrite(p []byte) (int, error) {
+	n, err := w.disk.Write(p)
+	if err != nil {
+		w.err = err
+	}
+	return n, err
+}
+
+// Close adds the file to the filesystem, unless a write to it failed.
+func (w *memWriter) Close() error {
+	m := w.fs
+	if w.err != nil {
+		m.release(int64(w.buf.Len()))
+		if w.disk != nil {
+			w.disk.Close()
+			m.spill.RemoveAll(w.name)
+		}
+		return w.err
+	}
+	if w.disk != nil {
+		if err := w.disk.Close(); err != nil {
+			return err
+		}
+		m.mu.Lock()
+		m.mkdirAll(path.Dir(w.name))
+		if old, ok := m.files[w.name]; ok {
+			m.used -= int64(len(old.data))
+			delete(m.files, w.name)
+			delete(m.children[path.Dir(w.name)], path.Base(w.name))
+		}
+		m.mu.Unlock()
+		return nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.mkdirAll(path.Dir(w.name))
+	if old, ok := m.files[w.name]; ok {
+		m.used -= int64(len(old.data))
+	}
+	m.files[w.name] = &memFile{data: w.buf.Bytes(), mode: w.mode, modTime: w.modTime}
+	m.addChild(w.name)
+	return nil
+}
+
+func (f *memFile) info(name string) *memInfo {
+	return &memInfo{name: path.Base(name), size: int64(len(f.data)), mode: f.mode, modTime: f.modTime}
+}
+
+type memInfo struct {
+	name    string
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
+}
+
+func (i *memInfo) Name() string       { return i.name }
+func (i *memInfo) Size() int64        { return i.size }
+func (i *memInfo) Mode() os.FileMode  { return i.mode }
+func (i *memInfo) ModTime() time.Time { return i.modTime }
+func (i *memInfo) IsDir() bool        { return i.mode.IsDir() }
+func (i *memInfo) Sys() interface{}   { return nil }
+
+type memOpenFile struct {
+	*bytes.Reader
+	info *memInfo
+}
+
+func (f *memOpenFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *memOpenFile) Close() error               { return nil }
+
+type memDirFile struct {
+	fs      *memFS
+	name    string
+	entries []fs.DirEntry
+	read    bool
+}
+
+func (d *memDirFile) Stat() (fs.FileInfo, error) { return d.fs.Stat(d.name) }
+func (d *memDirFile) Close() error               { return nil }
+
+func (d *memDirFile) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.name, Err: errors.New("is a directory")}
+}
+
+func (d *memDirFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !d.read {
+		entries, err := d.fs.ReadDir(d.name)
+		if err != nil {
+			return nil, err
+		}
+		d.entries, d.read = entries, true
+	}
+	if n <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(d.entries) {
+		n = len(d.entries)
+	}
+	entries := d.entries[:n]
+	d.entries = d.entries[n:]
+	return entries, nil
+}
@@ -0,0 +1,89 @@
+package scratch_test
+
+import (
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/joelanford/goscan/utils/scratch"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeFile(fsys scratch.FS, name string, data string) error {
+	w, err := fsys.Create(name, 0644, time.Time{})
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, data); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+func TestMemFSSpill(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goscan-memfs")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	fsys := scratch.MemFS(8, dir)
+	assert.NoError(t, writeFile(fsys, "a/small.txt", "small"))
+	assert.NoError(t, writeFile(fsys, "a/large.txt", "larger than budget"))
+
+	//
+	// Only the file that didn't fit in the budget is on disk, but both are
+	// visible through the filesystem.
+	//
+	_, err = os.Stat(filepath.Join(dir, "a", "small.txt"))
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(dir, "a", "large.txt"))
+	assert.NoError(t, err)
+
+	data, err := fs.ReadFile(fsys, "a/large.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, "larger than budget", string(data))
+
+	entries, err := fsys.ReadDir("a")
+	assert.NoError(t, err)
+	if assert.Len(t, entries, 2) {
+		assert.Equal(t, "large.txt", entries[0].Name())
+		assert.Equal(t, "small.txt", entries[1].Name())
+	}
+
+	var walked []string
+	err = fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
+		walked = append(walked, path)
+		return err
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{".", "a", "a/large.txt", "a/small.txt"}, walked)
+
+	//
+	// Asking for the disk path of a file in memory moves it to disk.
+	//
+	p, err := fsys.DiskPath("a/small.txt")
+	assert.NoError(t, err)
+	data, err = ioutil.ReadFile(p)
+	assert.NoError(t, err)
+	assert.Equal(t, "small", string(data))
+
+	assert.NoError(t, fsys.RemoveAll("a"))
+	_, err = fsys.Stat("a/small.txt")
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestMemFSNoSpill(t *testing.T) {
+	fsys := scratch.MemFS(8, "")
+	assert.NoError(t, writeFile(fsys, "small.txt", "small"))
+
+	err := writeFile(fsys, "large.txt", "larger than budget")
+	assert.Equal(t, scratch.ErrLimit, errors.Cause(err))
+
+	_, err = fsys.DiskPath("small.txt")
+	assert.Equal(t, scratch.ErrLimit, errors.Cause(err))
+}
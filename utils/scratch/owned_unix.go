@@ -0,0 +1,16 @@
+//go:build linux || darwin || freebsd
+// +build linux darwin freebsd
+
+package scratch
+
+import (
+	"os"
+	"syscall"
+)
+
+// ownedByUs returns true if the file described by info belongs to the user
+// we are running as.
+func ownedByUs(info os.FileInfo) bool {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	return !ok || int(st.Uid) == os.Getuid()
+}
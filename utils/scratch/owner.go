@@ -0,0 +1,61 @@
+package scratch
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+)
+
+// ownerSuffix is appended to the path of a scratch directory to name the
+// file recording the process that owns it. It is kept next to the directory
+// rather than in it, so that it isn't hidden by a ramdisk mounted on it.
+const ownerSuffix = ".owner"
+
+// Owner records the process that created a scratch directory.
+type Owner struct {
+	PID     int       `json:"pid"`
+	Host    string    `json:"host"`
+	Created time.Time `json:"created"`
+
+	// Ramdisk is true if a ramdisk is mounted on the directory. Device is
+	// the ramdisk's device, on platforms that need it to release it.
+	Ramdisk bool   `json:"ramdisk,omitempty"`
+	Device  string `json:"device,omitempty"`
+
+	// Kept is true if the directory was deliberately left in place.
+	Kept bool `json:"kept,omitempty"`
+}
+
+// createOwner writes the owner file of dir, and locks it for as long as the
+// returned file is open, so that the directory isn't reaped while in use.
+func createOwner(dir string, o Owner) (*os.File, error) {
+	f, err := os.OpenFile(dir+ownerSuffix, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(f); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	if err := json.NewEncoder(f).Encode(o); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	return f, nil
+}
+
+func readOwner(dir string) (*Owner, error) {
+	f, err := os.Open(dir + ownerSuffix)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var o Owner
+	if err := json.NewDecoder(f).Decode(&o); err != nil {
+		return nil, err
+	}
+	return &o, nil
+}
@@ -0,0 +1,197 @@
+package scratch
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// quotaStallTimeout is how long Wait waits for usage to go down before
+// giving up, when it isn't going down at all.
+const quotaStallTimeout = 30 * time.Second
+
+// Quota tracks the bytes written to the scratch space against a limit, and
+// the free space of the filesystem the scratch directory is on.
+type Quota struct {
+	limit   int64
+	minFree int64
+	pause   bool
+	dir     string
+
+	mu        sync.Mutex
+	used      int64
+	free      int64
+	freeCheck time.Time
+}
+
+// Used returns the number of bytes currently used in the scratch space.
+func (q *Quota) Used() int64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.used
+}
+
+// Pause returns true if writes should wait for space to be freed rather
+// than fail when the quota is exceeded.
+func (q *Quota) Pause() bool {
+	return q.pause
+}
+
+// Add accounts for n bytes written to the scratch space. n is negative when
+// files are removed.
+func (q *Quota) Add(n int64) {
+	q.mu.Lock()
+	q.used += n
+	q.mu.Unlock()
+}
+
+// Check returns an error wrapping ErrLimit if writing n more bytes would
+// exceed the quota or leave the scratch filesystem low on free space.
+func (q *Quota) Check(n int64) error {
+	if used := q.Used(); q.limit > 0 && used+n > q.limit {
+		return errors.Wrapf(ErrLimit, "scratch quota of %d MB exceeded", q.limit>>20)
+	}
+	if q.minFree > 0 && q.dir != "" {
+		if free, err := q.diskFree(); err == nil && free-n < q.minFree {
+			return errors.Wrapf(ErrLimit, "scratch filesystem has less than %d MB free", q.minFree>>20)
+		}
+	}
+	return nil
+}
+
+// diskFree returns the free space of the scratch filesystem, checking it
+// at most every 100ms since it is called for every write.
+func (q *Quota) diskFree() (int64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if time.Since(q.freeCheck) < 100*time.Millisecond {
+		return q.free, nil
+	}
+	free, err := diskFree(q.dir)
+	if err != nil {
+		return 0, err
+	}
+	q.free, q.freeCheck = free, time.Now()
+	return free, nil
+}
+
+// Wait returns once n more bytes can be written without exceeding the
+// quota. If the quota doesn't pause, or usage stops going down because
+// nothing is left to free space, it returns the error from Check instead.
+func (q *Quota) Wait(ctx context.Context, n int64) error {
+	err := q.Check(n)
+	if err == nil || !q.pause {
+		return err
+	}
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	lowest, lastProgress := q.Used(), time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+		if err = q.Check(n); err == nil {
+			return nil
+		}
+		if used := q.Used(); used < lowest {
+			lowest, lastProgress = used, time.Now()
+		} else if time.Since(lastProgress) > quotaStallTimeout {
+			return err
+		}
+	}
+}
+
+// quotaFS accounts for the files written to and removed from an FS.
+type quotaFS struct {
+	FS
+	quota *Quota
+}
+
+func (q *quotaFS) Create(name string, mode os.FileMode, modTime time.Time) (io.WriteCloser, error) {
+	return q.createContext(context.Background(), name, mode, modTime)
+}
+
+func (q *quotaFS) createContext(ctx context.Context, name string, mode os.FileMode, modTime time.Time) (io.WriteCloser, error) {
+	if err := q.quota.Wait(ctx, 0); err != nil {
+		return nil, err
+	}
+	w, err := create(ctx, q.FS, name, mode, modTime)
+	if err != nil {
+		return nil, err
+	}
+	return &quotaWriter{WriteCloser: w, quota: q.quota, ctx: ctx}, nil
+}
+
+func (q *quotaFS) RemoveAll(name string) error {
+	var size int64
+	fs.WalkDir(q.FS, name, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && d.Type().IsRegular() {
+			if info, err := d.Info(); err == nil {
+				size += info.Size()
+			}
+		}
+		return nil
+	})
+	err := q.FS.RemoveAll(name)
+	q.quota.Add(-size)
+	return err
+}
+
+type quotaWriter struct {
+	io.WriteCloser
+	quota *Quota
+	ctx   context.Context
+}
+
+func (w *quotaWriter) Write(p []byte) (int, error) {
+	if err := w.quota.Wait(w.ctx, int64(len(p))); err != nil {
+		return 0, err
+	}
+	n, err := w.WriteCloser.Write(p)
+	w.quota.Add(int64(n))
+	return n, err
+}
+
+// contextCreator is an FS that can stop waiting for space to write files
+// once a context is done.
+type contextCreator interface {
+	createContext(ctx context.Context, name string, mode os.FileMode, modTime time.Time) (io.WriteCloser, error)
+}
+
+func create(ctx context.Context, fsys FS, name string, mode os.FileMode, modTime time.Time) (io.WriteCloser, error) {
+	if c, ok := fsys.(contextCreator); ok {
+		return c.createContext(ctx, name, mode, modTime)
+	}
+	return fsys.Create(name, mode, modTime)
+}
+
+// WithContext returns fsys with files created for writing that stop
+// waiting for quota once ctx is done, so that canceling a scan doesn't
+// wait for the scratch space to free up.
+func WithContext(ctx context.Context, fsys FS) FS {
+	if _, ok := fsys.(contextCreator); !ok {
+		return fsys
+	}
+	return &contextFS{FS: fsys, ctx: ctx}
+}
+
+type contextFS struct {
+	FS
+	ctx context.Context
+}
+
+func (c *contextFS) Create(name string, mode os.FileMode, modTime time.Time) (io.WriteCloser, error) {
+	return create(c.ctx, c.FS, name, mode, modTime)
+}
+
+func (c *contextFS) createContext(ctx context.Context, name string, mode os.FileMode, modTime time.Time) (io.WriteCloser, error) {
+	return create(ctx, c.FS, name, mode, modTime)
+}
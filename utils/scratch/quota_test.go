@@ -0,0 +1,54 @@
+package scratch_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/joelanford/goscan/utils/scratch"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQuota(t *testing.T) {
+	s, err := scratch.New("", scratch.Memory(4, false), scratch.WithQuota(1, 0, false))
+	assert.NoError(t, err)
+	assert.NoError(t, s.Setup())
+	defer s.Teardown()
+
+	fsys := s.FS()
+	big := string(make([]byte, 600<<10))
+	assert.NoError(t, writeFile(fsys, "a.bin", big))
+	assert.Equal(t, int64(600<<10), s.Quota().Used())
+
+	//
+	// The second file doesn't fit until the first is removed.
+	//
+	err = writeFile(fsys, "b.bin", big)
+	assert.Equal(t, scratch.ErrLimit, errors.Cause(err))
+
+	assert.NoError(t, fsys.RemoveAll("a.bin"))
+	assert.NoError(t, writeFile(fsys, "b.bin", big))
+	assert.Equal(t, int64(600<<10), s.Quota().Used())
+}
+
+func TestQuotaPauseCancel(t *testing.T) {
+	s, err := scratch.New("", scratch.Memory(4, false), scratch.WithQuota(1, 0, true))
+	assert.NoError(t, err)
+	assert.NoError(t, s.Setup())
+	defer s.Teardown()
+
+	big := string(make([]byte, 600<<10))
+	assert.NoError(t, writeFile(s.FS(), "a.bin", big))
+
+	//
+	// A paused write stops waiting once its context is canceled, rather
+	// than when the quota gives up.
+	//
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	start := time.Now()
+	err = writeFile(scratch.WithContext(ctx, s.FS()), "b.bin", big)
+	assert.Equal(t, context.Canceled, errors.Cause(err))
+	assert.True(t, time.Since(start) < 5*time.Second)
+}
@@ -0,0 +1,67 @@
+package scratch
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// macOS has no shared ramdisk to fall back to.
+var sharedRamdisks []string
+
+type ramdisk struct {
+	dir    string
+	device string
+}
+
+func mountRamdisk(dir string, size int64) (*ramdisk, error) {
+	sectors := size / 512
+	out, err := exec.Command("hdiutil", "attach", "-nomount", fmt.Sprintf("ram://%d", sectors)).CombinedOutput()
+	if err != nil {
+		return nil, errors.Errorf("error creating ramdisk: %s", strings.TrimSpace(string(out)))
+	}
+	r := &ramdisk{dir: dir, device: strings.TrimSpace(string(out))}
+
+	if out, err := exec.Command("newfs_hfs", "-v", "goscan", r.device).CombinedOutput(); err != nil {
+		r.detach()
+		return nil, errors.Errorf("error formatting ramdisk: %s", strings.TrimSpace(string(out)))
+	}
+	if out, err := exec.Command("diskutil", "mount", "-mountPoint", dir, r.device).CombinedOutput(); err != nil {
+		r.detach()
+		return nil, errors.Errorf("error mounting ramdisk: %s", strings.TrimSpace(string(out)))
+	}
+	return r, nil
+}
+
+// staleRamdisk returns the ramdisk mounted on dir by another process, which
+// can only be released if its device is known.
+func staleRamdisk(dir, device string) *ramdisk {
+	if device == "" {
+		return nil
+	}
+	return &ramdisk{dir: dir, device: device}
+}
+
+func (r *ramdisk) deviceName() string {
+	return r.device
+}
+
+func (r *ramdisk) unmount() error {
+	if out, err := exec.Command("diskutil", "unmount", "force", r.dir).CombinedOutput(); err != nil {
+		return errors.Errorf("error unmounting ramdisk: %s", strings.TrimSpace(string(out)))
+	}
+	return r.detach()
+}
+
+func (r *ramdisk) detach() error {
+	if out, err := exec.Command("hdiutil", "detach", "-force", r.device).CombinedOutput(); err != nil {
+		return errors.Errorf("error detaching ramdisk: %s", strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func ramdiskFree(dir string) (int64, error) {
+	return diskFree(dir)
+}
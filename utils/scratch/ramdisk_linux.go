@@ -0,0 +1,65 @@
+package scratch
+
+import (
+	"fmt"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const tmpfsMagic = 0x01021994
+
+// sharedRamdisks are existing tmpfs mounts that the scratch space falls
+// back to when a ramdisk can't be mounted.
+var sharedRamdisks = []string{"/dev/shm", "/run/shm"}
+
+type ramdisk struct {
+	dir string
+}
+
+func mountRamdisk(dir string, size int64) (*ramdisk, error) {
+	data := fmt.Sprintf("size=%d,mode=0700", size)
+	if err := syscall.Mount("tmpfs", dir, "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV, data); err != nil {
+		return nil, errors.Wrapf(err, "error mounting tmpfs on %s", dir)
+	}
+	return &ramdisk{dir: dir}, nil
+}
+
+// staleRamdisk returns the ramdisk mounted on dir by another process.
+func staleRamdisk(dir, device string) *ramdisk {
+	return &ramdisk{dir: dir}
+}
+
+func (r *ramdisk) deviceName() string {
+	return ""
+}
+
+// unmount unmounts the ramdisk. Files may still be held open briefly by
+// processes that are being shut down, so it retries for a few seconds
+// before falling back to a lazy unmount.
+func (r *ramdisk) unmount() error {
+	var err error
+	for i := 0; i < 10; i++ {
+		if err = syscall.Unmount(r.dir, 0); err == nil || err == syscall.EINVAL {
+			return nil
+		}
+		if err != syscall.EBUSY {
+			return err
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+	return syscall.Unmount(r.dir, syscall.MNT_DETACH)
+}
+
+// ramdiskFree returns the free space of dir, which must be on a tmpfs.
+func ramdiskFree(dir string) (int64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return 0, err
+	}
+	if int64(st.Type) != tmpfsMagic {
+		return 0, errors.Errorf("%s is not a tmpfs", dir)
+	}
+	return int64(st.Bavail) * int64(st.Bsize), nil
+}
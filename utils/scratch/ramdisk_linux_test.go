@@ -0,0 +1,104 @@
+package scratch_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/joelanford/goscan/utils/archive"
+	"github.com/joelanford/goscan/utils/scratch"
+	pkgerrors "github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+// mounted returns true if a tmpfs is mounted on dir.
+func mounted(t *testing.T, dir string) bool {
+	data, err := ioutil.ReadFile("/proc/mounts")
+	assert.NoError(t, err)
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) > 2 && fields[1] == dir && fields[2] == "tmpfs" {
+			return true
+		}
+	}
+	return false
+}
+
+func TestRamdisk(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("mounting a ramdisk requires root")
+	}
+	base, err := ioutil.TempDir("", "goscan-ramdisk")
+	assert.NoError(t, err)
+	defer os.RemoveAll(base)
+	base, err = filepath.EvalSymlinks(base)
+	assert.NoError(t, err)
+
+	s, err := scratch.New(base, scratch.Ramdisk(1))
+	assert.NoError(t, err)
+	assert.NoError(t, s.Setup())
+	dir := s.Dir()
+	if filepath.Dir(dir) != base {
+		s.Teardown()
+		t.Skipf("ramdisk could not be mounted, fell back to %s", dir)
+	}
+	assert.True(t, mounted(t, dir), "no tmpfs on %s", dir)
+
+	//
+	// The ramdisk holds what fits in its size, and no more.
+	//
+	fsys := s.FS()
+	assert.NoError(t, writeFile(fsys, "small.bin", string(make([]byte, 512<<10))))
+	err = writeFile(fsys, "big.bin", string(make([]byte, 1<<20)))
+	if assert.Error(t, err) {
+		assert.True(t, errors.Is(err, syscall.ENOSPC), "%v", err)
+	}
+
+	assert.NoError(t, s.Teardown())
+	assert.False(t, mounted(t, dir), "tmpfs still mounted on %s", dir)
+	_, err = os.Stat(dir)
+	assert.True(t, os.IsNotExist(err), "%v", err)
+}
+
+func TestRamdiskShared(t *testing.T) {
+	defer scratch.FailRamdiskMount()()
+	base, err := ioutil.TempDir("", "goscan-ramdisk")
+	assert.NoError(t, err)
+	defer os.RemoveAll(base)
+
+	s, err := scratch.New(base, scratch.Ramdisk(1))
+	assert.NoError(t, err)
+	if err := s.Setup(); err != nil {
+		t.Skipf("no shared ramdisk: %s", err)
+	}
+	defer s.Teardown()
+	assert.False(t, strings.HasPrefix(s.Dir(), base), s.Dir())
+	if !assert.NotNil(t, s.Quota()) {
+		return
+	}
+
+	//
+	// A shared ramdisk is limited to the size of the ramdisk that couldn't
+	// be mounted, including what is extracted into it.
+	//
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write(make([]byte, 2<<20))
+	assert.NoError(t, zw.Close())
+	name, err := s.CopyReader(&buf, "zeros.gz")
+	assert.NoError(t, err)
+
+	_, err = archive.Unarchive(context.Background(), s.FS(), name, name+".out", archive.Options{Native: true, Quota: s.Quota()})
+	uerr, ok := err.(*archive.UnarchiveError)
+	if assert.True(t, ok, "%v", err) {
+		assert.Equal(t, scratch.ErrLimit, pkgerrors.Cause(uerr.Err))
+	}
+	assert.True(t, s.Quota().Used() <= 1<<20, "%d", s.Quota().Used())
+}
@@ -0,0 +1,30 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package scratch
+
+import "github.com/pkg/errors"
+
+var sharedRamdisks []string
+
+type ramdisk struct{}
+
+func mountRamdisk(dir string, size int64) (*ramdisk, error) {
+	return nil, errors.New("ramdisk scratch space is only supported on Linux and macOS")
+}
+
+func staleRamdisk(dir, device string) *ramdisk {
+	return nil
+}
+
+func (r *ramdisk) deviceName() string {
+	return ""
+}
+
+func (r *ramdisk) unmount() error {
+	return nil
+}
+
+func ramdiskFree(dir string) (int64, error) {
+	return 0, errors.New("ramdisk scratch space is only supported on Linux and macOS")
+}
@@ -0,0 +1,121 @@
+package scratch
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// scratchDirName matches the names of the directories created by Setup.
+var scratchDirName = regexp.MustCompile(`^goscan[0-9]+$`)
+
+// ownerlessMinAge is the age a scratch directory without an owner file
+// must be to be stale, whatever minimum age is asked for, since Setup
+// creates the directory before its owner file.
+const ownerlessMinAge = time.Minute
+
+// StaleDir is a scratch directory left behind by a goscan process that was
+// killed or crashed before it could remove it.
+type StaleDir struct {
+	Path string
+
+	// Owner is the process that created the directory, or nil if it has no
+	// owner file, for example because it was created by an older version.
+	Owner *Owner
+
+	Age time.Duration
+}
+
+// SearchDirs returns the directories that scratch directories created with
+// baseDir may be in.
+func SearchDirs(baseDir string) []string {
+	return append([]string{baseDir}, sharedRamdisks...)
+}
+
+// FindStale returns the scratch directories in dirs that are older than
+// minAge and whose owning process is gone. Directories owned by processes on
+// other hosts are never considered stale, since we can't tell if they are
+// still running, and neither are those of other users, which we couldn't
+// remove. Kept directories are only included if kept is true.
+func FindStale(dirs []string, minAge time.Duration, kept bool) ([]StaleDir, error) {
+	host, _ := os.Hostname()
+	var stale []StaleDir
+	for _, dir := range dirs {
+		entries, err := os.ReadDir(dir)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		//
+		// Owner files are listed too, so that one is found even if its
+		// directory was removed but it wasn't.
+		//
+		seen := make(map[string]bool)
+		for _, e := range entries {
+			name := strings.TrimSuffix(e.Name(), ownerSuffix)
+			if !scratchDirName.MatchString(name) || seen[name] {
+				continue
+			}
+			seen[name] = true
+			path := filepath.Join(dir, name)
+			if info, err := e.Info(); err != nil || !ownedByUs(info) {
+				continue
+			}
+
+			var modTime time.Time
+			if info, err := os.Stat(path); err == nil {
+				modTime = info.ModTime()
+			} else if info, err := e.Info(); err == nil {
+				modTime = info.ModTime()
+			}
+
+			owner, err := readOwner(path)
+			if err == nil {
+				if owner.Host != host || ownerAlive(path, owner) || owner.Kept && !kept {
+					continue
+				}
+				modTime = owner.Created
+			} else if !os.IsNotExist(err) {
+				//
+				// The owner file may be in the middle of being written.
+				//
+				if ownerAlive(path, nil) {
+					continue
+				}
+				owner = nil
+			}
+
+			age := time.Since(modTime)
+			if age >= minAge && (owner != nil || age >= ownerlessMinAge) {
+				stale = append(stale, StaleDir{Path: path, Owner: owner, Age: age})
+			}
+		}
+	}
+	return stale, nil
+}
+
+// Remove removes the stale directory and its owner file, unmounting the
+// ramdisk on it first if there is one.
+func (d StaleDir) Remove() error {
+	if d.Owner != nil && d.Owner.Ramdisk {
+		if rd := staleRamdisk(d.Path, d.Owner.Device); rd != nil {
+			if err := rd.unmount(); err != nil {
+				return errors.Wrapf(err, "error unmounting ramdisk on %s", d.Path)
+			}
+		}
+	}
+	if err := os.RemoveAll(d.Path); err != nil {
+		return errors.Wrapf(err, "error deleting %s", d.Path)
+	}
+	if err := os.Remove(d.Path + ownerSuffix); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package scratch_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/joelanford/goscan/utils/scratch"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFindStale(t *testing.T) {
+	base, err := ioutil.TempDir("", "goscan-reap")
+	assert.NoError(t, err)
+	defer os.RemoveAll(base)
+
+	//
+	// A scratch space that is in use is never stale.
+	//
+	s, err := scratch.New(base)
+	assert.NoError(t, err)
+	assert.NoError(t, s.Setup())
+	stale, err := scratch.FindStale([]string{base}, 0, false)
+	assert.NoError(t, err)
+	assert.Empty(t, stale)
+
+	//
+	// One whose process is gone is stale once it is old enough.
+	//
+	host, _ := os.Hostname()
+	dead := filepath.Join(base, "goscan123")
+	assert.NoError(t, os.Mkdir(dead, 0700))
+	created := time.Now().Add(-2 * time.Hour).UTC().Format(time.RFC3339)
+	owner := `{"pid":-1,"host":"` + host + `","created":"` + created + `"}`
+	assert.NoError(t, ioutil.WriteFile(dead+".owner", []byte(owner), 0600))
+
+	stale, err = scratch.FindStale([]string{base}, 3*time.Hour, false)
+	assert.NoError(t, err)
+	assert.Empty(t, stale)
+
+	stale, err = scratch.FindStale([]string{base}, time.Hour, false)
+	assert.NoError(t, err)
+	if assert.Len(t, stale, 1) {
+		assert.Equal(t, dead, stale[0].Path)
+		assert.NoError(t, stale[0].Remove())
+	}
+	_, err = os.Stat(dead)
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(dead + ".owner")
+	assert.True(t, os.IsNotExist(err))
+
+	//
+	// A directory without an owner file may be one whose owner file is
+	// about to be created, so it needs to be a minute old, whatever the
+	// minimum age.
+	//
+	ownerless := filepath.Join(base, "goscan456")
+	assert.NoError(t, os.Mkdir(ownerless, 0700))
+	stale, err = scratch.FindStale([]string{base}, 0, false)
+	assert.NoError(t, err)
+	assert.Empty(t, stale)
+
+	old := time.Now().Add(-2 * time.Minute)
+	assert.NoError(t, os.Chtimes(ownerless, old, old))
+	stale, err = scratch.FindStale([]string{base}, 0, false)
+	assert.NoError(t, err)
+	if assert.Len(t, stale, 1) {
+		assert.Equal(t, ownerless, stale[0].Path)
+		assert.Nil(t, stale[0].Owner)
+	}
+
+	//
+	// Other users' directories are left alone.
+	//
+	if os.Geteuid() == 0 {
+		assert.NoError(t, os.Chown(ownerless, 12345, 12345))
+		stale, err = scratch.FindStale([]string{base}, 0, false)
+		assert.NoError(t, err)
+		assert.Empty(t, stale)
+	}
+	assert.NoError(t, os.Remove(ownerless))
+
+	assert.NoError(t, s.Teardown())
+	entries, err := ioutil.ReadDir(base)
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+}
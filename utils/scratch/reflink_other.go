@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package scratch
+
+import "github.com/pkg/errors"
+
+func reflink(src, dst string) error {
+	return errors.New("reflinks are only supported on Linux")
+}
@@ -1,83 +1,478 @@
 package scratch
 
 import (
+	"encoding/json"
 	"io"
+	"io/fs"
 	"os"
 	"path"
+	"path/filepath"
 	"strings"
+	"time"
 
 	"io/ioutil"
 
 	"github.com/pkg/errors"
 )
 
+type Option func(*Scratch) error
+
+// Ramdisk makes the scratch space a ramdisk of the given size in MB.
+func Ramdisk(sizeMB int) Option {
+	return func(s *Scratch) error {
+		if sizeMB < 1 {
+			return errors.New("error: ramdisk size must be > 0")
+		}
+		s.ramdiskSize = int64(sizeMB) << 20
+		return nil
+	}
+}
+
+// Memory keeps up to budgetMB of scratch files in memory rather than on
+// disk. If spill is true, files that don't fit in the budget, or that need
+// to be unarchived by external tools, are written to disk. Otherwise, they
+// are errors.
+func Memory(budgetMB int, spill bool) Option {
+	return func(s *Scratch) error {
+		if budgetMB < 1 {
+			return errors.New("error: memory budget must be > 0")
+		}
+		s.memoryBudget = int64(budgetMB) << 20
+		s.memorySpill = spill
+		return nil
+	}
+}
+
+// Encrypt encrypts everything written to the scratch directory with a key
+// that is only kept in memory, and zeroed by Teardown. The cipher's
+// expanded key isn't zeroed, and stays in memory until garbage collection.
+// Since external tools can't read the files, only the built-in extractors
+// can be used.
+func Encrypt() Option {
+	return func(s *Scratch) error {
+		s.encrypt = true
+		return nil
+	}
+}
+
+// Keep makes Teardown leave the scratch directory in place, along with a
+// manifest of where its files came from, for debugging.
+func Keep() Option {
+	return func(s *Scratch) error {
+		s.manifest = &Manifest{}
+		return nil
+	}
+}
+
+// WithQuota limits the scratch space to quotaMB, and requires minFreeMB to
+// remain free on the filesystem holding the scratch directory. A limit of
+// zero disables that check. If pause is true, writes that would exceed the
+// quota wait for space to be freed instead of failing.
+func WithQuota(quotaMB, minFreeMB int, pause bool) Option {
+	return func(s *Scratch) error {
+		if quotaMB < 0 || minFreeMB < 0 {
+			return errors.New("error: scratch quota and minimum free space must be >= 0")
+		}
+		s.quota = &Quota{
+			limit:   int64(quotaMB) << 20,
+			minFree: int64(minFreeMB) << 20,
+			pause:   pause,
+		}
+		return nil
+	}
+}
+
 type Scratch struct {
 	scratchDir string
 	baseDir    string
+	fs         FS
+
+	memoryBudget int64
+	memorySpill  bool
+	quota        *Quota
+	input        *inputFS
+	encrypt      bool
+	enc          *encFS
+	manifest     *Manifest
+
+	ramdiskSize int64
+	ramdisk     *ramdisk
+
+	//
+	// owner is the locked owner file of the scratch directory, which marks
+	// it as in use until Teardown.
+	//
+	owner     *os.File
+	ownerInfo Owner
 }
 
-func New(baseDir string) *Scratch {
-	return &Scratch{
+func New(baseDir string, opts ...Option) (*Scratch, error) {
+	s := &Scratch{
 		baseDir: baseDir,
 	}
+	for _, o := range opts {
+		if err := o(s); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
 }
 
+// Dir returns the scratch directory on disk. It is empty if the scratch
+// space is in memory and never spills to disk.
 func (s *Scratch) Dir() string {
 	return s.scratchDir
 }
 
+// FS returns the filesystem holding the scratch files.
+func (s *Scratch) FS() FS {
+	return s.fs
+}
+
+// Manifest returns the manifest to record scanned files in, or nil if the
+// scratch space isn't kept.
+func (s *Scratch) Manifest() *Manifest {
+	return s.manifest
+}
+
+// Quota returns the quota of the scratch space, or nil if it has none.
+func (s *Scratch) Quota() *Quota {
+	return s.quota
+}
+
 func (s *Scratch) Setup() error {
+	if err := s.setup(); err != nil {
+		return err
+	}
+	if s.scratchDir != "" {
+		if err := s.createOwner(); err != nil {
+			s.Teardown()
+			return errors.Wrap(err, "error creating scratch directory owner file")
+		}
+	}
+	if s.quota != nil {
+		s.quota.dir = s.scratchDir
+		s.fs = &quotaFS{FS: s.fs, quota: s.quota}
+	}
+
+	//
+	// Imported input files are outside of the quota, since they don't
+	// take up any more space.
+	//
+	s.input = &inputFS{FS: s.fs, files: make(map[string]inputFile)}
+	s.fs = s.input
+	return nil
+}
+
+func (s *Scratch) setup() error {
+	if s.manifest != nil && ((s.memoryBudget > 0 && !s.memorySpill) || s.encrypt || s.ramdiskSize > 0) {
+		return errors.New("error: a scratch space can only be kept if it is unencrypted and on disk")
+	}
+
+	if s.memoryBudget > 0 && !s.memorySpill {
+		s.fs = MemFS(s.memoryBudget, "")
+		return nil
+	}
+
 	var err error
 	s.scratchDir, err = ioutil.TempDir(s.baseDir, "goscan")
 	if err != nil {
 		return err
 	}
+	if s.ramdiskSize > 0 {
+		if err := s.setupRamdisk(); err != nil {
+			os.RemoveAll(s.scratchDir)
+			s.scratchDir = ""
+			return err
+		}
+	}
+	disk := DirFS(s.scratchDir)
+	if s.encrypt {
+		if s.enc, err = newEncFS(disk); err != nil {
+			return err
+		}
+		disk = s.enc
+	}
+	if s.memoryBudget > 0 {
+		s.fs = newMemFS(s.memoryBudget, disk)
+	} else {
+		s.fs = disk
+	}
+	return nil
+}
+
+func (s *Scratch) createOwner() error {
+	host, _ := os.Hostname()
+	o := Owner{
+		PID:     os.Getpid(),
+		Host:    host,
+		Created: time.Now(),
+	}
+	if s.ramdisk != nil {
+		o.Ramdisk = true
+		o.Device = s.ramdisk.deviceName()
+	}
+	var err error
+	s.owner, err = createOwner(s.scratchDir, o)
+	s.ownerInfo = o
+	return err
+}
+
+// keep writes the manifest of the scratch directory, and marks it as kept
+// so that it isn't reaped.
+func (s *Scratch) keep() error {
+	s.manifest.Created = s.ownerInfo.Created
+	if _, err := s.fs.DiskPath("."); err != nil {
+		return errors.Wrap(err, "error writing scratch files to disk")
+	}
+	if err := s.manifest.write(s.fs, s.scratchDir); err != nil {
+		return errors.Wrap(err, "error writing scratch manifest")
+	}
+	s.fs = nil
+
+	o := s.ownerInfo
+	o.Kept = true
+	if err := s.owner.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := s.owner.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := json.NewEncoder(s.owner).Encode(o); err != nil {
+		return err
+	}
+	s.owner.Close()
+	s.owner = nil
 	return nil
 }
 
+// mount mounts a ramdisk. Tests replace it to exercise the fallback to a
+// shared ramdisk.
+var mount = mountRamdisk
+
+// setupRamdisk mounts a ramdisk on the scratch directory. If that isn't
+// possible, for example because we aren't privileged, it moves the scratch
+// directory to an existing ramdisk with enough free space instead, and
+// limits the scratch space to the size of the ramdisk with a quota.
+func (s *Scratch) setupRamdisk() error {
+	rd, mountErr := mount(s.scratchDir, s.ramdiskSize)
+	if mountErr == nil {
+		s.ramdisk = rd
+		return nil
+	}
+
+	for _, dir := range sharedRamdisks {
+		if free, err := ramdiskFree(dir); err != nil || free < s.ramdiskSize {
+			continue
+		}
+		scratchDir, err := ioutil.TempDir(dir, "goscan")
+		if err != nil {
+			continue
+		}
+		os.Remove(s.scratchDir)
+		s.scratchDir = scratchDir
+		if s.quota == nil {
+			s.quota = &Quota{}
+		}
+		if s.quota.limit == 0 || s.quota.limit > s.ramdiskSize {
+			s.quota.limit = s.ramdiskSize
+		}
+		return nil
+	}
+	return errors.Wrap(mountErr, "could not mount ramdisk and no shared ramdisk with enough free space was found")
+}
+
+// CopyReader copies r into the scratch space under a name derived from
+// name, and returns the name of the copy within FS().
 func (s *Scratch) CopyReader(r io.Reader, name string) (string, error) {
+	return s.copyReader(r, name, 0, time.Time{})
+}
+
+// Name returns the name within FS() that a copy of the input file name
+// would have, for callers that write it themselves.
+func (s *Scratch) Name(name string) (string, error) {
+	return scratchName(name)
+}
+
+// scratchName returns the name within FS() of the copy of the input file
+// name, which mirrors its absolute path.
+func scratchName(name string) (string, error) {
 	ifiledir := path.Dir(name)
 	var ofiledir string
 	if path.IsAbs(ifiledir) {
-		ofiledir = path.Clean(path.Join(s.Dir(), strings.Replace(ifiledir, ":", "_", -1)))
+		ofiledir = path.Clean(strings.Replace(ifiledir, ":", "_", -1))
 	} else {
 		cwd, err := os.Getwd()
 		if err != nil {
 			return "", err
 		}
-		ofiledir = path.Clean(path.Join(s.Dir(), strings.Replace(cwd, ":", "_", -1), ifiledir))
+		ofiledir = path.Clean(path.Join(strings.Replace(cwd, ":", "_", -1), ifiledir))
 	}
-	ofilename := path.Join(ofiledir, path.Base(name))
+	return strings.TrimPrefix(path.Join(ofiledir, path.Base(name)), "/"), nil
+}
 
-	err := os.MkdirAll(path.Dir(ofilename), 0777)
+func (s *Scratch) copyReader(r io.Reader, name string, mode os.FileMode, modTime time.Time) (string, error) {
+	ofilename, err := scratchName(name)
 	if err != nil {
 		return "", err
 	}
-	ofile, err := os.Create(ofilename)
+
+	ofile, err := s.fs.Create(ofilename, mode, modTime)
 	if err != nil {
 		return "", err
 	}
-	defer ofile.Close()
 	if _, err := io.Copy(ofile, r); err != nil {
+		ofile.Close()
+		return "", err
+	}
+	if err := ofile.Close(); err != nil {
 		return "", err
 	}
 	return ofilename, nil
 }
 
+// CopyFS copies the regular files in fsys into a directory in the scratch
+// space whose name is derived from name, and returns the name of the
+// directory within FS().
+func (s *Scratch) CopyFS(fsys fs.FS, name string) (string, error) {
+	dir, err := scratchName(name)
+	if err != nil {
+		return "", err
+	}
+	if err := s.fs.MkdirAll(dir); err != nil {
+		return "", err
+	}
+	err = fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		f, err := fsys.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = s.copyReader(f, path.Join(name, p), info.Mode(), info.ModTime())
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
 func (s *Scratch) CopyFile(ifilename string) (string, error) {
 	r, err := os.Open(ifilename)
 	if err != nil {
 		return "", err
 	}
 	defer r.Close()
-	return s.CopyReader(r, ifilename)
+
+	//
+	// Preserve the mode and mtime of the input file so that they are
+	// reported the same way as those of files extracted from archives.
+	//
+	info, err := r.Stat()
+	if err != nil {
+		return "", err
+	}
+	return s.copyReader(r, ifilename, info.Mode(), info.ModTime())
+}
+
+// ImportFile makes ifilename available in the scratch space, without copying
+// it if possible, and returns its name within FS(). It tries a reflink clone
+// and then a hard link in the scratch directory, and then reading the file in
+// place. Either way, archives are unarchived into the scratch space rather
+// than next to the input. The file is only copied if none of those work.
+func (s *Scratch) ImportFile(ifilename string) (string, error) {
+	info, err := os.Stat(ifilename)
+	if err != nil {
+		return "", err
+	}
+	if !info.Mode().IsRegular() {
+		return s.CopyFile(ifilename)
+	}
+	name, err := scratchName(ifilename)
+	if err != nil {
+		return "", err
+	}
+
+	//
+	// Links are only made when the scratch space is an unencrypted directory
+	// on disk. They fail when it is on another filesystem, such as a ramdisk.
+	//
+	if s.scratchDir != "" && s.memoryBudget == 0 && !s.encrypt {
+		dst := filepath.Join(s.scratchDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(dst), 0777); err != nil {
+			return "", err
+		}
+		if err := reflink(ifilename, dst); err == nil {
+			os.Chmod(dst, info.Mode().Perm())
+			os.Chtimes(dst, info.ModTime(), info.ModTime())
+			return name, s.input.add(name, dst, true)
+		}
+		if err := os.Link(ifilename, dst); err == nil {
+			return name, s.input.add(name, dst, true)
+		}
+	}
+
+	abs, err := filepath.Abs(ifilename)
+	if err != nil {
+		return "", err
+	}
+	if f, err := os.Open(abs); err == nil {
+		f.Close()
+		return name, s.input.add(name, abs, false)
+	}
+	return s.CopyFile(ifilename)
 }
 
 func (s *Scratch) Teardown() error {
+	if s.manifest != nil && s.fs != nil && s.owner != nil {
+		return s.keep()
+	}
+	if s.fs != nil {
+		s.fs.RemoveAll(".")
+		s.fs = nil
+	}
+	if s.enc != nil {
+		s.enc.destroy()
+		s.enc = nil
+	}
+	if s.scratchDir == "" {
+		return nil
+	}
 	err := os.RemoveAll(s.scratchDir)
+	if s.ramdisk != nil {
+		//
+		// Even if removing the contents failed, always try to unmount the
+		// ramdisk so that its memory is released.
+		//
+		if uerr := s.ramdisk.unmount(); uerr != nil {
+			return errors.Wrap(uerr, "error unmounting ramdisk")
+		}
+		s.ramdisk = nil
+		err = os.RemoveAll(s.scratchDir)
+	}
 	if err != nil {
 		return errors.Wrap(err, "error deleting temporary directory")
 	}
+
+	//
+	// The owner file is only removed along with the directory, so that a
+	// directory we failed to remove can still be reaped later.
+	//
+	if s.owner != nil {
+		s.owner.Close()
+		os.Remove(s.owner.Name())
+		s.owner = nil
+	}
 	s.scratchDir = ""
 	return nil
 }
@@ -0,0 +1,78 @@
+package scratch_test
+
+import (
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/joelanford/goscan/utils/scratch"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImportFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goscan-import")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	input := filepath.Join(dir, "input.txt")
+	assert.NoError(t, ioutil.WriteFile(input, []byte("input data"), 0644))
+
+	for _, opts := range [][]scratch.Option{nil, {scratch.Memory(1, false)}} {
+		s, err := scratch.New(dir, opts...)
+		assert.NoError(t, err)
+		assert.NoError(t, s.Setup())
+
+		name, err := s.ImportFile(input)
+		assert.NoError(t, err)
+		data, err := fs.ReadFile(s.FS(), name)
+		assert.NoError(t, err)
+		assert.Equal(t, "input data", string(data))
+
+		//
+		// Replacing or removing the imported file never touches the input.
+		//
+		assert.NoError(t, writeFile(s.FS(), name, "replaced"))
+		assert.NoError(t, s.FS().RemoveAll(name))
+		assert.NoError(t, s.Teardown())
+
+		data, err = ioutil.ReadFile(input)
+		assert.NoError(t, err)
+		assert.Equal(t, "input data", string(data))
+	}
+}
+
+func TestKeep(t *testing.T) {
+	base, err := ioutil.TempDir("", "goscan-keep")
+	assert.NoError(t, err)
+	defer os.RemoveAll(base)
+
+	s, err := scratch.New(base, scratch.Memory(1, true), scratch.Keep())
+	assert.NoError(t, err)
+	assert.NoError(t, s.Setup())
+	assert.NoError(t, writeFile(s.FS(), "a/file.txt", "kept"))
+	s.Manifest().AddFile(scratch.ManifestFile{ScratchPath: "a/file.txt", Path: "/file.txt", Size: 4})
+	assert.NoError(t, s.Teardown())
+
+	//
+	// Files held in memory are written to disk, and the manifest maps them
+	// to their paths there.
+	//
+	m, err := scratch.ReadManifest(s.Dir())
+	assert.NoError(t, err)
+	if assert.Len(t, m.Files, 1) {
+		data, err := ioutil.ReadFile(m.Files[0].ScratchPath)
+		assert.NoError(t, err)
+		assert.Equal(t, "kept", string(data))
+	}
+
+	//
+	// Kept directories are only reaped when asked for.
+	//
+	stale, err := scratch.FindStale([]string{base}, 0, false)
+	assert.NoError(t, err)
+	assert.Empty(t, stale)
+	stale, err = scratch.FindStale([]string{base}, 0, true)
+	assert.NoError(t, err)
+	assert.Len(t, stale, 1)
+}
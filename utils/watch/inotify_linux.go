@@ -0,0 +1,125 @@
+package watch
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"github.com/pkg/errors"
+)
+
+const inotifyMask = syscall.IN_CREATE | syscall.IN_MODIFY | syscall.IN_CLOSE_WRITE | syscall.IN_MOVED_TO
+
+// notify sends the changes in the tree with inotify, unless polling was
+// asked for.
+func (w *Watcher) notify(ctx context.Context, changes chan<- change) error {
+	if w.pollInterval > 0 {
+		return w.poll(ctx, changes)
+	}
+
+	//
+	// A non-blocking inotify file can be read through the runtime poller,
+	// so that closing it stops the read below when ctx is done.
+	//
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return errors.Wrap(os.NewSyscallError("inotify_init1", err), "error setting up inotify")
+	}
+	f := os.NewFile(uintptr(fd), "inotify")
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-stop:
+		}
+		f.Close()
+	}()
+
+	//
+	// Directories are watched one by one, so new directories are added as
+	// they appear, along with whatever was written to them before that.
+	//
+	dirs := make(map[int32]string)
+	addTree := func(root string, report bool) error {
+		return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				if path == root {
+					return err
+				}
+				return nil
+			}
+			if info.IsDir() {
+				if w.excluded(path) {
+					return filepath.SkipDir
+				}
+				wd, err := syscall.InotifyAddWatch(fd, path, inotifyMask)
+				if err != nil {
+					if path == root {
+						return errors.Wrapf(os.NewSyscallError("inotify_add_watch", err), "error watching %s", path)
+					}
+					return nil
+				}
+				dirs[int32(wd)] = path
+				return nil
+			}
+			if report && info.Mode().IsRegular() {
+				send(ctx, changes, change{path: path, closed: true})
+			}
+			return nil
+		})
+	}
+	if err := addTree(w.dir, w.existing); err != nil {
+		return err
+	}
+
+	buf := make([]byte, 64<<10)
+	for {
+		n, err := f.Read(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return errors.Wrap(err, "error reading inotify events")
+		}
+		for off := 0; off+syscall.SizeofInotifyEvent <= n; {
+			ev := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[off]))
+			start := off + syscall.SizeofInotifyEvent
+			off = start + int(ev.Len)
+			name := strings.TrimRight(string(buf[start:off]), "\x00")
+
+			//
+			// Events were dropped, so the whole tree is reported again.
+			//
+			if ev.Mask&syscall.IN_Q_OVERFLOW != 0 {
+				addTree(w.dir, true)
+				continue
+			}
+			dir, ok := dirs[ev.Wd]
+			if !ok {
+				continue
+			}
+			if ev.Mask&syscall.IN_IGNORED != 0 {
+				delete(dirs, ev.Wd)
+				continue
+			}
+			if name == "" {
+				continue
+			}
+			path := filepath.Join(dir, name)
+			if ev.Mask&syscall.IN_ISDIR != 0 {
+				if ev.Mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0 {
+					addTree(path, true)
+				}
+				continue
+			}
+			send(ctx, changes, change{
+				path:   path,
+				closed: ev.Mask&(syscall.IN_CLOSE_WRITE|syscall.IN_MOVED_TO) != 0,
+			})
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package watch
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Settle calls fn, and calls it again for as long as the file at path
+// changed while it ran, so that what fn saw of the file is what it holds
+// once Settle returns. It returns the error from fn, or an error if the
+// file can't be found before fn is called.
+func Settle(path string, fn func() error) error {
+	for {
+		before, err := stampFile(path)
+		if err != nil {
+			return err
+		}
+		if err := fn(); err != nil {
+			return err
+		}
+		after, err := stampFile(path)
+		if err != nil || after == before {
+			return nil
+		}
+	}
+}
+
+// Move moves the file at path in the watched directory root to the same
+// place under dir, and returns where it went. A file that is already there
+// is kept, and the moved file is given a suffix.
+func Move(root, path, dir string) (string, error) {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return "", err
+	}
+	dest := filepath.Join(dir, rel)
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return "", err
+	}
+	if _, err := os.Lstat(dest); err == nil {
+		dest += "." + time.Now().Format("20060102T150405.000000000")
+	}
+	return dest, os.Rename(path, dest)
+}
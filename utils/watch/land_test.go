@@ -0,0 +1,97 @@
+package watch_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/joelanford/goscan/utils/watch"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSettle(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goscan-watch")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "a.txt")
+	assert.NoError(t, ioutil.WriteFile(path, []byte("a"), 0644))
+
+	//
+	// A file that changes during the first call is seen again by a second.
+	//
+	var seen []string
+	err = watch.Settle(path, func() error {
+		data, err := ioutil.ReadFile(path)
+		assert.NoError(t, err)
+		seen = append(seen, string(data))
+		if len(seen) == 1 {
+			return ioutil.WriteFile(path, []byte("a longer a"), 0644)
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "a longer a"}, seen)
+
+	//
+	// Errors from fn stop it, and so does a file that is gone before it is
+	// called. A file that is gone after is left to whoever called Settle.
+	//
+	calls := 0
+	stop := errors.New("stop")
+	assert.Equal(t, stop, watch.Settle(path, func() error {
+		calls++
+		ioutil.WriteFile(path, []byte("changed"), 0644)
+		return stop
+	}))
+	assert.Equal(t, 1, calls)
+	assert.NoError(t, watch.Settle(path, func() error {
+		calls++
+		return os.Remove(path)
+	}))
+	assert.Equal(t, 2, calls)
+	assert.Error(t, watch.Settle(path, func() error {
+		calls++
+		return nil
+	}))
+	assert.Equal(t, 2, calls)
+}
+
+func TestMove(t *testing.T) {
+	root, err := ioutil.TempDir("", "goscan-watch")
+	assert.NoError(t, err)
+	defer os.RemoveAll(root)
+	dest := filepath.Join(root, "clean")
+
+	land := func(name, data string) string {
+		path := filepath.Join(root, name)
+		assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		assert.NoError(t, ioutil.WriteFile(path, []byte(data), 0644))
+		return path
+	}
+
+	//
+	// Files keep their place in the tree, and a file that lands under the
+	// same name as one that was moved before doesn't replace it.
+	//
+	moved, err := watch.Move(root, land("sub/a.txt", "first"), dest)
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(dest, "sub", "a.txt"), moved)
+
+	again, err := watch.Move(root, land("sub/a.txt", "second"), dest)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(again, moved+"."), again)
+
+	for path, data := range map[string]string{moved: "first", again: "second"} {
+		got, err := ioutil.ReadFile(path)
+		assert.NoError(t, err)
+		assert.Equal(t, data, string(got))
+	}
+	_, err = os.Stat(filepath.Join(root, "sub", "a.txt"))
+	assert.True(t, os.IsNotExist(err))
+
+	_, err = watch.Move(root, filepath.Join(root, "missing.txt"), dest)
+	assert.Error(t, err)
+}
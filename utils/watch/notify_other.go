@@ -0,0 +1,12 @@
+//go:build !linux
+// +build !linux
+
+package watch
+
+import "context"
+
+// notify sends the changes in the tree by polling it, since inotify is
+// only available on Linux.
+func (w *Watcher) notify(ctx context.Context, changes chan<- change) error {
+	return w.poll(ctx, changes)
+}
@@ -0,0 +1,282 @@
+// Package watch reports the files that are written to a directory tree
+// once they have been fully written.
+package watch
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type Option func(*Watcher) error
+
+// Debounce is how long a file must be left alone, once it has been closed,
+// before it is reported.
+func Debounce(d time.Duration) Option {
+	return func(w *Watcher) error {
+		if d <= 0 {
+			return errors.New("error: debounce must be > 0")
+		}
+		w.debounce = d
+		return nil
+	}
+}
+
+// Poll makes the watcher look for changes by walking the tree every
+// interval, rather than with inotify. This works on any filesystem, such
+// as network filesystems where inotify doesn't see changes made by other
+// hosts. It is always used where inotify isn't available.
+func Poll(interval time.Duration) Option {
+	return func(w *Watcher) error {
+		if interval < 0 {
+			return errors.New("error: poll interval must be >= 0")
+		}
+		w.pollInterval = interval
+		return nil
+	}
+}
+
+// Existing makes the watcher also report the files that are already in the
+// tree when it starts.
+func Existing(existing bool) Option {
+	return func(w *Watcher) error {
+		w.existing = existing
+		return nil
+	}
+}
+
+// Exclude makes the watcher ignore the directories dirs and everything in
+// them.
+func Exclude(dirs ...string) Option {
+	return func(w *Watcher) error {
+		for _, dir := range dirs {
+			abs, err := filepath.Abs(dir)
+			if err != nil {
+				return err
+			}
+			w.exclude[abs] = true
+		}
+		return nil
+	}
+}
+
+// Watcher watches a directory tree for files that are created, modified,
+// or moved into it.
+type Watcher struct {
+	dir          string
+	debounce     time.Duration
+	pollInterval time.Duration
+	existing     bool
+	exclude      map[string]bool
+}
+
+// defaultPollInterval is the poll interval where inotify isn't available
+// and none was set.
+const defaultPollInterval = 2 * time.Second
+
+func New(dir string, opts ...Option) (*Watcher, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(abs)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, errors.Errorf("%s is not a directory", dir)
+	}
+	w := &Watcher{
+		dir:      abs,
+		debounce: 2 * time.Second,
+		exclude:  make(map[string]bool),
+	}
+	for _, o := range opts {
+		if err := o(w); err != nil {
+			return nil, err
+		}
+	}
+	return w, nil
+}
+
+// change is a notification that the file at path changed. closed is set
+// when the writer of the file is known to have closed it, or to have moved
+// it into place.
+type change struct {
+	path   string
+	closed bool
+}
+
+// pendingFile is a file that changed, and hasn't been reported yet.
+type pendingFile struct {
+	stamp  stamp
+	last   time.Time
+	closed bool
+}
+
+// Run watches the tree until ctx is done, and calls fn with the path of
+// each file that is created, modified or moved into it, once it has been
+// left alone for the debounce period. fn is called from the goroutine that
+// called Run, one file at a time, so it should hand long work off.
+//
+// A file is only reported once its writer has closed it, when the watcher
+// can tell. Files that are kept open without being written to are reported
+// after ten debounce periods, in case they are never closed.
+func (w *Watcher) Run(ctx context.Context, fn func(path string)) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	changes := make(chan change, 256)
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- w.notify(ctx, changes)
+	}()
+
+	tick := w.debounce / 4
+	if tick < 10*time.Millisecond {
+		tick = 10 * time.Millisecond
+	}
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+
+	pending := make(map[string]*pendingFile)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-errChan:
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		case c := <-changes:
+			p, ok := pending[c.path]
+			if !ok {
+				p = &pendingFile{}
+				p.stamp, _ = stampFile(c.path)
+				pending[c.path] = p
+			}
+			p.last = time.Now()
+			p.closed = c.closed
+		case now := <-ticker.C:
+			for path, p := range pending {
+				quiet := now.Sub(p.last)
+				if quiet < w.debounce || (!p.closed && quiet < 10*w.debounce) {
+					continue
+				}
+
+				//
+				// Files can change without the watcher being told, such as
+				// when they are polled, so they must also look unchanged.
+				//
+				st, err := stampFile(path)
+				if err != nil {
+					delete(pending, path)
+					continue
+				}
+				if st != p.stamp {
+					p.stamp = st
+					p.last = now
+					continue
+				}
+				delete(pending, path)
+				fn(path)
+			}
+		}
+	}
+}
+
+func (w *Watcher) excluded(path string) bool {
+	return w.exclude[path]
+}
+
+// send sends c on changes, unless ctx is done first.
+func send(ctx context.Context, changes chan<- change, c change) {
+	select {
+	case changes <- c:
+	case <-ctx.Done():
+	}
+}
+
+// stamp identifies a version of a file.
+type stamp struct {
+	size    int64
+	modTime int64
+}
+
+// stampFile returns the stamp of the regular file at path, or an error if
+// there is none.
+func stampFile(path string) (stamp, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return stamp{}, err
+	}
+	if !info.Mode().IsRegular() {
+		return stamp{}, errors.Errorf("%s is not a regular file", path)
+	}
+	return stamp{size: info.Size(), modTime: info.ModTime().UnixNano()}, nil
+}
+
+// poll sends the changes in the tree by walking it every poll interval.
+func (w *Watcher) poll(ctx context.Context, changes chan<- change) error {
+	interval := w.pollInterval
+	if interval == 0 {
+		interval = defaultPollInterval
+	}
+	stamps := make(map[string]stamp)
+	walk := func(report bool) error {
+		seen := make(map[string]bool)
+		err := filepath.Walk(w.dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				if path == w.dir {
+					return err
+				}
+				return nil
+			}
+			if info.IsDir() {
+				if w.excluded(path) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !info.Mode().IsRegular() {
+				return nil
+			}
+			seen[path] = true
+			st := stamp{size: info.Size(), modTime: info.ModTime().UnixNano()}
+			if old, ok := stamps[path]; ok && old == st {
+				return nil
+			}
+			stamps[path] = st
+			if report {
+				send(ctx, changes, change{path: path, closed: true})
+			}
+			return nil
+		})
+		for path := range stamps {
+			if !seen[path] {
+				delete(stamps, path)
+			}
+		}
+		return err
+	}
+
+	if err := walk(w.existing); err != nil {
+		return err
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := walk(true); err != nil {
+				return err
+			}
+		}
+	}
+}
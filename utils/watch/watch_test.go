@@ -0,0 +1,71 @@
+package watch_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/joelanford/goscan/utils/watch"
+	"github.com/stretchr/testify/assert"
+)
+
+func testWatch(t *testing.T, opts ...watch.Option) {
+	dir, err := ioutil.TempDir("", "goscan-watch")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "old.txt"), []byte("old"), 0644))
+	assert.NoError(t, os.Mkdir(filepath.Join(dir, "skip"), 0755))
+
+	w, err := watch.New(dir, append([]watch.Option{watch.Debounce(50 * time.Millisecond), watch.Exclude(filepath.Join(dir, "skip"))}, opts...)...)
+	assert.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	paths := make(chan string, 10)
+	go w.Run(ctx, func(path string) {
+		paths <- path
+	})
+	time.Sleep(100 * time.Millisecond)
+
+	//
+	// A file that is written in several goes is reported once, and so is
+	// a file in a new directory. Excluded directories are ignored.
+	//
+	f, err := os.Create(filepath.Join(dir, "new.txt"))
+	assert.NoError(t, err)
+	f.WriteString("new")
+	time.Sleep(20 * time.Millisecond)
+	f.WriteString(" data")
+	f.Close()
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "sub"), 0755))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "sub", "a.txt"), []byte("a"), 0644))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "skip", "b.txt"), []byte("b"), 0644))
+
+	got := make(map[string]int)
+	timeout := time.After(2 * time.Second)
+	for len(got) < 2 {
+		select {
+		case p := <-paths:
+			rel, _ := filepath.Rel(dir, p)
+			got[rel]++
+		case <-timeout:
+			t.Fatalf("timed out with %v", got)
+		}
+	}
+	select {
+	case p := <-paths:
+		t.Fatalf("unexpected %s", p)
+	case <-time.After(300 * time.Millisecond):
+	}
+	assert.Equal(t, map[string]int{"new.txt": 1, filepath.Join("sub", "a.txt"): 1}, got)
+}
+
+func TestWatch(t *testing.T) {
+	testWatch(t)
+}
+
+func TestWatchPoll(t *testing.T) {
+	testWatch(t, watch.Poll(20*time.Millisecond))
+}